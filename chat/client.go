@@ -0,0 +1,215 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This is a struct that gets only what
+// we need from the incoming JSON payload
+type incomingJSON struct {
+	Data    string `json:"data"`
+	Token   string `json:"token"`
+	Trigger string `json:"trigger"`
+	Message struct {
+		SenderID         int         `json:"sender_id"`
+		DisplayRecipient interface{} `json:"display_recipient"`
+		SenderEmail      string      `json:"sender_email"`
+		SenderFullName   string      `json:"sender_full_name"`
+	} `json:"message"`
+}
+
+// UserDataFromJSON is who sent a command. UserID has the platform prefix on
+// it for everyone but Zulip users
+type UserDataFromJSON struct {
+	UserID    string
+	UserEmail string
+	UserName  string
+}
+
+// Zulip has to get JSON back from the bot,
+// this does that. An empty message field stops
+// zulip from throwing an error at the user that
+// messaged the bot, but doesn't send a response
+type BotResponse struct {
+	Message string `json:"content"`
+}
+
+// BotNoResponse tells Zulip we're not answering, so it doesn't show an error
+type BotNoResponse struct {
+	Message bool `json:"response_not_required"`
+}
+
+// UserRequest is a webhook request from Zulip, read and checked a bit at a time
+type UserRequest interface {
+	// ValidateJSON reads the request body, and fails if it isn't JSON we know
+	ValidateJSON(r *http.Request) error
+	// ValidateAuthCreds is whether the request has our webhook token in it
+	ValidateAuthCreds(tokenFromDB string) bool
+	// ValidateInteractionType is what to say to a message that isn't a private
+	// one, or nil if it is
+	ValidateInteractionType() *BotResponse
+	// IgnoreInteractionType is non-nil for messages we shouldn't answer at all,
+	// like ones in the group chats we start for matches
+	IgnoreInteractionType() *BotNoResponse
+	// the text of the command, for ParseCmd
+	UserInput() string
+	ExtractUserData() *UserDataFromJSON // does this need an error return value? anything that hasn't been validated previously?
+}
+
+// UserNotification sends private messages. user is whatever the implementation
+// addresses people by: an email on Zulip, a prefixed ID on other platforms,
+// or a comma-separated group of them
+type UserNotification interface {
+	SendUserMessage(ctx context.Context, botPassword, user, message string) error
+}
+
+// StreamNotification posts to a stream (or channel), under a topic
+type StreamNotification interface {
+	SendStreamMessage(ctx context.Context, botPassword, stream, topic, message string) error
+}
+
+// implements UserRequest
+type ZulipUserRequest struct {
+	json incomingJSON
+}
+
+// implements UserNotification and StreamNotification
+type ZulipUserNotification struct {
+	BotUsername string
+	ZulipAPIURL string
+	// nil means DefaultHTTPClient
+	client *http.Client
+	// shared by every message we send, so a whole batch stays under Zulip's rate limit
+	limiter *RateLimiter
+	// how many times to retry when Zulip is down or rate-limits us
+	MaxRetries  int
+	BaseBackoff time.Duration
+}
+
+// DefaultHTTPClient is what we talk to Zulip, the other chat platforms and
+// the directory with, when nothing else is set
+var DefaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// SendUserMessage sends a private message, retrying with backoff when the
+// failure looks temporary (network errors, 5xx responses and rate limiting)
+func (zun *ZulipUserNotification) SendUserMessage(ctx context.Context, botPassword, user, message string) error {
+	messageRequest := url.Values{}
+	messageRequest.Add("type", "private")
+	messageRequest.Add("to", user)
+	messageRequest.Add("content", message)
+
+	return withRetries(ctx, zun.limiter, zun.MaxRetries, zun.BaseBackoff, "message to "+user, func() error {
+		return zun.send(ctx, botPassword, messageRequest)
+	})
+}
+
+// SendStreamMessage posts a message to a stream, retrying the same way
+func (zun *ZulipUserNotification) SendStreamMessage(ctx context.Context, botPassword, stream, topic, message string) error {
+	messageRequest := url.Values{}
+	messageRequest.Add("type", "stream")
+	messageRequest.Add("to", stream)
+	messageRequest.Add("topic", topic)
+	messageRequest.Add("content", message)
+
+	return withRetries(ctx, zun.limiter, zun.MaxRetries, zun.BaseBackoff, "message to #"+stream, func() error {
+		return zun.send(ctx, botPassword, messageRequest)
+	})
+}
+
+// send makes one attempt at sending a message
+func (zun *ZulipUserNotification) send(ctx context.Context, botPassword string, messageRequest url.Values) error {
+	zulipClient := zun.client
+	if zulipClient == nil {
+		zulipClient = DefaultHTTPClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", zun.ZulipAPIURL, strings.NewReader(messageRequest.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(zun.BotUsername, botPassword)
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	resp, err := zulipClient.Do(req)
+	if err != nil {
+		// if our own context is done there's no point retrying
+		return &deliveryErr{msg: err.Error(), temporary: ctx.Err() == nil}
+	}
+	defer resp.Body.Close()
+
+	return checkZulipResponse(resp)
+}
+
+// ValidateJSON reads Zulip's webhook JSON out of the request body
+func (zur *ZulipUserRequest) ValidateJSON(r *http.Request) error {
+	var userReq incomingJSON
+	// Look at the incoming webhook and slurp up the JSON
+	// Error if the JSON from Zulip itself is bad
+	err := json.NewDecoder(r.Body).Decode(&userReq)
+
+	if err == nil {
+		zur.json = userReq
+	}
+	return err
+}
+
+// ValidateAuthCreds checks the token Zulip sent against the one in botauth/token
+func (zur *ZulipUserRequest) ValidateAuthCreds(tokenFromDB string) bool {
+	if zur.json.Token != tokenFromDB {
+		log.Println("Unauthorized interaction attempt")
+		return false
+	}
+	return true
+}
+
+// if the zulip msg is posted in a stream, don't treat it as a command
+func (zur *ZulipUserRequest) ValidateInteractionType() *BotResponse {
+	if zur.json.Trigger != "private_message" {
+		return &BotResponse{"Hi! I'm Pairing Bot (she/her)!\n\nSend me a PM that says `subscribe` to get started :smiley:\n\n:pear::robot:\n:octopus::octopus:"}
+	}
+	return nil
+}
+
+// if there aren't two 'recipients' (one sender and one receiver),
+// then don't respond. this stops pairing bot from responding in the group
+// chat she starts when she matches people
+func (zur *ZulipUserRequest) IgnoreInteractionType() *BotNoResponse {
+	if len(zur.json.Message.DisplayRecipient.([]interface{})) != 2 {
+		return &BotNoResponse{true}
+	}
+	return nil
+}
+
+// UserInput is the text of the message
+func (zur *ZulipUserRequest) UserInput() string {
+	return zur.json.Data
+}
+
+// ExtractUserData is who sent the message
+func (zur *ZulipUserRequest) ExtractUserData() *UserDataFromJSON {
+	return &UserDataFromJSON{
+		UserID:    strconv.Itoa(zur.json.Message.SenderID),
+		UserEmail: zur.json.Message.SenderEmail,
+		UserName:  zur.json.Message.SenderFullName,
+	}
+}
+
+// NewZulipNotification sends messages as botUsername, through the messages
+// API at zulipAPIURL (like https://recurse.zulipchat.com/api/v1/messages)
+func NewZulipNotification(botUsername, zulipAPIURL string) *ZulipUserNotification {
+	return &ZulipUserNotification{
+		BotUsername: botUsername,
+		ZulipAPIURL: zulipAPIURL,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		limiter:     NewRateLimiter(defaultSendsPerMinute),
+		MaxRetries:  DefaultMaxRetries,
+		BaseBackoff: DefaultBaseBackoff,
+	}
+}
@@ -0,0 +1,323 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// Zulip allows roughly 200 requests a minute per bot, so we stay a bit under that
+const defaultSendsPerMinute = 150
+
+// how many times we retry a failed send before giving up on it
+const DefaultMaxRetries = 4
+
+// how long we wait before the first retry. it doubles for every retry after that
+const DefaultBaseBackoff = time.Second
+
+const maxBackoff = 30 * time.Second
+
+// after this many tries from the outbox, a message is dropped for good
+const maxOutboxAttempts = 10
+
+// how long Retry holds on to a message while it sends it. it's longer than
+// withRetries will ever take, so nobody else picks it up in the meantime
+const outboxClaim = 5 * time.Minute
+
+// Zulip always answers with one of these, whether it worked or not
+// https://zulip.com/api/rest-error-handling
+type zulipResponse struct {
+	Result     string  `json:"result"`
+	Msg        string  `json:"msg"`
+	Code       string  `json:"code"`
+	RetryAfter float64 `json:"retry-after"`
+}
+
+// deliveryErr is what SendUserMessage returns when a chat platform didn't take our message
+type deliveryErr struct {
+	status int
+	msg    string
+	// temporary errors are worth retrying
+	temporary bool
+	// how long Zulip asked us to wait, if it did
+	retryAfter time.Duration
+}
+
+func (e *deliveryErr) Error() string {
+	if e.status == 0 {
+		return fmt.Sprintf("Error when sending chat message: %s", e.msg)
+	}
+	return fmt.Sprintf("Error when sending chat message: HTTP %d: %s", e.status, e.msg)
+}
+
+// checkZulipResponse looks at the status code and the body of a response from
+// Zulip's API and works out whether the message was sent, and if not, why not
+func checkZulipResponse(resp *http.Response) error {
+	var zr zulipResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&zr)
+
+	if resp.StatusCode == http.StatusOK && decodeErr == nil && zr.Result == "success" {
+		return nil
+	}
+
+	err := &deliveryErr{
+		status:    resp.StatusCode,
+		msg:       zr.Msg,
+		temporary: resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+	}
+	if err.msg == "" {
+		err.msg = http.StatusText(resp.StatusCode)
+	}
+
+	// Zulip tells us how long to back off for in both the header and the body
+	err.retryAfter = retryAfter(resp.Header)
+	if err.retryAfter == 0 && zr.RetryAfter > 0 {
+		err.retryAfter = time.Duration(zr.RetryAfter * float64(time.Second))
+	}
+	return err
+}
+
+// backoff is how long to wait before retry number attempt (starting from 0)
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << uint(attempt)
+	if d > maxBackoff || d <= 0 {
+		return maxBackoff
+	}
+	return d
+}
+
+// withRetries calls attempt until it works, retrying with backoff when it
+// fails with a temporary deliveryErr (network errors, 5xx responses and rate
+// limiting). Every attempt waits its turn with the rate limiter first.
+// what describes the attempt for the logs
+func withRetries(ctx context.Context, limiter *RateLimiter, maxRetries int, baseBackoff time.Duration, what string, attempt func() error) error {
+	for n := 0; ; n++ {
+		err := limiter.wait(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = attempt()
+		if err == nil {
+			return nil
+		}
+
+		var de *deliveryErr
+		if !errors.As(err, &de) || !de.temporary || n >= maxRetries {
+			return err
+		}
+
+		wait := backoff(baseBackoff, n)
+		if de.retryAfter > 0 {
+			// the platform knows best. everyone else has to wait too, not just us
+			wait = de.retryAfter
+			limiter.pause(de.retryAfter)
+		}
+		log.Printf("Retrying %s in %v: %s\n", what, wait, err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// retryAfter reads a Retry-After header, which is in seconds
+func retryAfter(h http.Header) time.Duration {
+	secs, err := strconv.ParseFloat(h.Get("Retry-After"), 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+// RateLimiter spaces out sends so that everything going through it (e.g. a
+// whole batch of match messages) stays under Zulip's rate limit.
+// A nil *RateLimiter doesn't limit anything.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRateLimiter lets through perMinute sends a minute, evenly spaced
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return &RateLimiter{interval: time.Minute / time.Duration(perMinute)}
+}
+
+// wait blocks until it's our turn to send, or until ctx is done
+func (rl *RateLimiter) wait(ctx context.Context) error {
+	if rl == nil {
+		return ctx.Err()
+	}
+
+	rl.mu.Lock()
+	now := time.Now()
+	if rl.next.Before(now) {
+		rl.next = now
+	}
+	d := rl.next.Sub(now)
+	rl.next = rl.next.Add(rl.interval)
+	rl.mu.Unlock()
+
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pause holds back every send for d, for when Zulip tells us we're going too fast
+func (rl *RateLimiter) pause(d time.Duration) {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if until := time.Now().Add(d); rl.next.Before(until) {
+		rl.next = until
+	}
+}
+
+// implements UserNotification
+// OutboxUserNotification sends messages through another UserNotification,
+// and saves anything that still fails into the outbox so it can be retried later
+type OutboxUserNotification struct {
+	UN  UserNotification
+	ODB storage.OutboxDB
+}
+
+// SendUserMessage sends through UN, and queues the message if it failed in a
+// way that might go away. the error is still returned either way
+func (o *OutboxUserNotification) SendUserMessage(ctx context.Context, botPassword, user, message string) error {
+	err := o.UN.SendUserMessage(ctx, botPassword, user, message)
+	if err == nil || !worthRetrying(ctx, err) {
+		return err
+	}
+
+	// ctx might be the thing that failed us, so don't use it to save the message
+	qctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	qerr := o.ODB.Add(qctx, storage.OutboxMessage{
+		Date:      time.Now(),
+		To:        user,
+		Content:   message,
+		Attempts:  1,
+		LastError: err.Error(),
+	})
+	if qerr != nil {
+		return fmt.Errorf("%w (and it couldn't be saved to the outbox: %v)", err, qerr)
+	}
+	return &queuedErr{err}
+}
+
+// queuedErr is a message that failed, but is in the outbox now
+type queuedErr struct {
+	err error
+}
+
+func (e *queuedErr) Error() string {
+	return e.err.Error() + " (saved to the outbox to try again later)"
+}
+
+func (e *queuedErr) Unwrap() error {
+	return e.err
+}
+
+// Retry tries to send everything in the outbox again. Messages that go
+// through are removed from it, and messages that fail for good, or have
+// failed too many times, are dropped. It stops when ctx is done, leaving the
+// rest for next time. It returns how many were sent and how many are left.
+func (o *OutboxUserNotification) Retry(ctx context.Context, botPassword string) (int, int, error) {
+	msgs, err := o.ODB.List(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sent, dropped := 0, 0
+	for _, msg := range msgs {
+		// running out of time isn't the messages' fault, so it doesn't count as an attempt
+		if ctx.Err() != nil {
+			break
+		}
+
+		// someone else is already sending it
+		claimed, err := o.ODB.Claim(ctx, msg.ID, time.Now().Add(outboxClaim))
+		if err != nil {
+			log.Printf("Could not claim outbox message %s: %s\n", msg.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		msg.ClaimedUntil = time.Time{}
+
+		err = o.UN.SendUserMessage(ctx, botPassword, msg.To, msg.Content)
+		if err == nil {
+			sent++
+			err = o.ODB.Delete(ctx, msg.ID)
+			if err != nil {
+				log.Printf("Could not remove sent message %s from the outbox: %s\n", msg.ID, err)
+			}
+			continue
+		}
+
+		if ctx.Err() != nil {
+			// let go of it for next time, with ctx gone
+			uctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = o.ODB.Update(uctx, msg)
+			cancel()
+			if err != nil {
+				log.Printf("Could not update outbox message %s: %s\n", msg.ID, err)
+			}
+			break
+		}
+
+		msg.Attempts++
+		msg.LastError = err.Error()
+		switch {
+		case !worthRetrying(ctx, err):
+			log.Printf("Giving up on outbox message %s to %s, which can't be sent: %s\n", msg.ID, msg.To, err)
+			dropped++
+			err = o.ODB.Delete(ctx, msg.ID)
+		case msg.Attempts >= maxOutboxAttempts:
+			log.Printf("Giving up on outbox message %s to %s after %d attempts: %s\n", msg.ID, msg.To, msg.Attempts, err)
+			dropped++
+			err = o.ODB.Delete(ctx, msg.ID)
+		default:
+			err = o.ODB.Update(ctx, msg)
+		}
+		if err != nil {
+			log.Printf("Could not update outbox message %s: %s\n", msg.ID, err)
+		}
+	}
+	return sent, len(msgs) - sent - dropped, nil
+}
+
+// worthRetrying is whether err might go away by itself: a temporary
+// deliveryErr, or running out of time before the message could be sent.
+// anything else (like Zulip saying there's no such user) will fail again
+func worthRetrying(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+	var de *deliveryErr
+	return errors.As(err, &de) && de.temporary
+}
@@ -0,0 +1,247 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// zulipStub answers each request with the next of its responses, and then
+// keeps repeating the last one
+type zulipStub struct {
+	mu        sync.Mutex
+	responses []stubResponse
+	requests  int
+}
+
+type stubResponse struct {
+	status     int
+	body       string
+	retryAfter string
+}
+
+func (z *zulipStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	resp := z.responses[len(z.responses)-1]
+	if z.requests < len(z.responses) {
+		resp = z.responses[z.requests]
+	}
+	z.requests++
+
+	if resp.retryAfter != "" {
+		w.Header().Set("Retry-After", resp.retryAfter)
+	}
+	w.WriteHeader(resp.status)
+	fmt.Fprint(w, resp.body)
+}
+
+const zulipSuccess = `{"result": "success", "msg": "", "id": 42}`
+
+func newTestNotification(url string) *ZulipUserNotification {
+	return &ZulipUserNotification{
+		BotUsername: "pairing-bot@example.com",
+		ZulipAPIURL: url,
+		MaxRetries:  3,
+		BaseBackoff: time.Millisecond,
+	}
+}
+
+func TestSendUserMessageRetries(t *testing.T) {
+	var table = []struct {
+		testName     string
+		responses    []stubResponse
+		wantErr      bool
+		wantRequests int
+	}{
+		{"success", []stubResponse{{200, zulipSuccess, ""}}, false, 1},
+		{"server_error_then_success", []stubResponse{{502, "bad gateway", ""}, {200, zulipSuccess, ""}}, false, 2},
+		{"rate_limited_then_success", []stubResponse{{429, `{"result": "error", "code": "RATE_LIMIT_HIT", "retry-after": 0.01}`, "0.01"}, {200, zulipSuccess, ""}}, false, 2},
+		{"server_error_forever", []stubResponse{{500, "oops", ""}}, true, 4},
+		{"bad_request_is_not_retried", []stubResponse{{400, `{"result": "error", "msg": "Invalid email"}`, ""}}, true, 1},
+		{"error_result_with_200", []stubResponse{{200, `{"result": "error", "msg": "nope"}`, ""}}, true, 1},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.testName, func(t *testing.T) {
+			stub := &zulipStub{responses: tt.responses}
+			srv := httptest.NewServer(stub)
+			defer srv.Close()
+
+			err := newTestNotification(srv.URL).SendUserMessage(context.Background(), "password", "someone@example.com", "hi")
+			if tt.wantErr && err == nil {
+				t.Errorf("Expected an error but didn't get one\n")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("Got unexpected error: %v\n", err)
+			}
+			if stub.requests != tt.wantRequests {
+				t.Errorf("got %d requests, wanted %d\n", stub.requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestSendUserMessageRespectsRetryAfter(t *testing.T) {
+	stub := &zulipStub{responses: []stubResponse{
+		{429, `{"result": "error", "code": "RATE_LIMIT_HIT"}`, "0.2"},
+		{200, zulipSuccess, ""},
+	}}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	start := time.Now()
+	err := newTestNotification(srv.URL).SendUserMessage(context.Background(), "password", "someone@example.com", "hi")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v\n", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("retried after %v, but Zulip asked us to wait 200ms\n", elapsed)
+	}
+}
+
+func TestRateLimiterSpacesOutSends(t *testing.T) {
+	rl := &RateLimiter{interval: 20 * time.Millisecond}
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := rl.wait(ctx); err != nil {
+			t.Fatalf("Got unexpected error: %v\n", err)
+		}
+	}
+	// the first one goes straight away, then four more intervals
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("5 sends took %v, wanted at least 80ms\n", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	rl.pause(time.Hour)
+	if err := rl.wait(cancelled); err == nil {
+		t.Errorf("Expected an error from a cancelled context\n")
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	stub := &zulipStub{responses: []stubResponse{{503, "down", ""}}}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	odb := storage.NewMemoryOutboxDB()
+	outbox := &OutboxUserNotification{UN: newTestNotification(srv.URL), ODB: odb}
+	ctx := context.Background()
+
+	err := outbox.SendUserMessage(ctx, "password", "someone@example.com", "hi")
+	if err == nil {
+		t.Fatalf("Expected an error but didn't get one\n")
+	}
+	if msgs, _ := odb.List(ctx); len(msgs) != 1 {
+		t.Fatalf("got %d messages in the outbox, wanted 1\n", len(msgs))
+	}
+
+	// still down: the message stays in the outbox, with another attempt counted
+	sent, left, err := outbox.Retry(ctx, "password")
+	if err != nil || sent != 0 || left != 1 {
+		t.Errorf("got %d sent, %d left, %v, wanted 0 sent, 1 left\n", sent, left, err)
+	}
+	msgs, _ := odb.List(ctx)
+	for _, m := range msgs {
+		if m.Attempts != 2 || m.To != "someone@example.com" || m.Content != "hi" {
+			t.Errorf("got outbox message %+v\n", m)
+		}
+	}
+
+	// back up again: the message goes out and leaves the outbox
+	stub.mu.Lock()
+	stub.responses = []stubResponse{{200, zulipSuccess, ""}}
+	stub.requests = 0
+	stub.mu.Unlock()
+
+	sent, left, err = outbox.Retry(ctx, "password")
+	if err != nil || sent != 1 || left != 0 {
+		t.Errorf("got %d sent, %d left, %v, wanted 1 sent, 0 left\n", sent, left, err)
+	}
+	if msgs, _ := odb.List(ctx); len(msgs) != 0 {
+		t.Errorf("got %d messages in the outbox, wanted 0\n", len(msgs))
+	}
+}
+
+func TestOutboxOnlyQueuesTemporaryFailures(t *testing.T) {
+	var table = []struct {
+		testName string
+		response stubResponse
+		queued   bool
+	}{
+		{"server_error", stubResponse{503, "down", ""}, true},
+		{"rate_limited", stubResponse{429, `{"result": "error", "code": "RATE_LIMIT_HIT"}`, "0"}, true},
+		{"bad_request", stubResponse{400, `{"result": "error", "msg": "Invalid email 'nobody@example.com'"}`, ""}, false},
+		{"unauthorized", stubResponse{401, `{"result": "error", "msg": "Invalid API key"}`, ""}, false},
+	}
+	for _, tt := range table {
+		t.Run(tt.testName, func(t *testing.T) {
+			srv := httptest.NewServer(&zulipStub{responses: []stubResponse{tt.response}})
+			defer srv.Close()
+			odb := storage.NewMemoryOutboxDB()
+			outbox := &OutboxUserNotification{UN: newTestNotification(srv.URL), ODB: odb}
+			ctx := context.Background()
+
+			if err := outbox.SendUserMessage(ctx, "password", "nobody@example.com", "hi"); err == nil {
+				t.Fatal("Expected an error but didn't get one")
+			}
+			if msgs, _ := odb.List(ctx); (len(msgs) == 1) != tt.queued {
+				t.Errorf("got %d messages in the outbox, wanted queued to be %v", len(msgs), tt.queued)
+			}
+		})
+	}
+}
+
+func TestOutboxRetryStopsWhenCtxIsDone(t *testing.T) {
+	stub := &zulipStub{responses: []stubResponse{{200, zulipSuccess, ""}}}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+	odb := storage.NewMemoryOutboxDB()
+	outbox := &OutboxUserNotification{UN: newTestNotification(srv.URL), ODB: odb}
+	for _, to := range []string{"ada@example.com", "bea@example.com"} {
+		odb.Add(context.Background(), storage.OutboxMessage{To: to, Content: "hi", Attempts: 1})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	sent, left, err := outbox.Retry(ctx, "password")
+	if err != nil || sent != 0 || left != 2 || stub.requests != 0 {
+		t.Errorf("got %d sent, %d left, %v, %d requests, wanted nothing sent", sent, left, err, stub.requests)
+	}
+	msgs, _ := odb.List(context.Background())
+	for _, m := range msgs {
+		if m.Attempts != 1 || !m.ClaimedUntil.IsZero() {
+			t.Errorf("a cancelled retry used up an attempt: %+v", m)
+		}
+	}
+}
+
+func TestOutboxRetrySkipsClaimedMessages(t *testing.T) {
+	stub := &zulipStub{responses: []stubResponse{{200, zulipSuccess, ""}}}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+	odb := storage.NewMemoryOutboxDB()
+	outbox := &OutboxUserNotification{UN: newTestNotification(srv.URL), ODB: odb}
+	ctx := context.Background()
+	odb.Add(ctx, storage.OutboxMessage{To: "ada@example.com", Content: "hi", Attempts: 1})
+
+	// another retry is sending it right now
+	msgs, _ := odb.List(ctx)
+	if claimed, _ := odb.Claim(ctx, msgs[0].ID, time.Now().Add(time.Minute)); !claimed {
+		t.Fatal("couldn't claim the message")
+	}
+	sent, left, err := outbox.Retry(ctx, "password")
+	if err != nil || sent != 0 || left != 1 || stub.requests != 0 {
+		t.Errorf("got %d sent, %d left, %v, %d requests, wanted the claimed message left alone", sent, left, err, stub.requests)
+	}
+}
@@ -0,0 +1,380 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const discordPlatformName = "discord"
+
+// DefaultDiscordAPIURL is Discord's REST API, at the version we were written for
+const DefaultDiscordAPIURL = "https://discord.com/api/v10"
+
+// Discord's global limit is 50 requests a second, but creating DMs and
+// threads is limited much harder than that
+const DefaultDiscordSendsPerMinute = 50
+
+// Discord won't take a message longer than this
+const DiscordMaxMessageLength = 2000
+
+// interaction types, and the types of response we send back
+// https://discord.com/developers/docs/interactions/receiving-and-responding
+const (
+	DiscordPing               = 1
+	DiscordApplicationCommand = 2
+
+	discordPong                             = 1
+	discordDeferredChannelMessageWithSource = 5
+
+	// only the person who used the command can see our reply
+	discordEphemeral = 64
+
+	DiscordPrivateThread = 12
+)
+
+// implements Platform
+// DiscordPlatform runs Pairing Bot as a Discord app: every command is a slash
+// command, which Discord sends us as an interaction. We answer privately to
+// whoever used it, DM people for messages that are just for them, and start
+// a private thread for each match.
+// It needs the app's public key and bot token in the database, at
+// discordauth/publickey and discordauth/bottoken
+type DiscordPlatform struct {
+	APIURL string
+	AppID  string
+	// the channel match threads are started in
+	ChannelID string
+	// the slash commands RegisterCommands tells Discord about
+	Commands []DiscordCommand
+	ADB      storage.APIAuthDB
+	// nil means DefaultHTTPClient
+	Client      *http.Client
+	Limiter     *RateLimiter
+	MaxRetries  int
+	BaseBackoff time.Duration
+}
+
+// the parts of an interaction we care about
+type discordInteraction struct {
+	Type          int    `json:"type"`
+	ApplicationID string `json:"application_id"`
+	Token         string `json:"token"`
+	Data          struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string      `json:"name"`
+			Value interface{} `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+	// member is set when the command was used in a server, and user when it was used in a DM
+	Member *struct {
+		User DiscordUser `json:"user"`
+	} `json:"member"`
+	User *DiscordUser `json:"user"`
+}
+
+// DiscordUser is who sent an interaction
+type DiscordUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+}
+
+// what Discord says when something goes wrong
+type discordError struct {
+	Message    string  `json:"message"`
+	Code       int     `json:"code"`
+	RetryAfter float64 `json:"retry_after"`
+}
+
+// DiscordCommand is how one of Pairing Bot's commands shows up as a slash command
+type DiscordCommand struct {
+	Name        string
+	Description string
+	// what to say about the command's arguments, if it takes any
+	Args string
+}
+
+// Name is "discord"
+func (d *DiscordPlatform) Name() string {
+	return discordPlatformName
+}
+
+// ReadCommands checks an interaction's signature, answers pings, and defers
+// the response to slash commands so there's time to run them
+func (d *DiscordPlatform) ReadCommands(w http.ResponseWriter, r *http.Request) ([]IncomingCommand, error) {
+	ctx := r.Context()
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := d.ADB.GetKey(ctx, "discordauth", "publickey")
+	if err != nil {
+		return nil, err
+	}
+	// Discord checks that we turn down bad signatures, and it wants a 401 for them
+	err = verifyDiscordSignature(publicKey, r.Header, body)
+	if err != nil {
+		log.Printf("Bad request from discord: %s\n", err)
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return nil, nil
+	}
+
+	var in discordInteraction
+	err = json.Unmarshal(body, &in)
+	if err != nil {
+		return nil, err
+	}
+
+	switch in.Type {
+	case DiscordPing:
+		return nil, writeDiscordResponse(w, map[string]interface{}{"type": discordPong})
+
+	case DiscordApplicationCommand:
+		// we have 3 seconds to answer, so say we're on it and follow up with the real reply
+		err = writeDiscordResponse(w, map[string]interface{}{
+			"type": discordDeferredChannelMessageWithSource,
+			"data": map[string]interface{}{"flags": discordEphemeral},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		var user DiscordUser
+		switch {
+		case in.Member != nil:
+			user = in.Member.User
+		case in.User != nil:
+			user = *in.User
+		default:
+			return nil, errors.New("interaction has no user")
+		}
+		name := user.GlobalName
+		if name == "" {
+			name = user.Username
+		}
+
+		text := in.Data.Name
+		for _, opt := range in.Data.Options {
+			text += " " + fmt.Sprint(opt.Value)
+		}
+		return []IncomingCommand{{
+			User: UserDataFromJSON{
+				UserID:   storage.PlatformUserID(discordPlatformName, user.ID),
+				UserName: name,
+			},
+			Text:    text,
+			replyTo: in.ApplicationID + "/" + in.Token,
+		}}, nil
+	}
+	return nil, nil
+}
+
+// verifyDiscordSignature checks that a request really came from Discord
+// https://discord.com/developers/docs/interactions/overview#setting-up-an-endpoint-validating-security-request-headers
+func verifyDiscordSignature(publicKeyHex string, h http.Header, body []byte) error {
+	publicKey, err := hex.DecodeString(publicKeyHex)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("discordauth/publickey isn't an Ed25519 public key")
+	}
+	sig, err := hex.DecodeString(h.Get("X-Signature-Ed25519"))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return errors.New("missing or bad X-Signature-Ed25519")
+	}
+	msg := append([]byte(h.Get("X-Signature-Timestamp")), body...)
+	if !ed25519.Verify(publicKey, msg, sig) {
+		return errors.New("bad X-Signature-Ed25519")
+	}
+	return nil
+}
+
+func writeDiscordResponse(w http.ResponseWriter, resp interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// Reply fills in the response we deferred when the command came in
+func (d *DiscordPlatform) Reply(ctx context.Context, cmd IncomingCommand, response string) error {
+	return d.call(ctx, "PATCH", "/webhooks/"+cmd.replyTo+"/messages/@original", map[string]string{
+		"content": discordMessage(response),
+	}, nil)
+}
+
+// SendUserMessage messages one or more Discord users (comma-separated IDs).
+// One person gets a DM. More than that get a private thread together
+func (d *DiscordPlatform) SendUserMessage(ctx context.Context, botPassword, user, message string) error {
+	ids := strings.Split(user, ",")
+	var channel string
+	var err error
+	if len(ids) == 1 {
+		channel, err = d.openDM(ctx, ids[0])
+	} else {
+		channel, err = d.startThread(ctx, ids)
+	}
+	if err != nil {
+		return err
+	}
+	return d.call(ctx, "POST", "/channels/"+channel+"/messages", map[string]string{
+		"content": discordMessage(message),
+	}, nil)
+}
+
+func (d *DiscordPlatform) openDM(ctx context.Context, userID string) (string, error) {
+	var dm struct {
+		ID string `json:"id"`
+	}
+	err := d.call(ctx, "POST", "/users/@me/channels", map[string]string{"recipient_id": userID}, &dm)
+	return dm.ID, err
+}
+
+// startThread starts a private thread in the match channel, with everyone in ids in it
+func (d *DiscordPlatform) startThread(ctx context.Context, ids []string) (string, error) {
+	if d.ChannelID == "" {
+		return "", errors.New("no discord channel is set up for match threads")
+	}
+
+	var thread struct {
+		ID string `json:"id"`
+	}
+	err := d.call(ctx, "POST", "/channels/"+d.ChannelID+"/threads", map[string]interface{}{
+		"name":                  "Pairing " + time.Now().Format("Jan 2"),
+		"type":                  DiscordPrivateThread,
+		"invitable":             false,
+		"auto_archive_duration": 1440,
+	}, &thread)
+	if err != nil {
+		return "", err
+	}
+	for _, id := range ids {
+		err = d.call(ctx, "PUT", "/channels/"+thread.ID+"/thread-members/"+id, nil, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+	return thread.ID, nil
+}
+
+// RegisterCommands tells Discord about all of our slash commands, replacing
+// whatever it had before
+func (d *DiscordPlatform) RegisterCommands(ctx context.Context) error {
+	var defs []map[string]interface{}
+	for _, c := range d.Commands {
+		def := map[string]interface{}{
+			"name":        c.Name,
+			"description": c.Description,
+		}
+		if c.Args != "" {
+			def["options"] = []map[string]interface{}{{
+				"type":        3, // string
+				"name":        "args",
+				"description": c.Args,
+			}}
+		}
+		defs = append(defs, def)
+	}
+	return d.call(ctx, "PUT", "/applications/"+d.AppID+"/commands", defs, nil)
+}
+
+// call calls Discord's HTTP API, retrying the same way we do for Zulip.
+// in is sent as JSON if it's not nil, and out gets the decoded response if it's not nil
+func (d *DiscordPlatform) call(ctx context.Context, method, path string, in, out interface{}) error {
+	token, err := d.ADB.GetKey(ctx, "discordauth", "bottoken")
+	if err != nil {
+		return err
+	}
+	var body []byte
+	if in != nil {
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+	return withRetries(ctx, d.Limiter, d.MaxRetries, d.BaseBackoff, "discord "+method+" "+path, func() error {
+		return d.callOnce(ctx, token, method, path, body, out)
+	})
+}
+
+func (d *DiscordPlatform) callOnce(ctx context.Context, token, method, path string, body []byte, out interface{}) error {
+	client := d.Client
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, d.APIURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bot "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &deliveryErr{msg: err.Error(), temporary: ctx.Err() == nil}
+	}
+	defer resp.Body.Close()
+
+	return checkDiscordResponse(resp, out)
+}
+
+func checkDiscordResponse(resp *http.Response, out interface{}) error {
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &deliveryErr{status: resp.StatusCode, msg: err.Error(), temporary: true}
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil || len(b) == 0 {
+			return nil
+		}
+		return json.Unmarshal(b, out)
+	}
+
+	var de discordError
+	_ = json.Unmarshal(b, &de)
+	e := &deliveryErr{
+		status:     resp.StatusCode,
+		msg:        de.Message,
+		temporary:  resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+		retryAfter: retryAfter(resp.Header),
+	}
+	if e.retryAfter == 0 && de.RetryAfter > 0 {
+		e.retryAfter = time.Duration(de.RetryAfter * float64(time.Second))
+	}
+	if e.msg == "" {
+		e.msg = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// discordMessage cuts a message down to what Discord will take. Discord
+// already understands our markdown
+func discordMessage(s string) string {
+	if len(s) <= DiscordMaxMessageLength {
+		return s
+	}
+	cut := DiscordMaxMessageLength - len("…")
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
@@ -0,0 +1,14 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiscordMessage(t *testing.T) {
+	long := strings.Repeat("é", DiscordMaxMessageLength)
+	got := discordMessage(long)
+	if len(got) > DiscordMaxMessageLength || !strings.HasSuffix(got, "…") || !strings.HasPrefix(got, "éé") {
+		t.Errorf("cut a long message down to %d bytes: %q", len(got), got[len(got)-10:])
+	}
+}
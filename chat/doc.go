@@ -0,0 +1,5 @@
+// Package chat sends and receives messages. Zulip is the main platform;
+// Slack, Matrix and Discord implement Platform, and email can stand in for
+// any of them. sends are rate-limited and retried, and anything that still
+// fails goes into the outbox.
+package chat
@@ -0,0 +1,206 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// email isn't a chat platform, but it's addressed like one: "email:ada@example.com"
+const emailPlatform = "email"
+
+// implements UserNotification
+// SMTPUserNotification sends messages as email, with a plain text and an HTML version
+type SMTPUserNotification struct {
+	// host:port of the SMTP server
+	Addr string
+	From string
+	// nil means we don't log in
+	Auth        smtp.Auth
+	MaxRetries  int
+	BaseBackoff time.Duration
+}
+
+// EmailAddress is how we address an email to someone through PlatformNotification
+func EmailAddress(email string) string {
+	return storage.PlatformUserID(emailPlatform, email)
+}
+
+// SendUserMessage emails everyone in user (comma-separated addresses)
+func (s *SMTPUserNotification) SendUserMessage(ctx context.Context, botPassword, user, message string) error {
+	var to []string
+	for _, addr := range strings.Split(user, ",") {
+		to = append(to, strings.TrimSpace(addr))
+	}
+
+	msg, err := buildEmail(s.From, to, message, time.Now())
+	if err != nil {
+		return err
+	}
+	return withRetries(ctx, nil, s.MaxRetries, s.BaseBackoff, "email to "+user, func() error {
+		return s.send(ctx, to, msg)
+	})
+}
+
+// send makes one attempt at handing an email to the SMTP server
+func (s *SMTPUserNotification) send(ctx context.Context, to []string, msg []byte) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return &deliveryErr{msg: err.Error(), temporary: ctx.Err() == nil}
+	}
+	// net/smtp doesn't know about contexts, so a deadline on the connection has to do
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	conn.SetDeadline(deadline)
+
+	host, _, _ := net.SplitHostPort(s.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return smtpErr(err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return smtpErr(err)
+		}
+	}
+	if s.Auth != nil {
+		err = c.Auth(s.Auth)
+		if err != nil {
+			return smtpErr(err)
+		}
+	}
+
+	err = c.Mail(s.From)
+	if err != nil {
+		return smtpErr(err)
+	}
+	for _, addr := range to {
+		err = c.Rcpt(addr)
+		if err != nil {
+			return smtpErr(err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpErr(err)
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return smtpErr(err)
+	}
+	err = w.Close()
+	if err != nil {
+		return smtpErr(err)
+	}
+	return smtpErr(c.Quit())
+}
+
+// smtpErr turns an SMTP server's complaint into a deliveryErr. 4xx replies
+// (and losing the connection) are worth trying again, 5xx replies aren't
+func smtpErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return &deliveryErr{status: tpErr.Code, msg: tpErr.Msg, temporary: tpErr.Code >= 400 && tpErr.Code < 500}
+	}
+	return &deliveryErr{msg: err.Error(), temporary: true}
+}
+
+// buildEmail renders a message (in the markdown we write all our messages in)
+// as a multipart email, with a plain text version and an HTML version
+func buildEmail(from string, to []string, message string, date time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		// the last part is the one mail clients prefer
+		{"text/plain; charset=utf-8", plainText(message)},
+		{"text/html; charset=utf-8", htmlText(message)},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		_, err = qp.Write([]byte(part.content))
+		if err != nil {
+			return nil, err
+		}
+		err = qp.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: Pairing Bot <%s>\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", emailSubject(message)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n", mw.Boundary())
+	fmt.Fprintf(&msg, "\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// emailSubject is the first line of the message, since all of ours start with a greeting
+func emailSubject(message string) string {
+	subject := strings.TrimSpace(strings.SplitN(plainText(message), "\n", 2)[0])
+	if len(subject) > 78 || subject == "" {
+		return "A message from Pairing Bot"
+	}
+	return subject
+}
+
+var markdownCode = regexp.MustCompile("`([^`]+)`")
+
+// plainText strips out our markdown
+func plainText(message string) string {
+	s := markdownBold.ReplaceAllString(message, "$1")
+	s = markdownLink.ReplaceAllString(s, "$1 ($2)")
+	return markdownCode.ReplaceAllString(s, "$1")
+}
+
+// htmlText turns our markdown into HTML. We only use a little bit of markdown,
+// so this only knows about a little bit of it
+func htmlText(message string) string {
+	s := html.EscapeString(message)
+	s = markdownBold.ReplaceAllString(s, "<strong>$1</strong>")
+	s = markdownLink.ReplaceAllString(s, `<a href="$2">$1</a>`)
+	s = markdownCode.ReplaceAllString(s, "<code>$1</code>")
+	s = strings.Replace(s, "\n", "<br>\n", -1)
+	return "<!DOCTYPE html>\n<html><body>\n" + s + "\n</body></html>\n"
+}
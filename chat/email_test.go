@@ -0,0 +1,224 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a tiny SMTP server that accepts mail for anyone (except the
+// addresses in reject) and remembers it
+type fakeSMTP struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	mails  []receivedMail
+	reject map[string]bool
+	// how many MAIL commands to answer with a temporary failure first
+	failNext int
+}
+
+type receivedMail struct {
+	from string
+	to   []string
+	data []byte
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, reject: map[string]bool{}}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(textproto.NewConn(conn))
+	}
+}
+
+func (s *fakeSMTP) handle(c *textproto.Conn) {
+	defer c.Close()
+
+	var mail receivedMail
+	c.PrintfLine("220 localhost fake ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		arg := func(prefix string) string {
+			a := strings.TrimPrefix(line[len(prefix):], ":")
+			return strings.Trim(strings.TrimSpace(a), "<>")
+		}
+
+		switch verb {
+		case "EHLO", "HELO":
+			c.PrintfLine("250 localhost")
+		case "MAIL":
+			s.mu.Lock()
+			fail := s.failNext > 0
+			s.failNext--
+			s.mu.Unlock()
+			if fail {
+				c.PrintfLine("451 try again later")
+				continue
+			}
+			mail = receivedMail{from: arg("MAIL FROM")}
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			to := arg("RCPT TO")
+			s.mu.Lock()
+			rejected := s.reject[to]
+			s.mu.Unlock()
+			if rejected {
+				c.PrintfLine("550 no such user")
+				continue
+			}
+			mail.to = append(mail.to, to)
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			mail.data = data
+			s.mu.Lock()
+			s.mails = append(s.mails, mail)
+			s.mu.Unlock()
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTP) notification() *SMTPUserNotification {
+	return &SMTPUserNotification{
+		Addr:        s.ln.Addr().String(),
+		From:        "pairing-bot@example.com",
+		MaxRetries:  1,
+		BaseBackoff: time.Millisecond,
+	}
+}
+
+// mailTo returns the plain text of every email sent to addr
+func (s *fakeSMTP) mailTo(t *testing.T, addr string) []string {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var texts []string
+	for _, m := range s.mails {
+		for _, to := range m.to {
+			if to == addr {
+				text, _ := readEmail(t, m.data)
+				texts = append(texts, text)
+			}
+		}
+	}
+	return texts
+}
+
+// readEmail pulls the plain text and HTML out of a multipart email
+func readEmail(t *testing.T, data []byte) (string, string) {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text, html string
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		b, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+			html = string(b)
+		} else {
+			text = string(b)
+		}
+	}
+	return text, html
+}
+
+func TestBuildEmail(t *testing.T) {
+	message := "Yay! You're now subscribed to Pairing Bot!\nI'll find partners for you on **Mondays**, **Tuesdays** and **Fridays**.\n[issues](https://example.com/issues) <3"
+	data, err := buildEmail("pairing-bot@example.com", []string{"ada@example.com"}, message, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("Subject"); got != "Yay! You're now subscribed to Pairing Bot!" {
+		t.Errorf("got subject %q", got)
+	}
+
+	text, html := readEmail(t, data)
+	if !strings.Contains(text, "on Mondays, Tuesdays and Fridays") || !strings.Contains(text, "issues (https://example.com/issues)") || strings.Contains(text, "**") {
+		t.Errorf("got plain text %q", text)
+	}
+	if !strings.Contains(html, "<strong>Mondays</strong>") || !strings.Contains(html, `<a href="https://example.com/issues">issues</a>`) || !strings.Contains(html, "&lt;3") {
+		t.Errorf("got HTML %q", html)
+	}
+}
+
+func TestSMTPUserNotification(t *testing.T) {
+	smtpServer := newFakeSMTP(t)
+	un := smtpServer.notification()
+	ctx := context.Background()
+	message := "Hi you two! You've been matched for pairing :)"
+
+	// a temporary failure is retried
+	smtpServer.failNext = 1
+	err := un.SendUserMessage(ctx, "", "ada@example.com, bea@example.com", message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := smtpServer.mailTo(t, "bea@example.com"); len(got) != 1 || !strings.HasPrefix(got[0], "Hi you two!") {
+		t.Errorf("Bea got %q", got)
+	}
+
+	// a permanent one isn't
+	smtpServer.reject["nobody@example.com"] = true
+	err = un.SendUserMessage(ctx, "", "nobody@example.com", message)
+	if de, ok := err.(*deliveryErr); !ok || de.status != 550 || de.temporary {
+		t.Errorf("got %v for a rejected address", err)
+	}
+}
@@ -0,0 +1,319 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const matrixPlatformName = "matrix"
+
+// Synapse's default rate limit for messages is a few a second, but it lets
+// application services off that. this is just to be polite
+const DefaultMatrixSendsPerMinute = 60
+
+// implements Platform
+// MatrixPlatform runs Pairing Bot as a Matrix application service: the
+// homeserver pushes events to us in transactions, and we use the
+// client-server API (as the bot user) for everything we send.
+// It needs the tokens from the appservice registration in the database, at
+// matrixauth/hstoken (what the homeserver sends us) and matrixauth/astoken
+// (what we send the homeserver)
+type MatrixPlatform struct {
+	// like https://matrix.example.org
+	Homeserver string
+	// like @pairing-bot:example.org
+	BotUserID string
+	ADB       storage.APIAuthDB
+	// where we keep each person's DM room, and each pair's room
+	Rooms storage.RoomDB
+	// nil means DefaultHTTPClient
+	Client      *http.Client
+	Limiter     *RateLimiter
+	MaxRetries  int
+	BaseBackoff time.Duration
+
+	// the homeserver sends a transaction again if it doesn't hear back from
+	// us, so we remember the last few we've seen
+	mu       sync.Mutex
+	seenTxns map[string]bool
+}
+
+// the parts of a room event we care about
+// https://spec.matrix.org/v1.8/client-server-api/#room-events
+type MatrixEvent struct {
+	Type     string  `json:"type"`
+	RoomID   string  `json:"room_id"`
+	Sender   string  `json:"sender"`
+	StateKey *string `json:"state_key"`
+	Content  struct {
+		MsgType    string `json:"msgtype"`
+		Body       string `json:"body"`
+		Membership string `json:"membership"`
+		IsDirect   bool   `json:"is_direct"`
+	} `json:"content"`
+}
+
+// what the homeserver says when something goes wrong
+type matrixError struct {
+	ErrCode      string `json:"errcode"`
+	Error        string `json:"error"`
+	RetryAfterMS int64  `json:"retry_after_ms"`
+}
+
+// for making transaction IDs for the messages we send
+var matrixTxnCounter int64
+
+// Name is "matrix"
+func (m *MatrixPlatform) Name() string {
+	return matrixPlatformName
+}
+
+// ReadCommands handles a transaction from the homeserver
+// https://spec.matrix.org/v1.8/application-service-api/#pushing-events
+func (m *MatrixPlatform) ReadCommands(w http.ResponseWriter, r *http.Request) ([]IncomingCommand, error) {
+	ctx := r.Context()
+
+	i := strings.LastIndex(r.URL.Path, "/transactions/")
+	if r.Method != http.MethodPut || i < 0 {
+		return nil, fmt.Errorf("not a transaction: %s %s", r.Method, r.URL.Path)
+	}
+	txnID := r.URL.Path[i+len("/transactions/"):]
+
+	hsToken, err := m.ADB.GetKey(ctx, "matrixauth", "hstoken")
+	if err != nil {
+		return nil, err
+	}
+	// older homeservers put the token in the query string instead
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("access_token")
+	}
+	if hsToken == "" || token != hsToken {
+		return nil, errors.New("bad homeserver token")
+	}
+
+	var txn struct {
+		Events []MatrixEvent `json:"events"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&txn)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write([]byte("{}"))
+	if err != nil {
+		return nil, err
+	}
+	if m.alreadySeen(txnID) {
+		return nil, nil
+	}
+
+	var cmds []IncomingCommand
+	for _, ev := range txn.Events {
+		if ev.Sender == m.BotUserID {
+			continue
+		}
+
+		switch {
+		// someone started a DM with the bot. join it, and remember it's theirs
+		case ev.Type == "m.room.member" && ev.StateKey != nil && *ev.StateKey == m.BotUserID &&
+			ev.Content.Membership == "invite" && ev.Content.IsDirect:
+			err = m.joinDM(ctx, ev.Sender, ev.RoomID)
+			if err != nil {
+				log.Printf("Could not join %s's DM on matrix: %s\n", ev.Sender, err)
+			}
+
+		// only answer people in their DM with us. this stops pairing bot from
+		// answering in the rooms she starts when she matches people
+		case ev.Type == "m.room.message" && ev.Content.MsgType == "m.text":
+			dm, err := m.Rooms.GetRoom(ctx, matrixPlatformName, ev.Sender)
+			if err != nil {
+				return nil, err
+			}
+			if dm != ev.RoomID {
+				continue
+			}
+			cmds = append(cmds, IncomingCommand{
+				User: UserDataFromJSON{
+					UserID:   storage.PlatformUserID(matrixPlatformName, ev.Sender),
+					UserName: m.displayName(ctx, ev.Sender),
+				},
+				Text:    ev.Content.Body,
+				replyTo: ev.RoomID,
+			})
+		}
+	}
+	return cmds, nil
+}
+
+// alreadySeen tells whether we've handled this transaction before, and
+// remembers it for next time
+func (m *MatrixPlatform) alreadySeen(txnID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.seenTxns[txnID] {
+		return true
+	}
+	// the homeserver only ever resends recent transactions
+	if m.seenTxns == nil || len(m.seenTxns) > 1000 {
+		m.seenTxns = map[string]bool{}
+	}
+	m.seenTxns[txnID] = true
+	return false
+}
+
+func (m *MatrixPlatform) joinDM(ctx context.Context, userID, roomID string) error {
+	err := m.call(ctx, "POST", "/join/"+url.PathEscape(roomID), struct{}{}, nil)
+	if err != nil {
+		return err
+	}
+	return m.Rooms.SetRoom(ctx, matrixPlatformName, userID, roomID)
+}
+
+// displayName looks up what someone calls themselves, falling back to their user ID
+func (m *MatrixPlatform) displayName(ctx context.Context, userID string) string {
+	var profile struct {
+		DisplayName string `json:"displayname"`
+	}
+	err := m.call(ctx, "GET", "/profile/"+url.PathEscape(userID)+"/displayname", nil, &profile)
+	if err != nil || profile.DisplayName == "" {
+		return userID
+	}
+	return profile.DisplayName
+}
+
+// Reply posts the response in the room the command came from
+func (m *MatrixPlatform) Reply(ctx context.Context, cmd IncomingCommand, response string) error {
+	return m.send(ctx, cmd.replyTo, response)
+}
+
+// SendUserMessage messages one or more Matrix users (comma-separated IDs). They
+// get a private room with the bot the first time, and the same room after that
+func (m *MatrixPlatform) SendUserMessage(ctx context.Context, botPassword, user, message string) error {
+	ids := strings.Split(user, ",")
+	sort.Strings(ids)
+	members := strings.Join(ids, ",")
+
+	room, err := m.Rooms.GetRoom(ctx, matrixPlatformName, members)
+	if err != nil {
+		return err
+	}
+	if room == "" {
+		room, err = m.createRoom(ctx, ids)
+		if err != nil {
+			return err
+		}
+		err = m.Rooms.SetRoom(ctx, matrixPlatformName, members, room)
+		if err != nil {
+			log.Printf("Could not save the matrix room for %s: %s\n", members, err)
+		}
+	}
+	return m.send(ctx, room, message)
+}
+
+func (m *MatrixPlatform) createRoom(ctx context.Context, invite []string) (string, error) {
+	var created struct {
+		RoomID string `json:"room_id"`
+	}
+	err := m.call(ctx, "POST", "/createRoom", map[string]interface{}{
+		"preset":    "trusted_private_chat",
+		"is_direct": true,
+		"invite":    invite,
+	}, &created)
+	return created.RoomID, err
+}
+
+func (m *MatrixPlatform) send(ctx context.Context, roomID, message string) error {
+	// the homeserver won't post the same transaction twice, so retries are safe
+	txnID := "pb" + strconv.FormatInt(time.Now().UnixNano(), 36) + "." + strconv.FormatInt(atomic.AddInt64(&matrixTxnCounter, 1), 36)
+	return m.call(ctx, "PUT", "/rooms/"+url.PathEscape(roomID)+"/send/m.room.message/"+txnID, map[string]string{
+		"msgtype": "m.text",
+		"body":    message,
+	}, nil)
+}
+
+// call calls the client-server API, retrying the same way we do for Zulip.
+// in is sent as JSON if it's not nil, and out gets the decoded response if it's not nil
+func (m *MatrixPlatform) call(ctx context.Context, method, path string, in, out interface{}) error {
+	asToken, err := m.ADB.GetKey(ctx, "matrixauth", "astoken")
+	if err != nil {
+		return err
+	}
+	var body []byte
+	if in != nil {
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+	return withRetries(ctx, m.Limiter, m.MaxRetries, m.BaseBackoff, "matrix "+method+" "+path, func() error {
+		return m.callOnce(ctx, asToken, method, path, body, out)
+	})
+}
+
+func (m *MatrixPlatform) callOnce(ctx context.Context, asToken, method, path string, body []byte, out interface{}) error {
+	client := m.Client
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, m.Homeserver+"/_matrix/client/v3"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+asToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &deliveryErr{msg: err.Error(), temporary: ctx.Err() == nil}
+	}
+	defer resp.Body.Close()
+
+	return checkMatrixResponse(resp, out)
+}
+
+func checkMatrixResponse(resp *http.Response, out interface{}) error {
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &deliveryErr{status: resp.StatusCode, msg: err.Error(), temporary: true}
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(b, out)
+	}
+
+	var me matrixError
+	_ = json.Unmarshal(b, &me)
+	de := &deliveryErr{
+		status:     resp.StatusCode,
+		msg:        strings.TrimSpace(me.ErrCode + " " + me.Error),
+		temporary:  resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+		retryAfter: time.Duration(me.RetryAfterMS) * time.Millisecond,
+	}
+	if de.msg == "" {
+		de.msg = http.StatusText(resp.StatusCode)
+	}
+	return de
+}
@@ -0,0 +1,90 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// how many messages we send at once when a whole batch goes out.
+// the rate limiter still decides how fast they actually go
+const defaultNotifyWorkers = 8
+
+// one message to one recipient (which can be a comma-separated group of emails)
+type Notification struct {
+	To      string
+	Message string
+}
+
+// NotificationResult is how sending one Notification went
+type NotificationResult struct {
+	To   string `json:"to"`
+	Sent bool   `json:"sent"`
+	// it wasn't sent, but it's in the outbox and will be tried again
+	Queued bool   `json:"queued,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NotificationSummary is what the cron handlers log and send back as JSON
+type NotificationSummary struct {
+	Sent   int `json:"sent"`
+	Failed int `json:"failed"`
+	// how many of the failed ones are in the outbox
+	Queued  int                  `json:"queued"`
+	Results []NotificationResult `json:"results"`
+}
+
+// SendAll sends every notification using up to workers goroutines, and
+// reports how each one went, in the same order they were given.
+// Once ctx is done, anything that hasn't been sent yet fails straight away,
+// but it's still handed to un, so an OutboxUserNotification can keep it for later.
+func SendAll(ctx context.Context, un UserNotification, botPassword string, notifications []Notification, workers int) NotificationSummary {
+	if workers < 1 {
+		workers = defaultNotifyWorkers
+	}
+
+	results := make([]NotificationResult, len(notifications))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				n := notifications[i]
+				results[i] = NotificationResult{To: n.To}
+
+				err := un.SendUserMessage(ctx, botPassword, n.To, n.Message)
+				if err != nil {
+					log.Printf("Error when trying to send message to %s: %s\n", n.To, err)
+					var qerr *queuedErr
+					results[i].Queued = errors.As(err, &qerr)
+					results[i].Error = err.Error()
+					continue
+				}
+				results[i].Sent = true
+			}
+		}()
+	}
+
+	for i := range notifications {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	summary := NotificationSummary{Results: results}
+	for _, r := range results {
+		if r.Sent {
+			summary.Sent++
+		} else {
+			summary.Failed++
+		}
+		if r.Queued {
+			summary.Queued++
+		}
+	}
+	return summary
+}
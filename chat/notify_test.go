@@ -0,0 +1,189 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// slowZulip is a fake Zulip that takes a while to answer, and keeps track
+// of how many requests it's handling at once. Messages to anyone with
+// "bounce" in their address fail.
+type slowZulip struct {
+	latency time.Duration
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	received    int
+}
+
+func (z *slowZulip) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	z.mu.Lock()
+	z.inFlight++
+	z.received++
+	if z.inFlight > z.maxInFlight {
+		z.maxInFlight = z.inFlight
+	}
+	z.mu.Unlock()
+
+	select {
+	case <-time.After(z.latency):
+	case <-r.Context().Done():
+	}
+
+	z.mu.Lock()
+	z.inFlight--
+	z.mu.Unlock()
+
+	if strings.Contains(r.FormValue("to"), "bounce") {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"result": "error", "msg": "Invalid email"}`)
+		return
+	}
+	fmt.Fprint(w, zulipSuccess)
+}
+
+func makeNotifications(n int) []Notification {
+	var notifications []Notification
+	for i := 0; i < n; i++ {
+		notifications = append(notifications, Notification{To: fmt.Sprintf("recurser%d@example.com", i), Message: "hi"})
+	}
+	return notifications
+}
+
+func TestSendAll(t *testing.T) {
+	zulip := &slowZulip{latency: 50 * time.Millisecond}
+	srv := httptest.NewServer(zulip)
+	defer srv.Close()
+
+	notifications := makeNotifications(20)
+	notifications[7].To = "bounce@example.com"
+
+	start := time.Now()
+	summary := SendAll(context.Background(), newTestNotification(srv.URL), "password", notifications, 5)
+	elapsed := time.Since(start)
+
+	if summary.Sent != 19 || summary.Failed != 1 {
+		t.Errorf("got %d sent and %d failed, wanted 19 and 1\n", summary.Sent, summary.Failed)
+	}
+	for i, r := range summary.Results {
+		if r.To != notifications[i].To {
+			t.Errorf("result %d is for %v, wanted %v\n", i, r.To, notifications[i].To)
+		}
+		if wantSent := i != 7; r.Sent != wantSent || (r.Error == "") != wantSent {
+			t.Errorf("got result %+v for %v\n", r, notifications[i].To)
+		}
+	}
+
+	if zulip.maxInFlight > 5 {
+		t.Errorf("got %d requests at once, wanted at most 5\n", zulip.maxInFlight)
+	}
+	// one at a time would take a whole second
+	if elapsed > 500*time.Millisecond {
+		t.Errorf("sending took %v, it doesn't look like it happened concurrently\n", elapsed)
+	}
+}
+
+func TestSendAllUsesRateLimiter(t *testing.T) {
+	zulip := &slowZulip{}
+	srv := httptest.NewServer(zulip)
+	defer srv.Close()
+
+	un := newTestNotification(srv.URL)
+	un.limiter = &RateLimiter{interval: 20 * time.Millisecond}
+
+	start := time.Now()
+	summary := SendAll(context.Background(), un, "password", makeNotifications(6), 6)
+	if summary.Sent != 6 {
+		t.Errorf("got %d sent, wanted 6\n", summary.Sent)
+	}
+	// even with a worker each, the limiter spaces them out
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("6 sends took %v, wanted at least 100ms\n", elapsed)
+	}
+}
+
+func TestSendAllStopsWhenCancelled(t *testing.T) {
+	zulip := &slowZulip{latency: 100 * time.Millisecond}
+	srv := httptest.NewServer(zulip)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	summary := SendAll(ctx, newTestNotification(srv.URL), "password", makeNotifications(40), 2)
+	elapsed := time.Since(start)
+
+	if summary.Sent+summary.Failed != 40 || len(summary.Results) != 40 {
+		t.Errorf("got %d results, wanted one for each of the 40 notifications\n", len(summary.Results))
+	}
+	if summary.Failed < 30 {
+		t.Errorf("got %d failed, wanted most of them to fail after the deadline\n", summary.Failed)
+	}
+	if elapsed > time.Second {
+		t.Errorf("sending took %v, it doesn't look like it stopped at the deadline\n", elapsed)
+	}
+
+	zulip.mu.Lock()
+	defer zulip.mu.Unlock()
+	if zulip.received > 10 {
+		t.Errorf("Zulip got %d requests after the deadline passed\n", zulip.received)
+	}
+}
+
+// cancellingUN sends the first few messages it's given, then cancels ctx
+type cancellingUN struct {
+	cancel context.CancelFunc
+	after  int
+	sent   []string
+}
+
+func (c *cancellingUN) SendUserMessage(ctx context.Context, botPassword, user, message string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.sent = append(c.sent, user)
+	if len(c.sent) == c.after {
+		c.cancel()
+	}
+	return nil
+}
+
+func TestSendAllQueuesWhatsLeftWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	odb := storage.NewMemoryOutboxDB()
+	un := &cancellingUN{cancel: cancel, after: 2}
+
+	notifications := makeNotifications(5)
+	summary := SendAll(ctx, &OutboxUserNotification{UN: un, ODB: odb}, "password", notifications, 1)
+	if summary.Sent != 2 || summary.Failed != 3 || summary.Queued != 3 {
+		t.Errorf("got %d sent, %d failed and %d queued, wanted 2, 3 and 3\n", summary.Sent, summary.Failed, summary.Queued)
+	}
+
+	queued, err := odb.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, msg := range queued {
+		got[msg.To] = true
+	}
+	for _, n := range notifications[2:] {
+		if !got[n.To] {
+			t.Errorf("the message to %s isn't in the outbox\n", n.To)
+		}
+	}
+	if len(queued) != 3 {
+		t.Errorf("got %d messages in the outbox, wanted 3\n", len(queued))
+	}
+}
@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// added to messages we email to people because we couldn't reach them on Zulip
+const zulipFallbackNote = "(I couldn't reach you on Zulip, so this came by email instead.)"
+
+// Platform is a chat service, other than Zulip, that people can use Pairing Bot through
+type Platform interface {
+	// SendUserMessage sends a message to one or more of the platform's users, by
+	// their platform user IDs (without the prefix), comma-separated.
+	// It gets Zulip's botPassword like every UserNotification, and ignores it
+	UserNotification
+	// Name is the prefix on the IDs of this platform's users
+	Name() string
+	// ReadCommands authenticates an incoming request and pulls out the commands
+	// in it. There can be none (a handshake, a retry, a message from a bot), and
+	// some platforms batch up more than one. If the platform wants anything but
+	// an empty HTTP 200 back, ReadCommands writes it
+	ReadCommands(w http.ResponseWriter, r *http.Request) ([]IncomingCommand, error)
+	// Reply sends Pairing Bot's response to a command back to where it came from
+	Reply(ctx context.Context, cmd IncomingCommand, response string) error
+}
+
+// IncomingCommand is a message someone sent Pairing Bot on a chat platform
+type IncomingCommand struct {
+	// userID already has the platform prefix on it
+	User UserDataFromJSON
+	Text string
+	// where the reply should go, for platforms that reply with an API call
+	replyTo string
+}
+
+// implements UserNotification
+// PlatformNotification sends each message through the platform its recipients are on,
+// and sends email to "email:" addresses
+type PlatformNotification struct {
+	Zulip     UserNotification
+	Platforms map[string]Platform
+	// nil if email isn't set up
+	Email UserNotification
+}
+
+// SendUserMessage works out which platform user (one or more comma-separated
+// addresses) is on from their prefix, and sends it there. a message Zulip
+// won't take is emailed instead, when email is set up
+func (pn *PlatformNotification) SendUserMessage(ctx context.Context, botPassword, user, message string) error {
+	platform := ""
+	var ids []string
+	for _, address := range strings.Split(user, ",") {
+		address = strings.TrimSpace(address)
+		p := storage.PlatformOf(address)
+		if platform != "" && p != platform {
+			return fmt.Errorf("can't send one message to people on both %s and %s", platform, p)
+		}
+		platform = p
+		ids = append(ids, strings.TrimPrefix(address, p+":"))
+	}
+
+	switch platform {
+	case storage.ZulipPlatform:
+		err := pn.Zulip.SendUserMessage(ctx, botPassword, user, message)
+		if err == nil || pn.Email == nil {
+			return err
+		}
+		// Zulip addresses are email addresses, so we can still reach people when Zulip is down
+		emailErr := pn.Email.SendUserMessage(ctx, botPassword, user, message+"\n\n"+zulipFallbackNote)
+		if emailErr != nil {
+			log.Printf("Could not email %s after Zulip failed either: %s\n", user, emailErr)
+			return err
+		}
+		log.Printf("Emailed %s because Zulip failed: %s\n", user, err)
+		return nil
+
+	case emailPlatform:
+		if pn.Email == nil {
+			return errors.New("email isn't set up")
+		}
+		return pn.Email.SendUserMessage(ctx, botPassword, strings.Join(ids, ","), message)
+	}
+	p, ok := pn.Platforms[platform]
+	if !ok {
+		return fmt.Errorf("no chat platform called %q is set up", platform)
+	}
+	return p.SendUserMessage(ctx, botPassword, strings.Join(ids, ","), message)
+}
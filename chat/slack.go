@@ -0,0 +1,289 @@
+package chat
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// SlackPlatformName is the prefix on Slack users' IDs, like slack:U024BE7LH
+const SlackPlatformName = "slack"
+
+// DefaultSlackAPIURL is Slack's Web API
+const DefaultSlackAPIURL = "https://slack.com/api"
+
+// DefaultSlackSendsPerMinute keeps us under "Tier 3", which most of the
+// methods we use are in, at about 50 a minute
+const DefaultSlackSendsPerMinute = 50
+
+// Slack signs every request it sends us, and we turn down anything signed
+// longer ago than this so old requests can't be replayed
+// https://api.slack.com/authentication/verifying-requests-from-slack
+const slackMaxRequestAge = 5 * time.Minute
+
+// implements Platform
+// SlackPlatform talks to a Slack workspace through the Events API (for
+// messages people send Pairing Bot) and the Web API (for everything we send).
+// It needs the app's signing secret and bot token in the database,
+// at slackauth/signingsecret and slackauth/bottoken
+type SlackPlatform struct {
+	APIURL string
+	ADB    storage.APIAuthDB
+	// nil means DefaultHTTPClient
+	Client      *http.Client
+	Limiter     *RateLimiter
+	MaxRetries  int
+	BaseBackoff time.Duration
+}
+
+// the parts of an Events API request we care about
+// https://api.slack.com/apis/connections/events-api
+type slackEnvelope struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Event     struct {
+		Type        string `json:"type"`
+		Subtype     string `json:"subtype"`
+		ChannelType string `json:"channel_type"`
+		Channel     string `json:"channel"`
+		User        string `json:"user"`
+		BotID       string `json:"bot_id"`
+		Text        string `json:"text"`
+	} `json:"event"`
+}
+
+// every Web API response has these, whether it worked or not
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// Name is "slack"
+func (s *SlackPlatform) Name() string {
+	return SlackPlatformName
+}
+
+// ReadCommands checks an Events API request's signature and answers its URL
+// check. the only events that are commands are people's own DMs to the bot,
+// and Slack's retries are dropped, since we already got the first one
+func (s *SlackPlatform) ReadCommands(w http.ResponseWriter, r *http.Request) ([]IncomingCommand, error) {
+	ctx := r.Context()
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := s.ADB.GetKey(ctx, "slackauth", "signingsecret")
+	if err != nil {
+		return nil, err
+	}
+	err = verifySlackSignature(secret, r.Header, body, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// Slack sends an event again if we took more than 3 seconds to answer it.
+	// we've already handled it, so don't do it twice
+	if r.Header.Get("X-Slack-Retry-Num") != "" {
+		return nil, nil
+	}
+
+	var env slackEnvelope
+	err = json.Unmarshal(body, &env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Slack checks that we own the URL before it sends us any events
+	if env.Type == "url_verification" {
+		w.Header().Set("Content-Type", "text/plain")
+		_, err = io.WriteString(w, env.Challenge)
+		return nil, err
+	}
+
+	// only answer people who DM us. this stops pairing bot from answering
+	// in the group DMs she starts when she matches people, and from
+	// answering herself
+	ev := env.Event
+	if env.Type != "event_callback" || ev.Type != "message" || ev.ChannelType != "im" || ev.BotID != "" || ev.Subtype != "" || ev.User == "" {
+		return nil, nil
+	}
+
+	user, err := s.userInfo(ctx, ev.User)
+	if err != nil {
+		return nil, err
+	}
+	return []IncomingCommand{{
+		User:    user,
+		Text:    ev.Text,
+		replyTo: ev.Channel,
+	}}, nil
+}
+
+// verifySlackSignature checks that a request really came from Slack
+func verifySlackSignature(secret string, h http.Header, body []byte, now time.Time) error {
+	ts := h.Get("X-Slack-Request-Timestamp")
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("missing or bad X-Slack-Request-Timestamp")
+	}
+	age := now.Sub(time.Unix(secs, 0))
+	if age > slackMaxRequestAge || age < -slackMaxRequestAge {
+		return fmt.Errorf("request was signed %v ago", age.Round(time.Second))
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if secret == "" || !hmac.Equal([]byte(want), []byte(h.Get("X-Slack-Signature"))) {
+		return errors.New("bad X-Slack-Signature")
+	}
+	return nil
+}
+
+// userInfo looks up the name and email of the person behind a Slack user ID
+func (s *SlackPlatform) userInfo(ctx context.Context, slackID string) (UserDataFromJSON, error) {
+	var info struct {
+		slackResponse
+		User struct {
+			RealName string `json:"real_name"`
+			Name     string `json:"name"`
+			Profile  struct {
+				Email string `json:"email"`
+			} `json:"profile"`
+		} `json:"user"`
+	}
+	err := s.call(ctx, "users.info", url.Values{"user": {slackID}}, &info)
+	if err != nil {
+		return UserDataFromJSON{}, err
+	}
+
+	name := info.User.RealName
+	if name == "" {
+		name = info.User.Name
+	}
+	return UserDataFromJSON{
+		UserID:    storage.PlatformUserID(SlackPlatformName, slackID),
+		UserEmail: info.User.Profile.Email,
+		UserName:  name,
+	}, nil
+}
+
+// Reply posts the response in the DM the command came from. Slack only
+// wants to hear that we got the event, which the empty HTTP 200 tells it
+func (s *SlackPlatform) Reply(ctx context.Context, cmd IncomingCommand, response string) error {
+	return s.postMessage(ctx, cmd.replyTo, response)
+}
+
+// SendUserMessage messages one or more Slack users (comma-separated IDs).
+// for more than one, that's a group DM with all of them in it
+func (s *SlackPlatform) SendUserMessage(ctx context.Context, botPassword, user, message string) error {
+	var conv struct {
+		slackResponse
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
+	}
+	err := s.call(ctx, "conversations.open", url.Values{"users": {user}}, &conv)
+	if err != nil {
+		return err
+	}
+	return s.postMessage(ctx, conv.Channel.ID, message)
+}
+
+func (s *SlackPlatform) postMessage(ctx context.Context, channel, message string) error {
+	return s.call(ctx, "chat.postMessage", url.Values{
+		"channel": {channel},
+		"text":    {SlackMarkdown(message)},
+	}, nil)
+}
+
+// call calls a Web API method, retrying the same way we do for Zulip.
+// out gets the decoded response, if it's not nil
+func (s *SlackPlatform) call(ctx context.Context, method string, params url.Values, out interface{}) error {
+	token, err := s.ADB.GetKey(ctx, "slackauth", "bottoken")
+	if err != nil {
+		return err
+	}
+	return withRetries(ctx, s.Limiter, s.MaxRetries, s.BaseBackoff, "slack "+method, func() error {
+		return s.callOnce(ctx, token, method, params, out)
+	})
+}
+
+func (s *SlackPlatform) callOnce(ctx context.Context, token, method string, params url.Values, out interface{}) error {
+	client := s.Client
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.APIURL+"/"+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("content-type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &deliveryErr{msg: err.Error(), temporary: ctx.Err() == nil}
+	}
+	defer resp.Body.Close()
+
+	return checkSlackResponse(resp, out)
+}
+
+// checkSlackResponse works out whether a Web API call worked. Slack answers
+// HTTP 200 even when it didn't, with "ok": false and an error code in the body
+func checkSlackResponse(resp *http.Response, out interface{}) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &deliveryErr{status: resp.StatusCode, msg: err.Error(), temporary: true}
+	}
+
+	var sr slackResponse
+	decodeErr := json.Unmarshal(body, &sr)
+	if resp.StatusCode == http.StatusOK && decodeErr == nil && sr.OK {
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(body, out)
+	}
+
+	de := &deliveryErr{
+		status:     resp.StatusCode,
+		msg:        sr.Error,
+		temporary:  resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+		retryAfter: retryAfter(resp.Header),
+	}
+	if de.msg == "" {
+		de.msg = http.StatusText(resp.StatusCode)
+	}
+	return de
+}
+
+var (
+	markdownBold = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	markdownLink = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+)
+
+// SlackMarkdown turns the Zulip-flavoured markdown our messages are written
+// in into Slack's mrkdwn, which does bold and links differently
+func SlackMarkdown(s string) string {
+	s = markdownBold.ReplaceAllString(s, "*$1*")
+	return markdownLink.ReplaceAllString(s, "<$2|$1>")
+}
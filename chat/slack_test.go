@@ -0,0 +1,18 @@
+package chat
+
+import "testing"
+
+func TestSlackMarkdown(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"**Mondays**, **Tuesdays**", "*Mondays*, *Tuesdays*"},
+		{"[submit an issue](https://example.com/issues)!", "<https://example.com/issues|submit an issue>!"},
+		{"`schedule` :)", "`schedule` :)"},
+	}
+	for _, tt := range tests {
+		if got := SlackMarkdown(tt.in); got != tt.want {
+			t.Errorf("slackMarkdown(%q) = %q, wanted %q", tt.in, got, tt.want)
+		}
+	}
+}
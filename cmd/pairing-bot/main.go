@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/commands"
+	"github.com/thwidge/pairing-bot/config"
+	"github.com/thwidge/pairing-bot/metrics"
+	"github.com/thwidge/pairing-bot/server"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// It's alive! The application starts here.
+func main() {
+	configPath := flag.String("config", "", "the config file (PB_CONFIG, or "+config.DefaultPath+", by default)")
+	checkConfig := flag.Bool("check-config", false, "check the config, print it and quit")
+	flag.Parse()
+
+	// everything that isn't a secret comes from here, and if anything's
+	// missing we'd rather not start at all
+	cfg, err := config.Load(*configPath, os.LookupEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *checkConfig {
+		fmt.Print(cfg)
+		fmt.Println("the config is OK")
+		return
+	}
+
+	ctx := context.Background()
+
+	// setting up database connection: 2 clients encapsulated into PairingLogic struct
+
+	rc, err := firestore.NewClient(ctx, cfg.Project)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer rc.Close()
+
+	ac, err := firestore.NewClient(ctx, cfg.Project)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer ac.Close()
+
+	frdb := &storage.FirestoreRecurserDB{
+		Client: rc,
+	}
+
+	// these two are timed and counted for /metrics
+	rdb := &metrics.InstrumentedRecurserDB{
+		RDB: frdb,
+	}
+
+	adb := &metrics.InstrumentedAPIAuthDB{
+		ADB: &storage.FirestoreAPIAuthDB{
+			Client: ac,
+		},
+	}
+
+	err = metrics.WatchSubscribers(frdb)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	mdb := &storage.FirestoreMatchDB{
+		Client: rc,
+	}
+
+	audit := &storage.FirestoreAuditDB{
+		Client: ac,
+	}
+
+	cdb := &storage.FirestoreConfigDB{
+		Client: ac,
+	}
+
+	rooms := &storage.FirestoreRoomDB{
+		Client: rc,
+	}
+
+	ur := &chat.ZulipUserRequest{}
+
+	zun := chat.NewZulipNotification(cfg.Zulip.BotUsername, cfg.Zulip.APIURL)
+
+	// slack.enabled turns on the slack app as well. it reads its secrets from
+	// the slackauth collection, and slack.api_url can point it at a fake
+	var platforms []chat.Platform
+	if cfg.Slack.Enabled {
+		slackAPIURL := chat.DefaultSlackAPIURL
+		if cfg.Slack.APIURL != "" {
+			slackAPIURL = cfg.Slack.APIURL
+		}
+		platforms = append(platforms, &chat.SlackPlatform{
+			APIURL:      slackAPIURL,
+			ADB:         adb,
+			Client:      &http.Client{Timeout: 30 * time.Second},
+			Limiter:     chat.NewRateLimiter(chat.DefaultSlackSendsPerMinute),
+			MaxRetries:  chat.DefaultMaxRetries,
+			BaseBackoff: chat.DefaultBaseBackoff,
+		})
+	}
+
+	// matrix.homeserver turns on the matrix application service, as
+	// matrix.user (like @pairing-bot:example.org). it reads its tokens
+	// from the matrixauth collection
+	if cfg.Matrix.Homeserver != "" {
+		platforms = append(platforms, &chat.MatrixPlatform{
+			Homeserver:  strings.TrimSuffix(cfg.Matrix.Homeserver, "/"),
+			BotUserID:   cfg.Matrix.User,
+			ADB:         adb,
+			Rooms:       rooms,
+			Client:      &http.Client{Timeout: 30 * time.Second},
+			Limiter:     chat.NewRateLimiter(chat.DefaultMatrixSendsPerMinute),
+			MaxRetries:  chat.DefaultMaxRetries,
+			BaseBackoff: chat.DefaultBaseBackoff,
+		})
+	}
+
+	// discord.app_id turns on the discord app. it starts match threads in
+	// discord.channel, and reads its secrets from the discordauth collection
+	if cfg.Discord.AppID != "" {
+		discord := &chat.DiscordPlatform{
+			APIURL:      chat.DefaultDiscordAPIURL,
+			AppID:       cfg.Discord.AppID,
+			ChannelID:   cfg.Discord.Channel,
+			Commands:    commands.DiscordCommands,
+			ADB:         adb,
+			Client:      &http.Client{Timeout: 30 * time.Second},
+			Limiter:     chat.NewRateLimiter(chat.DefaultDiscordSendsPerMinute),
+			MaxRetries:  chat.DefaultMaxRetries,
+			BaseBackoff: chat.DefaultBaseBackoff,
+		}
+		// keep discord's slash commands in step with ours
+		err = discord.RegisterCommands(ctx)
+		if err != nil {
+			log.Printf("Could not register discord's slash commands: %s\n", err)
+		}
+		platforms = append(platforms, discord)
+	}
+
+	// messages go out on whichever platform their recipients are on
+	pun := &chat.PlatformNotification{
+		Zulip:     &metrics.InstrumentedUserNotification{UN: zun, Platform: storage.ZulipPlatform},
+		Platforms: map[string]chat.Platform{},
+	}
+	for _, p := range platforms {
+		pun.Platforms[p.Name()] = p
+	}
+
+	// smtp.addr (host:port) turns on email, from smtp.from. if the server
+	// wants us to log in, that's as smtp.username with the password in smtpauth/password
+	if addr := cfg.SMTP.Addr; addr != "" {
+		var auth smtp.Auth
+		if username := cfg.SMTP.Username; username != "" {
+			password, err := adb.GetKey(ctx, "smtpauth", "password")
+			if err != nil {
+				log.Printf("Could not read the SMTP password: %s\n", err)
+			}
+			host, _, _ := net.SplitHostPort(addr)
+			auth = smtp.PlainAuth("", username, password, host)
+		}
+		pun.Email = &metrics.InstrumentedUserNotification{
+			UN: &chat.SMTPUserNotification{
+				Addr:        addr,
+				From:        cfg.SMTP.From,
+				Auth:        auth,
+				MaxRetries:  chat.DefaultMaxRetries,
+				BaseBackoff: chat.DefaultBaseBackoff,
+			},
+			Platform: "email",
+		}
+	}
+
+	// anything that still can't be sent after retrying goes into the outbox
+	un := &chat.OutboxUserNotification{
+		UN: pun,
+		ODB: &storage.FirestoreOutboxDB{
+			Client: rc,
+		},
+	}
+
+	// directory.url points at a Recurse-style profiles API (with its token in
+	// directoryauth/token), or directory.file at a JSON file of profiles
+	var directory commands.Directory
+	if u := cfg.Directory.URL; u != "" {
+		directory = commands.NewCachingDirectory(&commands.HTTPDirectory{
+			BaseURL: strings.TrimSuffix(u, "/"),
+			ADB:     adb,
+			Client:  &http.Client{Timeout: 30 * time.Second},
+		}, commands.DefaultDirectoryTTL)
+	} else if f := cfg.Directory.File; f != "" {
+		sd, err := commands.LoadStaticDirectory(f)
+		if err != nil {
+			log.Panic(err)
+		}
+		directory = sd
+	}
+
+	pl := &commands.PairingLogic{
+		Config:            *cfg,
+		RDB:               rdb,
+		ADB:               adb,
+		MDB:               mdb,
+		CDB:               cdb,
+		Audit:             audit,
+		UR:                ur,
+		UN:                un,
+		Streams:           zun,
+		Outbox:            un,
+		Admins:            cfg.AllAdmins(),
+		Platforms:         platforms,
+		Rooms:             rooms,
+		EmailEnabled:      pun.Email != nil,
+		Directory:         directory,
+		OffboardRetention: time.Duration(cfg.OffboardRetentionDays) * 24 * time.Hour,
+	}
+
+	log.Printf("Listening on port %s", cfg.Port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", cfg.Port), (&server.Server{PairingLogic: pl}).Routes()))
+}
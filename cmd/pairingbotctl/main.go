@@ -0,0 +1,591 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/commands"
+	"github.com/thwidge/pairing-bot/config"
+	"github.com/thwidge/pairing-bot/internal/format"
+	"github.com/thwidge/pairing-bot/matching"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// pairingbotctl is for admin jobs that would otherwise mean poking at
+// Firestore by hand, like:
+//   pairingbotctl list
+//   pairingbotctl match -dry-run -date 2024-03-04
+//   pairingbotctl export -o backup.ndjson
+// every command works on a backend: Firestore (the config's project, or
+// -project), or a backup loaded into memory with -backend file:backup.ndjson,
+// which is read-only, since nothing that's changed in memory would be saved
+
+const cliUsage = `usage:
+  pairingbotctl list                             list every subscriber
+  pairingbotctl show <user>                      show one subscriber, by ID or email
+  pairingbotctl set-schedule <user> <day>...     set someone's schedule, like: set-schedule 215391 monday friday
+  pairingbotctl delete -yes <user>               unsubscribe someone, without telling them
+  pairingbotctl match -dry-run [-date day]       show who would be matched on a day (tomorrow by default)
+  pairingbotctl simulate [-date day] [-days n] [-seed n]
+                                                 match everyone for n days (28 by default) and show how it went
+  pairingbotctl send-test-message <user> [message]
+                                                 send someone on Zulip a message from the bot
+  pairingbotctl export [-o file]                 back up every recurser, match and the config (to stdout without -o)
+  pairingbotctl import [-dry-run] <file>         check a backup and load it into the database
+  pairingbotctl migrate [-dry-run]               bring every subscriber's document up to the current schema version
+
+every command also takes:
+  -backend firestore|file:<backup>               where the data is (firestore by default)
+  -config file                                   the bot's config, for the Firestore project and Zulip
+  -project id                                    the Firestore project (the config's by default)
+  -json                                          print JSON instead of a table`
+
+const testMessage = "This is a test message from Pairing Bot. You don't need to do anything :)"
+
+// cliBackend is the databases a command works on
+type cliBackend struct {
+	rdb storage.RecurserDB
+	mdb storage.MatchDB
+	cdb storage.ConfigDB
+	adb storage.APIAuthDB
+	// nil for backends that don't need closing
+	client *firestore.Client
+	// true for file: backends, where changes would just be thrown away
+	readOnly bool
+}
+
+func (b *cliBackend) Close() {
+	if b.client != nil {
+		b.client.Close()
+	}
+}
+
+// openBackend opens "firestore", or "file:<backup>" as in-memory databases
+func openBackend(ctx context.Context, backend, project string) (*cliBackend, error) {
+	if backend == "firestore" {
+		client, err := firestore.NewClient(ctx, project)
+		if err != nil {
+			return nil, err
+		}
+		return &cliBackend{
+			rdb:    &storage.FirestoreRecurserDB{Client: client},
+			mdb:    &storage.FirestoreMatchDB{Client: client},
+			cdb:    &storage.FirestoreConfigDB{Client: client},
+			adb:    &storage.FirestoreAPIAuthDB{Client: client},
+			client: client,
+		}, nil
+	}
+
+	if !strings.HasPrefix(backend, "file:") {
+		return nil, fmt.Errorf("%q isn't a backend. Use firestore or file:<backup>", backend)
+	}
+	f, err := os.Open(strings.TrimPrefix(backend, "file:"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &cliBackend{
+		rdb:      storage.NewMemoryRecurserDB(),
+		mdb:      storage.NewMemoryMatchDB(),
+		cdb:      storage.NewMemoryConfigDB(),
+		adb:      storage.NewMemoryAPIAuthDB(),
+		readOnly: true,
+	}
+	_, err = storage.ImportBackup(ctx, b.rdb, b.mdb, b.cdb, f, false)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// cliCommand is what every command gets: its flags, parsed, and the backend
+type cliCommand struct {
+	flags      *flag.FlagSet
+	backend    *string
+	configPath *string
+	project    *string
+	json       *bool
+	out        io.Writer
+}
+
+func newCLICommand(name string, out io.Writer) *cliCommand {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	return &cliCommand{
+		flags:      flags,
+		backend:    flags.String("backend", "firestore", "firestore, or file:<backup> to work on a backup in memory"),
+		configPath: flags.String("config", "", "the bot's config file (PB_CONFIG, or "+config.DefaultPath+", by default)"),
+		project:    flags.String("project", "", "the Firestore project (the config's by default)"),
+		json:       flags.Bool("json", false, "print JSON instead of a table"),
+		out:        out,
+	}
+}
+
+// parse parses the flags, checks there are between min and max arguments
+// (max < 0 means any number), and opens the backend
+func (c *cliCommand) parse(ctx context.Context, args []string, min, max int) (*cliBackend, error) {
+	err := c.flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if c.flags.NArg() < min || (max >= 0 && c.flags.NArg() > max) {
+		return nil, fmt.Errorf("wrong number of arguments to %v\n%v", c.flags.Name(), cliUsage)
+	}
+	project := *c.project
+	if *c.backend == "firestore" && project == "" {
+		cfg, err := config.Load(*c.configPath, os.LookupEnv)
+		if err != nil {
+			return nil, err
+		}
+		project = cfg.Project
+	}
+	return openBackend(ctx, *c.backend, project)
+}
+
+// print writes v as JSON with -json, and otherwise as a table of rows under header
+func (c *cliCommand) print(v interface{}, header []string, rows [][]string) error {
+	if *c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	if header != nil {
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// printf is for messages that aren't data, so it's quiet with -json
+func (c *cliCommand) printf(format string, a ...interface{}) {
+	if !*c.json {
+		fmt.Fprintf(c.out, format, a...)
+	}
+}
+
+func main() {
+	err := runCLI(context.Background(), os.Args[1:], os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func runCLI(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(cliUsage)
+	}
+	c := newCLICommand(args[0], out)
+	switch args[0] {
+	case "list":
+		return c.list(ctx, args[1:])
+	case "show":
+		return c.show(ctx, args[1:])
+	case "set-schedule":
+		return c.setSchedule(ctx, args[1:])
+	case "delete":
+		return c.delete(ctx, args[1:])
+	case "match":
+		return c.match(ctx, args[1:])
+	case "simulate":
+		return c.simulate(ctx, args[1:])
+	case "send-test-message":
+		return c.sendTestMessage(ctx, args[1:])
+	case "export":
+		return c.export(ctx, args[1:])
+	case "import":
+		return c.importBackup(ctx, args[1:])
+	case "migrate":
+		return c.migrate(ctx, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprintln(out, cliUsage)
+		return nil
+	default:
+		return fmt.Errorf("I don't know how to %q\n%v", args[0], cliUsage)
+	}
+}
+
+// lookUp finds a subscriber by ID or email
+func lookUp(ctx context.Context, rdb storage.RecurserDB, idOrEmail string) (storage.Recurser, error) {
+	recursersList, err := rdb.GetAllUsers(ctx)
+	if err != nil {
+		return storage.Recurser{}, err
+	}
+	rec, ok := commands.FindRecurser(recursersList, idOrEmail)
+	if !ok {
+		return storage.Recurser{}, fmt.Errorf("there's no subscriber with the ID or email %q", idOrEmail)
+	}
+	rec.IsSubscribed = true
+	return rec, nil
+}
+
+func recurserRow(r storage.Recurser) []string {
+	return []string{r.ID, r.Name, r.Email, commands.ShortSchedule(r.Schedule), format.YesNo(r.IsSkippingTomorrow), orDash(r.Batch)}
+}
+
+var recurserHeader = []string{"ID", "NAME", "EMAIL", "SCHEDULE", "SKIPPING", "BATCH"}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func (c *cliCommand) list(ctx context.Context, args []string) error {
+	b, err := c.parse(ctx, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	recursersList, err := b.rdb.GetAllUsers(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(recursersList, func(i, j int) bool { return recursersList[i].ID < recursersList[j].ID })
+
+	all := []storage.BackupRecurser{}
+	var rows [][]string
+	for _, r := range recursersList {
+		all = append(all, storage.ToBackupRecurser(r))
+		rows = append(rows, recurserRow(r))
+	}
+	return c.print(all, recurserHeader, rows)
+}
+
+func (c *cliCommand) show(ctx context.Context, args []string) error {
+	b, err := c.parse(ctx, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	rec, err := lookUp(ctx, b.rdb, c.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	matches, err := b.mdb.ListByUserID(ctx, rec.ID)
+	if err != nil {
+		return err
+	}
+	s := commands.ComputeStats(matches, rec.ID)
+
+	batch := "-"
+	if rec.Batch != "" {
+		batch = fmt.Sprintf("%v, ends %v", rec.Batch, format.Date(rec.BatchEnd))
+	}
+	return c.print(storage.ToBackupRecurser(rec), nil, [][]string{
+		{"ID", rec.ID},
+		{"Name", rec.Name},
+		{"Email", rec.Email},
+		{"Schedule", commands.ShortSchedule(rec.Schedule)},
+		{"Skipping tomorrow", format.YesNo(rec.IsSkippingTomorrow)},
+		{"Notify via", rec.NotifyVia},
+		{"Batch", batch},
+		{"Asked to stay", format.YesNo(rec.AskedToStay)},
+		{"Last odd one out", format.Date(rec.LastOddOneOut)},
+		{"Times matched", fmt.Sprint(s.Matches)},
+		{"No-shows", fmt.Sprint(s.NoShows)},
+	})
+}
+
+func (c *cliCommand) setSchedule(ctx context.Context, args []string) error {
+	b, err := c.parse(ctx, args, 2, -1)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	schedule := map[string]interface{}{}
+	for _, day := range storage.Weekdays() {
+		schedule[day] = false
+	}
+	for _, day := range c.flags.Args()[1:] {
+		day = strings.ToLower(day)
+		if _, ok := schedule[day]; !ok {
+			return fmt.Errorf("%q isn't a day", day)
+		}
+		schedule[day] = true
+	}
+
+	rec, err := lookUp(ctx, b.rdb, c.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	err = c.canChange(b)
+	if err != nil {
+		return err
+	}
+	rec.Schedule = schedule
+	err = b.rdb.Set(ctx, rec.ID, rec)
+	if err != nil {
+		return err
+	}
+	return c.print(storage.ToBackupRecurser(rec), recurserHeader, [][]string{recurserRow(rec)})
+}
+
+func (c *cliCommand) delete(ctx context.Context, args []string) error {
+	yes := c.flags.Bool("yes", false, "really delete them")
+	b, err := c.parse(ctx, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	rec, err := lookUp(ctx, b.rdb, c.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !*yes {
+		return fmt.Errorf("this would unsubscribe %v (%v) without telling them. Add -yes if that's what you want", rec.Name, rec.ID)
+	}
+	err = c.canChange(b)
+	if err != nil {
+		return err
+	}
+	err = b.rdb.Delete(ctx, rec.ID)
+	if err != nil {
+		return err
+	}
+	c.printf("Unsubscribed %v (%v)\n", rec.Name, rec.ID)
+	return nil
+}
+
+// cliDate parses -date, which defaults to tomorrow, like the next /match run
+func cliDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Now().UTC().AddDate(0, 0, 1).Truncate(24 * time.Hour), nil
+	}
+	return time.Parse(storage.BatchDateLayout, date)
+}
+
+// cliPair is one pair in `match` and `simulate`'s JSON
+type cliPair struct {
+	IDs   [2]string `json:"ids"`
+	Names [2]string `json:"names"`
+}
+
+type cliMatchPlan struct {
+	Date       string    `json:"date"`
+	Pairs      []cliPair `json:"pairs"`
+	OddOneOuts []string  `json:"oddOneOuts"`
+}
+
+func (c *cliCommand) match(ctx context.Context, args []string) error {
+	dryRun := c.flags.Bool("dry-run", false, "only show who would be matched")
+	date := c.flags.String("date", "", "the day to match for, like 2024-03-04 (tomorrow by default)")
+	b, err := c.parse(ctx, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	if !*dryRun {
+		return errors.New("matching for real (and messaging everyone) is up to /match. Add -dry-run to see who would be matched")
+	}
+	day, err := cliDate(*date)
+	if err != nil {
+		return err
+	}
+
+	plan, err := matching.PlanDay(ctx, b.rdb, b.mdb, day, rand.New(rand.NewSource(time.Now().UnixNano())))
+	if err != nil {
+		return err
+	}
+
+	out := cliMatchPlan{Date: day.Format(storage.BatchDateLayout), Pairs: []cliPair{}, OddOneOuts: []string{}}
+	var rows [][]string
+	for _, p := range plan.Pairs {
+		out.Pairs = append(out.Pairs, cliPair{[2]string{p[0].ID, p[1].ID}, [2]string{p[0].Name, p[1].Name}})
+		rows = append(rows, []string{p[0].Name + " (" + p[0].ID + ")", p[1].Name + " (" + p[1].ID + ")"})
+	}
+	for _, r := range plan.OddOneOuts {
+		out.OddOneOuts = append(out.OddOneOuts, r.ID)
+		rows = append(rows, []string{r.Name + " (" + r.ID + ")", "odd one out"})
+	}
+	c.printf("On %v, with a random shuffle (every run is different):\n\n", day.Format("Monday, January 2"))
+	return c.print(out, []string{"PERSON", "PARTNER"}, rows)
+}
+
+func (c *cliCommand) simulate(ctx context.Context, args []string) error {
+	date := c.flags.String("date", "", "the first day, like 2024-03-04 (tomorrow by default)")
+	days := c.flags.Int("days", 28, "how many days to simulate")
+	seed := c.flags.Int64("seed", 0, "the random seed, so a simulation can be repeated (random by default)")
+	b, err := c.parse(ctx, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	from, err := cliDate(*date)
+	if err != nil {
+		return err
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	sim, err := matching.SimulateMatching(ctx, b.rdb, b.mdb, from, *days, rand.New(rand.NewSource(*seed)))
+	if err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, r := range sim.Recursers {
+		rows = append(rows, []string{r.ID, r.Name, fmt.Sprint(r.Matches), fmt.Sprint(r.Partners), fmt.Sprint(r.OddOneOuts), fmt.Sprint(r.Repeats)})
+	}
+	c.printf("%d days from %v (seed %d): %d pairs and %d odd ones out\n\n", sim.Days, sim.From, *seed, sim.Pairs, sim.OddOneOuts)
+	return c.print(sim, []string{"ID", "NAME", "MATCHES", "PARTNERS", "ODD ONE OUT", "REPEATS"}, rows)
+}
+
+func (c *cliCommand) sendTestMessage(ctx context.Context, args []string) error {
+	b, err := c.parse(ctx, args, 1, -1)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	rec, err := lookUp(ctx, b.rdb, c.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if storage.PlatformOf(rec.ID) != storage.ZulipPlatform {
+		return fmt.Errorf("%v is on %v, and I can only send test messages on Zulip", rec.Name, storage.PlatformOf(rec.ID))
+	}
+	message := testMessage
+	if c.flags.NArg() > 1 {
+		message = strings.Join(c.flags.Args()[1:], " ")
+	}
+
+	botPassword, err := b.adb.GetKey(ctx, "apiauth", "key")
+	if err != nil {
+		return fmt.Errorf("couldn't read the bot's API key: %w", err)
+	}
+	cfg, err := config.Load(*c.configPath, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	err = chat.NewZulipNotification(cfg.Zulip.BotUsername, cfg.Zulip.APIURL).SendUserMessage(ctx, botPassword, rec.Address(), message)
+	if err != nil {
+		return err
+	}
+	c.printf("Sent %v (%v) a message\n", rec.Name, rec.Address())
+	return nil
+}
+
+func (c *cliCommand) export(ctx context.Context, args []string) error {
+	o := c.flags.String("o", "", "the file to write the backup to")
+	b, err := c.parse(ctx, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	w := c.out
+	if *o != "" {
+		f, err := os.Create(*o)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	summary, err := storage.ExportBackup(ctx, b.rdb, b.mdb, b.cdb, w)
+	if err != nil {
+		return err
+	}
+	// stdout might be the backup, so this goes to stderr
+	fmt.Fprintf(os.Stderr, "Exported %v\n", summary)
+	return nil
+}
+
+func (c *cliCommand) importBackup(ctx context.Context, args []string) error {
+	dryRun := c.flags.Bool("dry-run", false, "check the backup and say what would be imported, without changing anything")
+	b, err := c.parse(ctx, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	if !*dryRun {
+		err = c.canChange(b)
+		if err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(c.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	summary, err := storage.ImportBackup(ctx, b.rdb, b.mdb, b.cdb, f, *dryRun)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		c.printf("Would import %v. Nothing was changed\n", summary)
+	} else {
+		c.printf("Imported %v\n", summary)
+	}
+	return c.printJSON(summary)
+}
+
+func (c *cliCommand) migrate(ctx context.Context, args []string) error {
+	dryRun := c.flags.Bool("dry-run", false, "count the documents that need migrating, without changing anything")
+	b, err := c.parse(ctx, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	if !*dryRun {
+		err = c.canChange(b)
+		if err != nil {
+			return err
+		}
+	}
+	migrated, err := b.rdb.Migrate(ctx, *dryRun)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		c.printf("Would migrate %d documents to version %d. Nothing was changed\n", migrated, storage.CurrentRecurserSchema)
+	} else {
+		c.printf("Migrated %d documents to version %d\n", migrated, storage.CurrentRecurserSchema)
+	}
+	return c.printJSON(map[string]int{"migrated": migrated, "version": storage.CurrentRecurserSchema})
+}
+
+// canChange stops a command from changing a backup loaded into memory, which
+// would look like it worked and then be thrown away
+func (c *cliCommand) canChange(b *cliBackend) error {
+	if b.readOnly {
+		return fmt.Errorf("%v would only change the copy of %v in memory, which isn't saved. Run it with -backend firestore", c.flags.Name(), strings.TrimPrefix(*c.backend, "file:"))
+	}
+	return nil
+}
+
+// printJSON is for commands whose table is a sentence: it only prints with -json
+func (c *cliCommand) printJSON(v interface{}) error {
+	if !*c.json {
+		return nil
+	}
+	return c.print(v, nil, nil)
+}
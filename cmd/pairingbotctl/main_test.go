@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/matching"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// newFixture is Ada, who's in a batch, Bea, who pairs on Saturdays too, and Cat,
+// who's been offboarded, with a couple of matches between them
+func newFixture() (*storage.MemoryRecurserDB, *storage.MemoryMatchDB, *storage.MemoryConfigDB) {
+	ctx := context.Background()
+	rdb, mdb, cdb := storage.NewMemoryRecurserDB(), storage.NewMemoryMatchDB(), storage.NewMemoryConfigDB()
+	day := time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC)
+
+	ada := storage.NewRecurser("2", "ada@example.com", "Ada")
+	ada.NotifyVia = storage.NotifyViaEmail
+	ada.Batch = "W1'24"
+	ada.BatchEnd = time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC)
+	bea := storage.NewRecurser("3", "bea@example.com", "Bea")
+	bea.Schedule["saturday"] = true
+	cat := storage.NewRecurser("4", "cat@example.com", "Cat")
+	for _, r := range []storage.Recurser{ada, bea, cat} {
+		rdb.Set(ctx, r.ID, r)
+	}
+	rdb.Offboard(ctx, cat.ID, storage.ReasonNoStay, day)
+
+	mdb.Add(ctx, storage.Match{
+		Date:   day,
+		IDs:    []string{"2", "3"},
+		Names:  []string{"Ada", "Bea"},
+		Emails: []string{"ada@example.com", "bea@example.com"},
+	})
+	mdb.Add(ctx, storage.Match{
+		Date:   day.AddDate(0, 0, 1),
+		IDs:    []string{"3", "4"},
+		Names:  []string{"Bea", "Cat"},
+		Emails: []string{"bea@example.com", "cat@example.com"},
+	})
+	return rdb, mdb, cdb
+}
+
+// fixtureBackend writes newFixture out as a backup, for -backend file:
+func fixtureBackend(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "pairing-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	var backup bytes.Buffer
+	rdb, mdb, cdb := newFixture()
+	_, err = storage.ExportBackup(context.Background(), rdb, mdb, cdb, &backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "backup.ndjson")
+	err = ioutil.WriteFile(path, backup.Bytes(), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "file:" + path
+}
+
+func TestCLI(t *testing.T) {
+	backend := fixtureBackend(t)
+	tests := []struct {
+		testName string
+		args     []string
+		// everything here should be in the output
+		wanted []string
+		// or in the error
+		wantErr string
+	}{
+		{"list", []string{"list"}, []string{"ID  NAME  EMAIL", "2   Ada   ada@example.com  Mon Tue Wed Thu Fri      no        W1'24"}, ""},
+		{"list_json", []string{"list", "-json"}, []string{`"id": "3"`, `"saturday": true`}, ""},
+		{"show", []string{"show", "bea@example.com"}, []string{"Name               Bea", "Times matched      2"}, ""},
+		{"show_nobody", []string{"show", "4"}, nil, `no subscriber with the ID or email "4"`},
+		{"set_schedule", []string{"set-schedule", "2", "Monday", "friday"}, nil, "set-schedule would only change the copy"},
+		{"set_schedule_bad_day", []string{"set-schedule", "2", "caturday"}, nil, `"caturday" isn't a day`},
+		{"delete_without_yes", []string{"delete", "2"}, nil, "Add -yes"},
+		{"delete", []string{"delete", "-yes", "2"}, nil, "delete would only change the copy"},
+		{"match_for_real", []string{"match"}, nil, "Add -dry-run"},
+		{"match_saturday", []string{"match", "-dry-run", "-date", "2024-03-02", "-json"}, []string{`"oddOneOuts": [` + "\n" + `    "3"`, `"pairs": []`}, ""},
+		{"match_monday", []string{"match", "-dry-run", "-date", "2024-03-04"}, []string{"PERSON", "Ada (2)"}, ""},
+		{"migrate", []string{"migrate", "-dry-run"}, []string{"Would migrate 0 documents"}, ""},
+		{"migrate_for_real", []string{"migrate"}, nil, "migrate would only change the copy"},
+		{"import_for_real", []string{"import", "backup.ndjson"}, nil, "import would only change the copy"},
+		{"unknown", []string{"frobnicate"}, nil, `I don't know how to "frobnicate"`},
+		{"bad_backend", []string{"list", "-backend", "postgres"}, nil, `"postgres" isn't a backend`},
+		{"wrong_args", []string{"show"}, nil, "wrong number of arguments to show"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			// the backend has to go before the other arguments
+			args := tt.args
+			if tt.testName != "unknown" && tt.testName != "bad_backend" {
+				args = append([]string{args[0], "-backend", backend}, args[1:]...)
+			}
+			var out bytes.Buffer
+			err := runCLI(context.Background(), args, &out)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("got %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("got %v, wanted an error about %q", err, tt.wantErr)
+			}
+			for _, w := range tt.wanted {
+				if !strings.Contains(out.String(), w) {
+					t.Errorf("wanted %q in:\n%s", w, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCLISimulate(t *testing.T) {
+	backend := fixtureBackend(t)
+
+	// a week from a Monday. Ada pairs on weekdays, and Bea on weekdays and
+	// Saturdays, so there are five pairs and Bea is alone on Saturday
+	var out bytes.Buffer
+	err := runCLI(context.Background(), []string{"simulate", "-backend", backend, "-date", "2024-03-04", "-days", "7", "-seed", "1", "-json"}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sim matching.Simulation
+	err = json.Unmarshal(out.Bytes(), &sim)
+	if err != nil {
+		t.Fatalf("simulate didn't print JSON: %s\n%s", err, out.String())
+	}
+	if sim.Pairs != 5 || sim.OddOneOuts != 1 || len(sim.Recursers) != 2 {
+		t.Fatalf("got %+v", sim)
+	}
+	ada, bea := sim.Recursers[0], sim.Recursers[1]
+	if ada.Matches != 5 || ada.Partners != 1 || ada.Repeats != 4 || bea.OddOneOuts != 1 {
+		t.Errorf("got %+v and %+v", ada, bea)
+	}
+}
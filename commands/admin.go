@@ -0,0 +1,322 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/internal/format"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const adminUnsubscribedMessage = "Hi! One of Pairing Bot's admins has unsubscribed you, so I won't find pairing partners for you anymore.\n\nIf you think this was a mistake, or you'd like to start again, just send me a message that says `subscribe`."
+
+func (pl *PairingLogic) isAdmin(userID string) bool {
+	return contains(pl.Admins, userID)
+}
+
+// dispatchAdmin runs `admin` commands. The caller has already checked that the
+// user is an admin, and ParseCmd() has already validated the arguments.
+// Everything that goes through here is written to the audit log first.
+func dispatchAdmin(ctx context.Context, pl *PairingLogic, cmdArgs []string, userID string, userName string) (string, error) {
+	err := pl.Audit.Add(ctx, storage.AuditEntry{
+		Date:      time.Now(),
+		AdminID:   userID,
+		AdminName: userName,
+		Action:    strings.Join(cmdArgs, " "),
+	})
+	if err != nil {
+		// if we can't keep a record of it, we don't do it
+		return pl.writeErrorMessage(), err
+	}
+
+	switch cmdArgs[0] {
+	case "list":
+		recursersList, err := pl.RDB.GetAllUsers(ctx)
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+		return formatRecursersTable(recursersList), nil
+
+	case "show":
+		recursersList, err := pl.RDB.GetAllUsers(ctx)
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+		rec, ok := FindRecurser(recursersList, cmdArgs[1])
+		if !ok {
+			return fmt.Sprintf("I couldn't find a subscriber with the ID or email `%v`", cmdArgs[1]), nil
+		}
+		matches, err := pl.MDB.ListByUserID(ctx, rec.ID)
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+		return formatRecurserDetails(rec, ComputeStats(matches, rec.ID)), nil
+
+	case "unsubscribe":
+		recursersList, err := pl.RDB.GetAllUsers(ctx)
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+		rec, ok := FindRecurser(recursersList, cmdArgs[1])
+		if !ok {
+			return fmt.Sprintf("I couldn't find a subscriber with the ID or email `%v`", cmdArgs[1]), nil
+		}
+		err = pl.RDB.Delete(ctx, rec.ID)
+		if err != nil {
+			return pl.writeErrorMessage(), err
+		}
+
+		botPassword, err := pl.ADB.GetKey(ctx, "apiauth", "key")
+		if err != nil {
+			log.Println("Something weird happened trying to read the auth token from the database")
+		}
+		err = pl.UN.SendUserMessage(ctx, botPassword, rec.Address(), adminUnsubscribedMessage)
+		if err != nil {
+			log.Printf("Error when trying to send unsubscribed message to %s: %s\n", rec.Address(), err)
+		}
+		return fmt.Sprintf("Done! %v is unsubscribed.", rec.Name), nil
+
+	case "match":
+		summary := pl.RunMatch(ctx)
+		return fmt.Sprintf("Done! I made **%d** pairs and sent **%d** messages (%d failed).", summary.Pairs, summary.Notifications.Sent, summary.Notifications.Failed), nil
+
+	case "endofbatch":
+		// on its own, it only makes a plan
+		if len(cmdArgs) == 1 {
+			plan, err := pl.PlanEndOfBatch(ctx, userName)
+			if err != nil {
+				return pl.writeErrorMessage(), err
+			}
+			return FormatPlan(plan, pl.Retention()), nil
+		}
+
+		plan, err := pl.CDB.GetEndOfBatchPlan(ctx)
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+		switch cmdArgs[1] {
+		case "status":
+			return FormatPlan(plan, pl.Retention()), nil
+		case "cancel":
+			if plan.Code == "" {
+				return FormatPlan(plan, pl.Retention()), nil
+			}
+			err = pl.CDB.SetEndOfBatchPlan(ctx, storage.EndOfBatchPlan{})
+			if err != nil {
+				return pl.writeErrorMessage(), err
+			}
+			return fmt.Sprintf("OK, I threw away end-of-batch plan `%v`. Nobody was offboarded.", plan.Code), nil
+		}
+
+		// confirm
+		if problem := planProblem(plan, cmdArgs[2], time.Now()); problem != "" {
+			return problem, nil
+		}
+		summary, err := pl.RunEndOfBatch(ctx, plan)
+		if err != nil {
+			return pl.writeErrorMessage(), err
+		}
+		message := fmt.Sprintf("Done! I offboarded **%d** people, asked **%d** whether they're staying, and sent **%d** messages (%d failed).", summary.Offboarded, summary.Prompted, summary.Notifications.Sent, summary.Notifications.Failed)
+		if summary.Skipped > 0 {
+			message += fmt.Sprintf(" I left **%d** people alone because they'd changed since the plan was made.", summary.Skipped)
+		}
+		return message, nil
+
+	case "offboarded":
+		offboarded, err := pl.RDB.ListOffboarded(ctx)
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+		return formatOffboarded(offboarded, pl.Retention()), nil
+
+	case "restore":
+		return pl.restore(ctx, cmdArgs[1])
+
+	case "maintenance":
+		maintenance, err := pl.CDB.GetMaintenance(ctx)
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+
+		switch cmdArgs[1] {
+		case "status":
+			return formatMaintenance(maintenance), nil
+		case "on":
+			maintenance.Enabled = true
+			maintenance.Message = cmdArgs[2]
+		case "off":
+			maintenance.Enabled = false
+		case "allow":
+			if !contains(maintenance.AllowedUsers, cmdArgs[2]) {
+				maintenance.AllowedUsers = append(maintenance.AllowedUsers, cmdArgs[2])
+			}
+		case "disallow":
+			var allowed []string
+			for _, id := range maintenance.AllowedUsers {
+				if id != cmdArgs[2] {
+					allowed = append(allowed, id)
+				}
+			}
+			maintenance.AllowedUsers = allowed
+		}
+
+		err = pl.CDB.SetMaintenance(ctx, maintenance)
+		if err != nil {
+			return pl.writeErrorMessage(), err
+		}
+		return formatMaintenance(maintenance), nil
+
+	case "announce":
+		announcements, err := pl.CDB.GetAnnouncements(ctx)
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+
+		switch cmdArgs[1] {
+		case "status":
+			return formatAnnouncements(announcements), nil
+		case "on":
+			if announcements.Stream == "" {
+				return "Which stream should I post in? Tell me with `admin announce stream <stream name>` first.", nil
+			}
+			announcements.Enabled = true
+		case "off":
+			announcements.Enabled = false
+		case "stream":
+			announcements.Stream = strings.TrimPrefix(cmdArgs[2], "#")
+		case "topic":
+			announcements.Topic = cmdArgs[2]
+		case "template":
+			// `admin announce template default` goes back to the default
+			if strings.EqualFold(cmdArgs[2], "default") {
+				announcements.Template = ""
+				break
+			}
+			_, err = renderAnnouncement(cmdArgs[2], sampleAnnouncementData)
+			if err != nil {
+				return fmt.Sprintf("That template doesn't work, so I didn't save it: %s", err), nil
+			}
+			announcements.Template = cmdArgs[2]
+		}
+
+		err = pl.CDB.SetAnnouncements(ctx, announcements)
+		if err != nil {
+			return pl.writeErrorMessage(), err
+		}
+		return formatAnnouncements(announcements), nil
+
+	case "broadcast":
+		recursersList, err := pl.RDB.GetAllUsers(ctx)
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+
+		botPassword, err := pl.ADB.GetKey(ctx, "apiauth", "key")
+		if err != nil {
+			log.Println("Something weird happened trying to read the auth token from the database")
+		}
+
+		var notifications []chat.Notification
+		for _, rec := range recursersList {
+			notifications = append(notifications, chat.Notification{To: rec.Address(), Message: cmdArgs[1]})
+		}
+		summary := chat.SendAll(ctx, pl.UN, botPassword, notifications, pl.NotifyWorkers)
+		return fmt.Sprintf("Done! I sent your message to **%d** of %d subscribers.", summary.Sent, len(recursersList)), nil
+
+	case "outbox":
+		botPassword, err := pl.ADB.GetKey(ctx, "apiauth", "key")
+		if err != nil {
+			log.Println("Something weird happened trying to read the auth token from the database")
+		}
+		sent, left, err := pl.Outbox.Retry(ctx, botPassword)
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+		return fmt.Sprintf("Done! I sent **%d** messages from the outbox, and **%d** are still waiting.", sent, left), nil
+
+	case "report":
+		since := time.Now().AddDate(0, 0, -7)
+		matches, err := pl.MDB.ListSince(ctx, since)
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+		return checkInReport(matches, since), nil
+	}
+
+	// this won't execute because all input has been sanitized by ParseCmd()
+	return "", nil
+}
+
+// FindRecurser looks someone up by their zulip ID or their email
+func FindRecurser(recursersList []storage.Recurser, idOrEmail string) (storage.Recurser, bool) {
+	for _, r := range recursersList {
+		if r.ID == idOrEmail || strings.EqualFold(r.Email, idOrEmail) {
+			return r, true
+		}
+	}
+	return storage.Recurser{}, false
+}
+
+// ShortSchedule makes a compact version of someone's schedule, like "Mon Wed Fri"
+func ShortSchedule(schedule map[string]interface{}) string {
+	var days []string
+	for _, day := range []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"} {
+		if on, _ := schedule[day].(bool); on {
+			days = append(days, strings.ToUpper(day[:1])+day[1:3])
+		}
+	}
+	if len(days) == 0 {
+		return "-"
+	}
+	return strings.Join(days, " ")
+}
+
+func formatRecursersTable(recursersList []storage.Recurser) string {
+	if len(recursersList) == 0 {
+		return "Nobody is subscribed to Pairing Bot right now."
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%d subscribers:**\n\n| Name | ID | Email | Schedule | Skipping tomorrow |\n|---|---|---|---|---|\n", len(recursersList))
+	for _, r := range recursersList {
+		fmt.Fprintf(&b, "| %v | %v | %v | %v | %v |\n", r.Name, r.ID, r.Email, ShortSchedule(r.Schedule), format.YesNo(r.IsSkippingTomorrow))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func formatRecurserDetails(r storage.Recurser, s PairingStats) string {
+	batch := "-"
+	if r.Batch != "" {
+		batch = fmt.Sprintf("%v, ends %v", r.Batch, format.Date(r.BatchEnd))
+	}
+	return fmt.Sprintf("**%v**\n\n| | |\n|---|---|\n| ID | %v |\n| Email | %v |\n| Batch | %v |\n| Asked to stay | %v |\n| Schedule | %v |\n| Skipping tomorrow | %v |\n| Last odd one out | %v |\n| Times matched | %d |\n| No-shows | %d |",
+		r.Name, r.ID, r.Email, batch, format.YesNo(r.AskedToStay), ShortSchedule(r.Schedule), format.YesNo(r.IsSkippingTomorrow), format.Date(r.LastOddOneOut), s.Matches, s.NoShows)
+}
+
+func formatMaintenance(m storage.Maintenance) string {
+	if !m.Enabled {
+		return "Maintenance mode is **off**. I'm here for everyone!"
+	}
+
+	message := m.Message
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	allowed := "just admins"
+	if len(m.AllowedUsers) > 0 {
+		allowed = "admins and " + strings.Join(m.AllowedUsers, ", ")
+	}
+	return fmt.Sprintf("Maintenance mode is **on**, and scheduled matching and offboarding are paused. Turn it off with `admin maintenance off`.\n\n| | |\n|---|---|\n| Message | %v |\n| Who can use me | %v |", message, allowed)
+}
+
+// formatOddOneOut describes when someone was last the odd one out, for `status`
+func formatOddOneOut(lastOddOneOut time.Time) string {
+	if lastOddOneOut.IsZero() {
+		return "You've **never been the odd one out**"
+	}
+	return "You were last the odd one out on **" + lastOddOneOut.Format("Monday, January 2") + "**"
+}
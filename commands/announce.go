@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// after matching, Pairing Bot can post a summary of the day's pairings in a
+// zulip stream. it only names people who've said that's OK with `announce on`
+
+const defaultAnnouncementTemplate = "Today **{{.People}}** people paired in **{{.Pairs}}** {{if eq .Pairs 1}}pair{{else}}pairs{{end}}! :pear:{{if .Named}}\n\nHappy pairing, {{.Named}}!{{end}}"
+
+// the topic we post in if an admin hasn't picked one
+const defaultAnnouncementTopic = "pairing bot"
+
+// announcementData is what an announcement template gets filled in with
+type announcementData struct {
+	// how many people got a match today
+	People int
+	Pairs  int
+	// how many people didn't get a match
+	OddOneOuts int
+	// everyone who got a match and said they're happy to be named, like "Ada, Bea and Cat".
+	// empty if nobody did
+	Named string
+}
+
+// example data for trying out a template before we save it
+var sampleAnnouncementData = announcementData{People: 14, Pairs: 7, OddOneOuts: 1, Named: "Ada and Bea"}
+
+// renderAnnouncement fills in an announcement template. an empty template means the default
+func renderAnnouncement(text string, data announcementData) (string, error) {
+	if text == "" {
+		text = defaultAnnouncementTemplate
+	}
+	tmpl, err := template.New("announcement").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// announceMatches posts today's announcement, if announcements are turned on.
+// paired is everyone who got a match today. it returns whether anything was posted
+func (pl *PairingLogic) announceMatches(ctx context.Context, botPassword string, paired []storage.Recurser, summary MatchSummary) bool {
+	if pl.Streams == nil || summary.Pairs == 0 {
+		return false
+	}
+	announcements, err := pl.CDB.GetAnnouncements(ctx)
+	if err != nil {
+		log.Printf("Could not read the announcement settings from DB: %s\n", err)
+		return false
+	}
+	if !announcements.Enabled || announcements.Stream == "" {
+		return false
+	}
+
+	var named []string
+	for _, r := range paired {
+		if r.Announce {
+			named = append(named, r.Name)
+		}
+	}
+	sort.Strings(named)
+
+	message, err := renderAnnouncement(announcements.Template, announcementData{
+		People:     len(paired),
+		Pairs:      summary.Pairs,
+		OddOneOuts: summary.OddOneOuts,
+		Named:      joinNames(named),
+	})
+	if err != nil {
+		log.Printf("Could not fill in the announcement template: %s\n", err)
+		return false
+	}
+
+	topic := announcements.Topic
+	if topic == "" {
+		topic = defaultAnnouncementTopic
+	}
+	err = pl.Streams.SendStreamMessage(ctx, botPassword, announcements.Stream, topic, message)
+	if err != nil {
+		log.Printf("Could not post the announcement in #%s: %s\n", announcements.Stream, err)
+		return false
+	}
+	return true
+}
+
+// joinNames makes a list of names read nicely, like "Ada, Bea and Cat"
+func joinNames(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+func formatAnnouncements(a storage.Announcements) string {
+	state := "**off**"
+	if a.Enabled {
+		state = "**on**"
+	}
+	stream := "(not set)"
+	if a.Stream != "" {
+		stream = "#" + a.Stream
+	}
+	topic := a.Topic
+	if topic == "" {
+		topic = defaultAnnouncementTopic
+	}
+	tmpl := "(the default)"
+	if a.Template != "" {
+		tmpl = "`" + a.Template + "`"
+	}
+	example, err := renderAnnouncement(a.Template, sampleAnnouncementData)
+	if err != nil {
+		example = err.Error()
+	}
+	return fmt.Sprintf("Announcements after matching are %v.\n\n| | |\n|---|---|\n| Stream | %v |\n| Topic | %v |\n| Template | %v |\n\nWith 14 people paired, it'd look like this:\n\n%v", state, stream, topic, tmpl, example)
+}
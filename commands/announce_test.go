@@ -0,0 +1,46 @@
+package commands
+
+import "testing"
+
+func TestRenderAnnouncement(t *testing.T) {
+	tests := []struct {
+		testName  string
+		template  string
+		data      announcementData
+		wanted    string
+		expectErr bool
+	}{
+		{"default", "", announcementData{People: 14, Pairs: 7}, "Today **14** people paired in **7** pairs! :pear:", false},
+		{"default_one_pair", "", announcementData{People: 2, Pairs: 1, Named: "Ada"}, "Today **2** people paired in **1** pair! :pear:\n\nHappy pairing, Ada!", false},
+		{"custom", "{{.Pairs}} pairs, {{.OddOneOuts}} left out", announcementData{Pairs: 3, OddOneOuts: 1}, "3 pairs, 1 left out", false},
+		{"unknown_field", "{{.Emails}}", announcementData{}, "", true},
+		{"bad_syntax", "{{.Pairs", announcementData{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			got, err := renderAnnouncement(tt.template, tt.data)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v", err)
+			}
+			if got != tt.wanted {
+				t.Errorf("got %q, wanted %q", got, tt.wanted)
+			}
+		})
+	}
+}
+
+func TestJoinNames(t *testing.T) {
+	for _, tt := range []struct {
+		names  []string
+		wanted string
+	}{
+		{nil, ""},
+		{[]string{"Ada"}, "Ada"},
+		{[]string{"Ada", "Bea"}, "Ada and Bea"},
+		{[]string{"Ada", "Bea", "Cat"}, "Ada, Bea and Cat"},
+	} {
+		if got := joinNames(tt.names); got != tt.wanted {
+			t.Errorf("joinNames(%q) = %q, wanted %q", tt.names, got, tt.wanted)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// at the end of a batch, only the people whose batch has ended are offboarded.
+// everyone else is asked whether they're still around, and anyone who doesn't
+// answer with `stay` is offboarded at the next end of batch instead
+
+// BatchEndedMessage is what people are told when they're offboarded because their batch ended
+const BatchEndedMessage = "Hi! Your batch has ended, so I've unsubscribed you from Pairing Bot.\n\nIf you'd like to keep pairing, just send me a message that says `subscribe`.\n\nBe well! :)"
+
+// NoStayMessage is what people are told when they're offboarded for not answering StayPromptMessage
+const NoStayMessage = "Hi! I asked at the end of the last batch whether you were still around and didn't hear back, so I've unsubscribed you from Pairing Bot.\n\nIf you'd like to keep pairing, just send me a message that says `subscribe`.\n\nBe well! :)"
+
+// StayPromptMessage asks someone whose batch hasn't ended whether they're still around
+const StayPromptMessage = "Hi! It's the end of a batch at RC. Are you still here?\n\nIf you'd like to keep pairing, reply `stay` and I'll keep your schedule just as it is. If I don't hear from you, I'll unsubscribe you at the end of the next batch."
+
+// StayMessage is the answer to `stay`
+const StayMessage = "Yay, glad you're still here! I'll keep finding pairing partners for you :)"
+
+// what we tell people when they're offboarded, by reason
+var offboardMessages = map[string]string{
+	storage.ReasonBatchEnded: BatchEndedMessage,
+	storage.ReasonNoStay:     NoStayMessage,
+}
+
+// offboardReason is why someone's offboarded at the end of a batch, or
+// "" if they aren't (in which case they're asked to stay). alum is whether
+// the directory says they aren't in batch any more, even if it doesn't say
+// when it ended
+func offboardReason(rec storage.Recurser, alum bool, now time.Time) string {
+	switch {
+	case alum, !rec.BatchEnd.IsZero() && !rec.BatchEnd.After(now):
+		return storage.ReasonBatchEnded
+	// we don't know when their batch ends, and they didn't answer last time
+	case rec.BatchEnd.IsZero() && rec.AskedToStay:
+		return storage.ReasonNoStay
+	}
+	return ""
+}
+
+// withBatchFrom fills in rec's batch from the directory, if it knows, and
+// says whether the directory has them as an alum. what the directory says
+// wins over what they told us, since it's the source of truth
+func withBatchFrom(ctx context.Context, dir Directory, rec storage.Recurser) (storage.Recurser, bool, error) {
+	if dir == nil {
+		return rec, false, nil
+	}
+	p, ok, err := dir.Lookup(ctx, rec.ID)
+	if err != nil || !ok || p.Batch == "" {
+		return rec, false, err
+	}
+	rec.Batch = p.Batch
+	rec.BatchEnd = p.BatchEnd
+	return rec, !p.Current, nil
+}
+
+// withoutAlums leaves out everyone the directory has as an alum, and says how
+// many that was. if it can't look someone up, they stay in
+func withoutAlums(ctx context.Context, dir Directory, recursersList []storage.Recurser) ([]storage.Recurser, int) {
+	if dir == nil {
+		return recursersList, 0
+	}
+	var current []storage.Recurser
+	for _, rec := range recursersList {
+		_, alum, err := withBatchFrom(ctx, dir, rec)
+		if err != nil {
+			log.Printf("Could not look up the batch for recurser %v: %s\n", rec.ID, err)
+		}
+		if alum {
+			continue
+		}
+		current = append(current, rec)
+	}
+	return current, len(recursersList) - len(current)
+}
+
+// batchMessage says which batch someone's in, for `status` and `batch`
+func batchMessage(rec storage.Recurser) string {
+	if rec.Batch == "" {
+		return "I don't know which batch you're in. Tell me with something like `batch W1'24 2024-03-29` (the batch, then the day it ends)"
+	}
+	return fmt.Sprintf("You're in batch **%v**, which ends on **%v**", rec.Batch, rec.BatchEnd.Format("Mon Jan 2, 2006"))
+}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+func TestOffboardReason(t *testing.T) {
+	now := time.Date(2024, 3, 29, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		testName    string
+		batchEnd    time.Time
+		askedToStay bool
+		alum        bool
+		wanted      string
+	}{
+		{"batch_ended", now.AddDate(0, 0, -1), false, false, storage.ReasonBatchEnded},
+		{"batch_ends_today", time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC), false, false, storage.ReasonBatchEnded},
+		{"still_in_batch", now.AddDate(0, 1, 0), false, false, ""},
+		{"still_in_batch_no_answer", now.AddDate(0, 1, 0), true, false, ""},
+		{"unknown_batch", time.Time{}, false, false, ""},
+		{"unknown_batch_no_answer", time.Time{}, true, false, storage.ReasonNoStay},
+		{"alum_unknown_end", time.Time{}, false, true, storage.ReasonBatchEnded},
+		{"alum_no_answer", time.Time{}, true, true, storage.ReasonBatchEnded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			rec := storage.Recurser{BatchEnd: tt.batchEnd, AskedToStay: tt.askedToStay}
+			if got := offboardReason(rec, tt.alum, now); got != tt.wanted {
+				t.Errorf("got %q, wanted %q", got, tt.wanted)
+			}
+		})
+	}
+}
+
+func TestWithBatchFrom(t *testing.T) {
+	end := time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC)
+	source := &StaticDirectory{Profiles: map[string]Profile{
+		"2": {Batch: "W1'24", BatchEnd: end, Current: true},
+		"4": {Batch: "F2'23"},
+	}}
+	ctx := context.Background()
+
+	// the source wins over what someone told us
+	rec, alum, err := withBatchFrom(ctx, source, storage.Recurser{ID: "2", Batch: "SP1'24"})
+	if err != nil || rec.Batch != "W1'24" || !rec.BatchEnd.Equal(end) || alum {
+		t.Errorf("got %+v, %v, %v", rec, alum, err)
+	}
+	// and when it doesn't know, we keep what we had
+	rec, alum, err = withBatchFrom(ctx, source, storage.Recurser{ID: "3", Batch: "SP1'24"})
+	if err != nil || rec.Batch != "SP1'24" || alum {
+		t.Errorf("got %+v, %v, %v", rec, alum, err)
+	}
+	// an alum is an alum, even without an end date
+	rec, alum, err = withBatchFrom(ctx, source, storage.Recurser{ID: "4"})
+	if err != nil || rec.Batch != "F2'23" || !alum {
+		t.Errorf("got %+v, %v, %v", rec, alum, err)
+	}
+}
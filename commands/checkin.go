@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const checkInMessage = "Hi! This morning I matched you with **%v** for pairing. Did you two get to pair?\n\nReply `yes`, `no`, or `rate 1` through `rate 5` to tell me how it went. Your answer is just for me, I won't pass it on to %v :)"
+
+// NoRecentMatchMessage is the answer to a check-in reply when there's no match to check in on
+const NoRecentMatchMessage = "Hmm, I don't have a recent pairing match on record for you, so there's nothing to check in on."
+
+// how far back a `yes`, `no` or `rate` reply can reach to find the match it's about
+const checkInWindow = 7 * 24 * time.Hour
+
+// RunCheckIns asks both people in every match from the last day whether their
+// session happened, unless they've already been asked
+func (pl *PairingLogic) RunCheckIns(ctx context.Context) (chat.NotificationSummary, error) {
+	matches, err := pl.MDB.ListSince(ctx, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return chat.NotificationSummary{}, err
+	}
+
+	botPassword, err := pl.ADB.GetKey(ctx, "apiauth", "key")
+	if err != nil {
+		log.Println("Something weird happened trying to read the auth token from the database")
+	}
+
+	var notifications []chat.Notification
+	for _, m := range matches {
+		if m.CheckInSent {
+			continue
+		}
+
+		for i, email := range m.Emails {
+			// they asked to be forgotten, so there's nobody to ask
+			if email == "" || m.IDs[i] == storage.ForgottenID {
+				continue
+			}
+			partner := m.Names[1-i]
+			notifications = append(notifications, chat.Notification{To: email, Message: fmt.Sprintf(checkInMessage, partner, partner)})
+		}
+
+		err := pl.MDB.MarkCheckInSent(ctx, m.ID)
+		if err != nil {
+			log.Printf("Could not mark check-in as sent for match %s: %s\n", m.ID, err)
+		}
+	}
+
+	return chat.SendAll(ctx, pl.UN, botPassword, notifications, pl.NotifyWorkers), nil
+}
+
+// latestMatch returns the most recent match in matches (which are newest first)
+// that happened inside the check-in window, if there is one
+func latestMatch(matches []storage.Match, now time.Time) (storage.Match, bool) {
+	if len(matches) == 0 || now.Sub(matches[0].Date) > checkInWindow {
+		return storage.Match{}, false
+	}
+	return matches[0], true
+}
+
+// checkInFromCmd turns a `yes`, `no` or `rate` command into a CheckIn.
+// the args have already been validated by ParseCmd()
+func checkInFromCmd(cmd string, cmdArgs []string) storage.CheckIn {
+	switch cmd {
+	case "no":
+		return storage.CheckIn{Happened: false}
+	case "rate":
+		return storage.CheckIn{Happened: true, Rating: int(cmdArgs[0][0] - '0')}
+	default:
+		return storage.CheckIn{Happened: true}
+	}
+}
+
+func checkInThanks(c storage.CheckIn, partner string) string {
+	switch {
+	case !c.Happened:
+		return fmt.Sprintf("Thanks for letting me know. Sorry it didn't work out with %v today -- there's always next time <3", partner)
+	case c.Rating > 0:
+		return fmt.Sprintf("Thanks! I've noted that your session with %v was a **%d/5** :)", partner, c.Rating)
+	default:
+		return fmt.Sprintf("Yay, glad you got to pair with %v! :tada:", partner)
+	}
+}
+
+// checkInReport summarises everyone's check-in answers for Pairing Bot's admins.
+// it only gives totals, since people were told their answers aren't passed on
+func checkInReport(matches []storage.Match, since time.Time) string {
+	var asked, answered, happened, didNot, rated, ratingSum, bothNo int
+	for _, m := range matches {
+		if m.CheckInSent {
+			asked += len(m.IDs)
+		}
+		noCount := 0
+		for _, c := range m.CheckIns {
+			answered++
+			if c.Happened {
+				happened++
+			} else {
+				didNot++
+				noCount++
+			}
+			if c.Rating > 0 {
+				rated++
+				ratingSum += c.Rating
+			}
+		}
+		if noCount == 2 {
+			bothNo++
+		}
+	}
+
+	avg := "n/a"
+	if rated > 0 {
+		avg = fmt.Sprintf("%.1f", float64(ratingSum)/float64(rated))
+	}
+
+	return fmt.Sprintf("**Check-ins since %v**\n\n| | |\n|---|---|\n| Matches made | %d |\n| People asked | %d |\n| People who answered | %d |\n| Said they paired | %d |\n| Said they didn't | %d |\n| Pairs where both said no | %d |\n| Average rating | %v (from %d ratings) |",
+		since.Format("Monday, January 2"), len(matches), asked, answered, happened, didNot, bothNo, avg, rated)
+}
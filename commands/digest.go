@@ -0,0 +1,181 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// once a week, everyone who hasn't said `digest off` gets a summary of their
+// week: who they paired with, when they're pairing next week, and a couple of
+// commands they haven't tried yet
+
+// how many untried commands a digest suggests
+const digestTipCount = 2
+
+// digestTip is a command the digest can suggest
+type digestTip struct {
+	cmd string
+	tip string
+}
+
+// in the order we suggest them
+var digestTips = []digestTip{
+	{"schedule", "`schedule monday wednesday friday` to choose which days you pair on"},
+	{"skip", "`skip tomorrow` when you need a day off from pairing"},
+	{"history", "`history` to see everyone you've paired with"},
+	{"stats", "`stats` to see your pairing streak"},
+	{"reminders", "`reminders on` to hear from me the evening before you pair, while you can still skip"},
+	{"rate", "`rate 1`-`rate 5` to tell me how a pairing session went"},
+	{"announce", "`announce on` to get a shout-out when I announce the day's pairings"},
+	{"notify", "`notify via email` to hear about your matches by email"},
+}
+
+// DigestSummary is what "digest" sends back as JSON
+type DigestSummary struct {
+	Digests       int                      `json:"digests"`
+	Notifications chat.NotificationSummary `json:"notifications"`
+}
+
+// RunDigest sends everyone with the digest on a summary of the week before
+// now: who they paired with, their schedule, and a tip or two
+func (pl *PairingLogic) RunDigest(ctx context.Context, now time.Time) (DigestSummary, error) {
+	var summary DigestSummary
+
+	recursersList, err := pl.RDB.GetAllUsers(ctx)
+	if err != nil {
+		return summary, err
+	}
+
+	// one query for the whole week's matches, rather than one per person
+	matches, err := pl.MDB.ListSince(ctx, now.AddDate(0, 0, -7))
+	if err != nil {
+		return summary, err
+	}
+	matchesByUser := map[string][]storage.Match{}
+	for _, m := range matches {
+		for _, id := range m.IDs {
+			matchesByUser[id] = append(matchesByUser[id], m)
+		}
+	}
+
+	var notifications []chat.Notification
+	for i, rec := range recursersList {
+		if !rec.Digest {
+			continue
+		}
+		message := buildDigest(rec, matchesByUser[rec.ID], pl.EmailEnabled)
+		notifications = append(notifications, pl.notificationsTo(recursersList[i:i+1], message)...)
+		summary.Digests++
+	}
+
+	botPassword, err := pl.ADB.GetKey(ctx, "apiauth", "key")
+	if err != nil {
+		log.Println("Something weird happened trying to read the auth token from the database")
+	}
+
+	summary.Notifications = chat.SendAll(ctx, pl.UN, botPassword, notifications, pl.NotifyWorkers)
+	return summary, nil
+}
+
+// buildDigest writes rec's digest from their state and this week's matches
+func buildDigest(rec storage.Recurser, matches []storage.Match, emailEnabled bool) string {
+	var b strings.Builder
+	b.WriteString("Hi! Here's your week with Pairing Bot :pear:\n\n")
+
+	var partners []string
+	for _, m := range matches {
+		partners = append(partners, "**"+m.Names[m.PartnerOf(rec.ID)]+"**")
+	}
+	if len(partners) > 0 {
+		fmt.Fprintf(&b, "* This week you paired with %v\n", joinNames(partners))
+	} else {
+		b.WriteString("* You didn't get matched with anyone this week\n")
+	}
+
+	if schedule := formatSchedule(rec.Schedule); schedule != "" {
+		fmt.Fprintf(&b, "* Next week you're scheduled to pair on **%v**\n", schedule)
+	} else {
+		b.WriteString("* You're not scheduled to pair on any days next week\n")
+	}
+	if rec.IsSkippingTomorrow {
+		b.WriteString("* You're **skipping** pairing tomorrow\n")
+	}
+
+	var tips []string
+	for _, t := range digestTips {
+		if len(tips) == digestTipCount {
+			break
+		}
+		if t.cmd == "notify" && !emailEnabled {
+			continue
+		}
+		if !hasTried(rec, matches, t.cmd) {
+			tips = append(tips, "* "+t.tip)
+		}
+	}
+	if len(tips) > 0 {
+		fmt.Fprintf(&b, "\nHave you tried...\n%v\n", strings.Join(tips, "\n"))
+	}
+
+	b.WriteString("\nIf you'd rather not get these, send me `digest off`.")
+	return b.String()
+}
+
+// hasTried guesses whether rec has used cmd. we only started keeping track of
+// that recently, so anything their settings or check-ins give away counts too
+func hasTried(rec storage.Recurser, matches []storage.Match, cmd string) bool {
+	if contains(rec.TriedCommands, cmd) {
+		return true
+	}
+	switch cmd {
+	case "schedule":
+		return formatSchedule(rec.Schedule) != formatSchedule(storage.NewRecurser("", "", "").Schedule)
+	case "skip":
+		return rec.IsSkippingTomorrow
+	case "rate":
+		for _, m := range matches {
+			if c, ok := m.CheckIns[rec.ID]; ok && c.Rating > 0 {
+				return true
+			}
+		}
+	case "reminders":
+		return rec.Reminders
+	case "announce":
+		return rec.Announce
+	case "notify":
+		return rec.NotifyVia != storage.NotifyViaZulip
+	}
+	return false
+}
+
+// noteTriedCommand remembers that someone has used cmd, if it's one the
+// digest might suggest
+func (pl *PairingLogic) noteTriedCommand(ctx context.Context, userData *chat.UserDataFromJSON, cmd string) {
+	suggested := false
+	for _, t := range digestTips {
+		suggested = suggested || t.cmd == cmd
+	}
+	if !suggested {
+		return
+	}
+
+	rec, err := pl.RDB.GetByUserID(ctx, userData.UserID, userData.UserEmail, userData.UserName)
+	if err != nil {
+		log.Printf("Could not read recurser %v from DB: %s\n", userData.UserID, err)
+		return
+	}
+	if !rec.IsSubscribed || contains(rec.TriedCommands, cmd) {
+		return
+	}
+	rec.TriedCommands = append(rec.TriedCommands, cmd)
+	err = pl.RDB.Set(ctx, rec.ID, rec)
+	if err != nil {
+		log.Printf("Could not record a command for recurser %v: %s\n", rec.ID, err)
+	}
+}
@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+func TestBuildDigest(t *testing.T) {
+	rec := storage.NewRecurser("2", "ada@example.com", "Ada")
+	rec.IsSkippingTomorrow = true
+	rec.TriedCommands = []string{"history"}
+	matches := []storage.Match{
+		{IDs: []string{"2", "3"}, Names: []string{"Ada", "Bea"}},
+		{IDs: []string{"4", "2"}, Names: []string{"Cat", "Ada"}, CheckIns: map[string]storage.CheckIn{"2": {Happened: true, Rating: 5}}},
+	}
+
+	got := buildDigest(rec, matches, false)
+	for _, want := range []string{
+		"This week you paired with **Bea** and **Cat**",
+		"scheduled to pair on **Mondays, Tuesdays, Wednesdays, Thursdays, and Fridays**",
+		"You're **skipping** pairing tomorrow",
+		// schedule is still the default, skip is on, and they've used history and rate
+		"Have you tried...\n* `schedule monday wednesday friday` to choose which days you pair on\n* `stats` to see your pairing streak\n",
+		"`digest off`",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("digest is missing %q:\n%s", want, got)
+		}
+	}
+
+	rec.Schedule = map[string]interface{}{"saturday": true}
+	rec.TriedCommands = []string{"skip", "history", "stats", "reminders", "rate", "announce"}
+	got = buildDigest(rec, nil, false)
+	if !strings.Contains(got, "You didn't get matched") || !strings.Contains(got, "**Saturdays**") || strings.Contains(got, "Have you tried") {
+		t.Errorf("got digest:\n%s", got)
+	}
+	if got = buildDigest(rec, nil, true); !strings.Contains(got, "`notify via email`") {
+		t.Errorf("didn't suggest email when it's set up:\n%s", got)
+	}
+}
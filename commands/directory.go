@@ -0,0 +1,279 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// the directory is where we find out about people beyond what their chat
+// platform tells us: which batch they're in, its dates, their pronouns, and
+// whether they're in batch now or an alum
+
+// Profile is what the directory knows about someone
+type Profile struct {
+	Pronouns string
+	// like "W1'24"
+	Batch      string
+	BatchStart time.Time
+	BatchEnd   time.Time
+	// true while they're in batch, false once they're an alum
+	Current bool
+}
+
+// Directory looks people up by their user ID (prefixed, for platforms other
+// than Zulip). see PairingLogic.Directory for what it's used for
+type Directory interface {
+	// ok is false if the directory doesn't know userID
+	Lookup(ctx context.Context, userID string) (profile Profile, ok bool, err error)
+}
+
+// implements Directory
+// HTTPDirectory looks people up in a Recurse-style REST API, at
+// <baseURL>/profiles/<user ID>, with the token in directoryauth/token
+type HTTPDirectory struct {
+	BaseURL string
+	ADB     storage.APIAuthDB
+	// nil means chat.DefaultHTTPClient
+	Client *http.Client
+}
+
+// the parts of a profile from the API that we care about
+type apiProfile struct {
+	Pronouns string `json:"pronouns"`
+	Stints   []struct {
+		StartDate string `json:"start_date"`
+		// null while the stint is still going
+		EndDate *string `json:"end_date"`
+		Batch   *struct {
+			ShortName string `json:"short_name"`
+		} `json:"batch"`
+	} `json:"stints"`
+}
+
+// Lookup fetches someone's profile. a 404 means the directory doesn't know them
+func (h *HTTPDirectory) Lookup(ctx context.Context, userID string) (Profile, bool, error) {
+	client := h.Client
+	if client == nil {
+		client = chat.DefaultHTTPClient
+	}
+	token, err := h.ADB.GetKey(ctx, "directoryauth", "token")
+	if err != nil {
+		return Profile{}, false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", h.BaseURL+"/profiles/"+url.PathEscape(userID), nil)
+	if err != nil {
+		return Profile{}, false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Profile{}, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Profile{}, false, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Profile{}, false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Profile{}, false, fmt.Errorf("directory answered HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var p apiProfile
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		return Profile{}, false, err
+	}
+	return p.toProfile(time.Now())
+}
+
+// toProfile picks out their latest batch (people can do more than one)
+func (p apiProfile) toProfile(now time.Time) (Profile, bool, error) {
+	profile := Profile{Pronouns: p.Pronouns}
+	for _, s := range p.Stints {
+		if s.Batch == nil {
+			continue
+		}
+		start, err := time.Parse(storage.BatchDateLayout, s.StartDate)
+		if err != nil {
+			return Profile{}, false, fmt.Errorf("bad start_date %q: %s", s.StartDate, err)
+		}
+		if !profile.BatchStart.IsZero() && start.Before(profile.BatchStart) {
+			continue
+		}
+		profile.Batch = s.Batch.ShortName
+		profile.BatchStart = start
+		profile.BatchEnd = time.Time{}
+		if s.EndDate != nil {
+			profile.BatchEnd, err = time.Parse(storage.BatchDateLayout, *s.EndDate)
+			if err != nil {
+				return Profile{}, false, fmt.Errorf("bad end_date %q: %s", *s.EndDate, err)
+			}
+		}
+	}
+	// the last day of a batch still counts as being in it
+	profile.Current = profile.Batch != "" && (profile.BatchEnd.IsZero() || !now.After(profile.BatchEnd.AddDate(0, 0, 1)))
+	return profile, true, nil
+}
+
+// implements Directory
+// StaticDirectory is a fixed list of profiles, read from a JSON file. It's
+// handy for tests, and for running Pairing Bot somewhere without a directory API
+type StaticDirectory struct {
+	Profiles map[string]Profile
+}
+
+// the JSON file is a list of profiles, like:
+// {"profiles": [{"id": "215391", "pronouns": "she/her", "batch": "W1'24", "batch_start": "2024-01-08", "batch_end": "2024-03-29", "current": true}]}
+type staticDirectoryFile struct {
+	Profiles []struct {
+		ID         string `json:"id"`
+		Pronouns   string `json:"pronouns"`
+		Batch      string `json:"batch"`
+		BatchStart string `json:"batch_start"`
+		BatchEnd   string `json:"batch_end"`
+		Current    bool   `json:"current"`
+	} `json:"profiles"`
+}
+
+// LoadStaticDirectory reads a StaticDirectory from a JSON file
+func LoadStaticDirectory(path string) (*StaticDirectory, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseStaticDirectory(b)
+}
+
+// ParseStaticDirectory reads a StaticDirectory from JSON like
+// {"profiles": [{"id": "215391", "batch": "W1'24", ...}]}. every profile
+// needs an id, and dates are like 2024-03-29
+func ParseStaticDirectory(b []byte) (*StaticDirectory, error) {
+	var f staticDirectoryFile
+	err := json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &StaticDirectory{Profiles: map[string]Profile{}}
+	for _, p := range f.Profiles {
+		if p.ID == "" {
+			return nil, fmt.Errorf("a profile in the directory has no id")
+		}
+		profile := Profile{Pronouns: p.Pronouns, Batch: p.Batch, Current: p.Current}
+		for _, date := range []struct {
+			s string
+			t *time.Time
+		}{{p.BatchStart, &profile.BatchStart}, {p.BatchEnd, &profile.BatchEnd}} {
+			if date.s == "" {
+				continue
+			}
+			*date.t, err = time.Parse(storage.BatchDateLayout, date.s)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s has a bad date %q: %s", p.ID, date.s, err)
+			}
+		}
+		d.Profiles[p.ID] = profile
+	}
+	return d, nil
+}
+
+// Lookup never fails
+func (s *StaticDirectory) Lookup(ctx context.Context, userID string) (Profile, bool, error) {
+	p, ok := s.Profiles[userID]
+	return p, ok, nil
+}
+
+// how long CachingDirectory remembers a profile by default. people's batches don't change much
+const DefaultDirectoryTTL = 6 * time.Hour
+
+// implements Directory
+// CachingDirectory remembers what another directory says for a while, so we
+// don't ask it about everyone every time we match. It remembers when someone
+// isn't in the directory too, but not errors
+type CachingDirectory struct {
+	dir Directory
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedProfile
+}
+
+type cachedProfile struct {
+	profile Profile
+	ok      bool
+	fetched time.Time
+}
+
+// NewCachingDirectory caches what dir says for ttl (DefaultDirectoryTTL, say)
+func NewCachingDirectory(dir Directory, ttl time.Duration) *CachingDirectory {
+	return &CachingDirectory{dir: dir, ttl: ttl, entries: map[string]cachedProfile{}}
+}
+
+// Lookup asks dir about anyone who isn't cached, or was cached more than ttl ago
+func (c *CachingDirectory) Lookup(ctx context.Context, userID string) (Profile, bool, error) {
+	c.mu.Lock()
+	e, hit := c.entries[userID]
+	c.mu.Unlock()
+	if hit && time.Since(e.fetched) < c.ttl {
+		return e.profile, e.ok, nil
+	}
+
+	profile, ok, err := c.dir.Lookup(ctx, userID)
+	if err != nil {
+		return Profile{}, false, err
+	}
+	c.mu.Lock()
+	c.entries[userID] = cachedProfile{profile, ok, time.Now()}
+	c.mu.Unlock()
+	return profile, ok, nil
+}
+
+// introduction is how we introduce someone to their match, like "**Ada** (she/her), W1'24"
+func introduction(rec storage.Recurser, p Profile) string {
+	intro := "**" + rec.Name + "**"
+	if p.Pronouns != "" {
+		intro += " (" + p.Pronouns + ")"
+	}
+	switch {
+	case p.Batch != "" && p.Current:
+		intro += ", " + p.Batch
+	case p.Batch != "":
+		intro += ", " + p.Batch + " alum"
+	}
+	return intro
+}
+
+// matchMessage is the message for a new pair. with a directory, it introduces
+// them to each other too
+func (pl *PairingLogic) matchMessage(ctx context.Context, pair []storage.Recurser) string {
+	if pl.Directory == nil {
+		return MatchedMessage
+	}
+	var intros []string
+	for _, rec := range pair {
+		p, _, err := pl.Directory.Lookup(ctx, rec.ID)
+		if err != nil {
+			log.Printf("Could not look up recurser %v in the directory: %s\n", rec.ID, err)
+		}
+		intros = append(intros, "* "+introduction(rec, p))
+	}
+	return MatchedMessage + "\n\n" + strings.Join(intros, "\n")
+}
@@ -0,0 +1,141 @@
+package commands
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const testDirectoryProfile = `{
+  "pronouns": "she/her",
+  "stints": [
+    {"type": "retreat", "start_date": "2022-09-26", "end_date": "2022-12-16", "batch": {"short_name": "F2'22"}},
+    {"type": "retreat", "start_date": "2024-01-08", "end_date": null, "batch": {"short_name": "W1'24"}},
+    {"type": "employment", "start_date": "2023-01-01", "end_date": null, "batch": null}
+  ]
+}`
+
+func TestHTTPDirectory(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "who are you?", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/profiles/2":
+			w.Write([]byte(testDirectoryProfile))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	adb := storage.NewMemoryAPIAuthDB()
+	adb.SetKey("directoryauth", "token", "secret")
+	dir := &HTTPDirectory{BaseURL: ts.URL, ADB: adb}
+	ctx := context.Background()
+
+	p, ok, err := dir.Lookup(ctx, "2")
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	// the latest stint with a batch wins, and it hasn't ended
+	if p.Pronouns != "she/her" || p.Batch != "W1'24" || !p.BatchEnd.IsZero() || !p.Current {
+		t.Errorf("got %+v", p)
+	}
+
+	_, ok, err = dir.Lookup(ctx, "3")
+	if err != nil || ok {
+		t.Errorf("got %v, %v for someone the directory doesn't know", ok, err)
+	}
+
+	adb.SetKey("directoryauth", "token", "wrong")
+	_, _, err = dir.Lookup(ctx, "2")
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got %v with the wrong token", err)
+	}
+}
+
+func TestParseStaticDirectory(t *testing.T) {
+	tests := []struct {
+		testName string
+		json     string
+		wantErr  bool
+	}{
+		{"ok", `{"profiles": [{"id": "2", "batch": "W1'24", "batch_start": "2024-01-08", "batch_end": "2024-03-29", "current": true}]}`, false},
+		{"no_dates", `{"profiles": [{"id": "2", "pronouns": "they/them"}]}`, false},
+		{"no_id", `{"profiles": [{"batch": "W1'24"}]}`, true},
+		{"bad_date", `{"profiles": [{"id": "2", "batch_end": "29/03/2024"}]}`, true},
+		{"not_json", `profiles`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			_, err := ParseStaticDirectory([]byte(tt.json))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, wanted an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// countingDirectory counts how often it's asked about people
+type countingDirectory struct {
+	dir Directory
+
+	mu      sync.Mutex
+	lookups int
+}
+
+func (c *countingDirectory) Lookup(ctx context.Context, userID string) (Profile, bool, error) {
+	c.mu.Lock()
+	c.lookups++
+	c.mu.Unlock()
+	return c.dir.Lookup(ctx, userID)
+}
+
+func TestCachingDirectory(t *testing.T) {
+	counter := &countingDirectory{dir: &StaticDirectory{Profiles: map[string]Profile{"2": {Batch: "W1'24"}}}}
+	ctx := context.Background()
+
+	cached := NewCachingDirectory(counter, time.Hour)
+	for i := 0; i < 3; i++ {
+		cached.Lookup(ctx, "2")
+		cached.Lookup(ctx, "3")
+	}
+	if counter.lookups != 2 {
+		t.Errorf("looked people up %d times, wanted 2", counter.lookups)
+	}
+
+	expired := NewCachingDirectory(counter, 0)
+	expired.Lookup(ctx, "2")
+	expired.Lookup(ctx, "2")
+	if counter.lookups != 4 {
+		t.Errorf("looked people up %d times with nothing cached, wanted 4", counter.lookups)
+	}
+}
+
+func TestIntroduction(t *testing.T) {
+	rec := storage.Recurser{Name: "Ada"}
+	tests := []struct {
+		testName string
+		profile  Profile
+		wanted   string
+	}{
+		{"unknown", Profile{}, "**Ada**"},
+		{"in_batch", Profile{Pronouns: "she/her", Batch: "W1'24", Current: true}, "**Ada** (she/her), W1'24"},
+		{"alum", Profile{Batch: "F2'22"}, "**Ada**, F2'22 alum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if got := introduction(rec, tt.profile); got != tt.wanted {
+				t.Errorf("got %q, wanted %q", got, tt.wanted)
+			}
+		})
+	}
+}
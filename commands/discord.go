@@ -0,0 +1,29 @@
+package commands
+
+import "github.com/thwidge/pairing-bot/chat"
+
+// DiscordCommands are the slash commands to register with discord. they have
+// to cover everything in cmdList
+var DiscordCommands = []chat.DiscordCommand{
+	{Name: "subscribe", Description: "Start getting matched with other people for pair programming"},
+	{Name: "unsubscribe", Description: "Stop getting matched"},
+	{Name: "help", Description: "Show how to use Pairing Bot"},
+	{Name: "schedule", Description: "Set the days you want to pair on", Args: "days, like: monday wednesday friday"},
+	{Name: "skip", Description: "Skip pairing tomorrow", Args: "tomorrow"},
+	{Name: "unskip", Description: "Undo skipping tomorrow", Args: "tomorrow"},
+	{Name: "status", Description: "Show your schedule and whether you're skipping tomorrow"},
+	{Name: "yes", Description: "Say you paired with your last match"},
+	{Name: "no", Description: "Say you didn't pair with your last match"},
+	{Name: "rate", Description: "Rate your last pairing session", Args: "1 to 5"},
+	{Name: "history", Description: "Show who you've paired with recently", Args: "how many sessions to show"},
+	{Name: "stats", Description: "Show your pairing stats"},
+	{Name: "notify", Description: "Choose how you hear about your matches", Args: "via zulip, via email or via both"},
+	{Name: "announce", Description: "Choose whether I name you when I announce the day's pairings", Args: "on or off"},
+	{Name: "digest", Description: "Choose whether you get a weekly digest of your pairing", Args: "on or off"},
+	{Name: "reminders", Description: "Choose whether I remind you the evening before you're matched", Args: "on or off"},
+	{Name: "batch", Description: "Tell me which batch you're in and when it ends", Args: "the batch and its last day, like: W1'24 2024-03-29"},
+	{Name: "stay", Description: "Tell me you're still around at the end of a batch"},
+	{Name: "mydata", Description: "See everything I have on you"},
+	{Name: "forget", Description: "Delete your data and anonymise you in everyone's match history", Args: "me, then: me confirm"},
+	{Name: "admin", Description: "Admin commands", Args: "the admin command, like: list"},
+}
@@ -0,0 +1,25 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/thwidge/pairing-bot/chat"
+)
+
+func TestDiscordCommandsCoverParseCmd(t *testing.T) {
+	names := map[string]bool{}
+	for _, c := range DiscordCommands {
+		names[c.Name] = true
+		if len(c.Description) > 100 || len(c.Args) > 100 {
+			t.Errorf("/%s has a description longer than Discord allows", c.Name)
+		}
+	}
+	for _, cmd := range cmdList {
+		if !names[cmd] {
+			t.Errorf("%q has no slash command", cmd)
+		}
+	}
+	if len(HelpMessage) > chat.DiscordMaxMessageLength {
+		t.Errorf("the help message is too long for Discord, so it'll get cut")
+	}
+}
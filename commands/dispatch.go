@@ -0,0 +1,416 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// HelpMessage is the answer to `help`, and to anything we don't understand
+const HelpMessage string = "**How to use Pairing Bot:**\n* `subscribe` to start getting matched with other Pairing Bot users for pair programming\n* `schedule monday wednesday friday` to set your weekly pairing schedule\n  * In this example, I've been set to find pairing partners for you on every Monday, Wednesday, and Friday\n  * You can schedule pairing for any combination of days in the week\n* `skip tomorrow` to skip pairing tomorrow\n  * This is valid until matches go out at 04:00 UTC\n* `unskip tomorrow` to undo skipping tomorrow\n* `status` to show your current schedule, skip status, name, and when you were last the odd one out\n* `unsubscribe` to stop getting matched entirely\n* `yes`, `no` or `rate 1`-`rate 5` to answer my check-in after you've been matched\n* `history` to see who you've paired with recently (or `history 20` to see more)\n* `stats` to see your pairing stats\n* `notify via email` to get your matches by email instead (or `notify via both`, or `notify via zulip` to switch back)\n* `announce on` to let me mention you by name when I announce the day's pairings (`announce off` to stop)\n* `digest off` to stop getting my weekly digest (`digest on` to start again)\n* `reminders on` to get a reminder the evening before you're matched, so you can still `skip tomorrow` (`reminders off` to stop)\n* `batch W1'24 2024-03-29` to tell me which batch you're in and the day it ends, so I know when to offboard you\n* `stay` to tell me you're still around when I ask at the end of a batch\n* `mydata` to see everything I have on you\n* `forget me` to have me delete your data and anonymise you in everyone's match history\n\nIf you've found a bug, please [submit an issue on github](https://github.com/thwidge/pairing-bot/issues)!"
+
+// SubscribeMessage is the answer to `subscribe`
+const SubscribeMessage string = "Yay! You're now subscribed to Pairing Bot!\nCurrently, I'm set to find pair programming partners for you on **Mondays**, **Tuesdays**, **Wednesdays**, **Thursdays**, and **Fridays**.\nYou can customize your schedule any time with `schedule` :)"
+
+const unsubscribeMessage string = "You're unsubscribed!\nI won't find pairing partners for you unless you `subscribe`.\n\nBe well :)"
+
+// NotSubscribedMessage is the answer to commands that need a subscription, from someone without one
+const NotSubscribedMessage string = "You're not subscribed to Pairing Bot <3"
+
+func (pl *PairingLogic) writeErrorMessage() string {
+	return fmt.Sprintf("Something went sideways while writing to the database. You should probably ping %v", pl.Config.Owner.Mention)
+}
+
+func (pl *PairingLogic) readErrorMessage() string {
+	return fmt.Sprintf("Something went sideways while reading from the database. You should probably ping %v", pl.Config.Owner.Mention)
+}
+
+func dispatch(ctx context.Context, pl *PairingLogic, cmd string, cmdArgs []string, userID string, userEmail string, userName string) (string, error) {
+	var response string
+	var err error
+
+	rec, err := pl.RDB.GetByUserID(ctx, userID, userEmail, userName)
+	if err != nil {
+		response = pl.readErrorMessage()
+		return response, err
+	}
+
+	isSubscribed := rec.IsSubscribed
+
+	// here's the actual actions. command input from
+	// the user input has already been sanitized, so we can
+	// trust that cmd and cmdArgs only have valid stuff in them
+	switch cmd {
+	case "schedule":
+		if !isSubscribed {
+			response = NotSubscribedMessage
+			break
+		}
+		// create a new blank schedule
+		var newSchedule = map[string]interface{}{
+			"monday":    false,
+			"tuesday":   false,
+			"wednesday": false,
+			"thursday":  false,
+			"friday":    false,
+			"saturday":  false,
+			"sunday":    false,
+		}
+		// populate it with the new days they want to pair on
+		for _, day := range cmdArgs {
+			newSchedule[day] = true
+		}
+		// put it in the database
+		rec.Schedule = newSchedule
+
+		err = pl.RDB.Set(ctx, userID, rec)
+
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = "Awesome, your new schedule's been set! You can check it with `status`."
+
+	case "subscribe":
+		if isSubscribed {
+			response = "You're already subscribed! Use `schedule` to set your schedule."
+			break
+		}
+
+		err = pl.RDB.Set(ctx, userID, rec)
+
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = SubscribeMessage
+
+	case "unsubscribe":
+		if !isSubscribed {
+			response = NotSubscribedMessage
+			break
+		}
+
+		err := pl.RDB.Delete(ctx, userID)
+
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = unsubscribeMessage
+
+	case "skip":
+		if !isSubscribed {
+			response = NotSubscribedMessage
+			break
+		}
+
+		rec.IsSkippingTomorrow = true
+
+		err := pl.RDB.Set(ctx, userID, rec)
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = `Tomorrow: cancelled. I feel you. **I will not match you** for pairing tomorrow <3`
+
+	case "unskip":
+		if !isSubscribed {
+			response = NotSubscribedMessage
+			break
+		}
+		rec.IsSkippingTomorrow = false
+
+		err := pl.RDB.Set(ctx, userID, rec)
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = "Tomorrow: uncancelled! Heckin *yes*! **I will match you** for pairing tomorrow :)"
+
+	case "status":
+		if !isSubscribed {
+			response = NotSubscribedMessage
+			break
+		}
+		// get their current name
+		whoami := rec.Name
+
+		// get skip status and prepare to write a sentence with it
+		var skipStr string
+		if rec.IsSkippingTomorrow {
+			skipStr = " "
+		} else {
+			skipStr = " not "
+		}
+
+		scheduleStr := formatSchedule(rec.Schedule)
+
+		response = fmt.Sprintf("* You're %v\n* You're scheduled for pairing on **%v**\n* **You're%vset to skip** pairing tomorrow\n* %v", whoami, scheduleStr, skipStr, formatOddOneOut(rec.LastOddOneOut))
+		if rec.NotifyVia == storage.NotifyViaEmail || rec.NotifyVia == storage.NotifyViaBoth {
+			response += "\n* " + notifyViaMessage(rec.NotifyVia, rec.Email)
+		}
+		if rec.Announce {
+			response += "\n* " + announceMessage(rec.Announce)
+		}
+		if !rec.Digest {
+			response += "\n* " + digestMessage(rec.Digest)
+		}
+		if rec.Reminders {
+			response += "\n* " + remindersMessage(rec.Reminders)
+		}
+		if rec.Batch != "" {
+			response += "\n* " + batchMessage(rec)
+		}
+		if rec.AskedToStay {
+			response += "\n* I asked whether you're still here. Reply `stay` to keep pairing after the next end of batch"
+		}
+
+	case "yes", "no", "rate":
+		// these are answers to the check-in we send after a match
+		var matches []storage.Match
+		matches, err = pl.MDB.ListByUserID(ctx, userID)
+		if err != nil {
+			response = pl.readErrorMessage()
+			break
+		}
+
+		m, ok := latestMatch(matches, time.Now())
+		if !ok {
+			response = NoRecentMatchMessage
+			break
+		}
+
+		checkIn := checkInFromCmd(cmd, cmdArgs)
+		err = pl.MDB.SetCheckIn(ctx, m.ID, userID, checkIn)
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = checkInThanks(checkIn, m.Names[m.PartnerOf(userID)])
+
+	case "notify":
+		if !isSubscribed {
+			response = NotSubscribedMessage
+			break
+		}
+		via := cmdArgs[0]
+		if via != storage.NotifyViaZulip && !pl.EmailEnabled {
+			response = "Sorry, I can't send email yet :( I'll keep messaging you here."
+			break
+		}
+		if via != storage.NotifyViaZulip && rec.Email == "" {
+			response = "Sorry, I don't know your email address, so I'll keep messaging you here."
+			break
+		}
+
+		rec.NotifyVia = via
+		err = pl.RDB.Set(ctx, userID, rec)
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = notifyViaMessage(via, rec.Email)
+
+	case "announce":
+		if !isSubscribed {
+			response = NotSubscribedMessage
+			break
+		}
+
+		rec.Announce = cmdArgs[0] == "on"
+		err = pl.RDB.Set(ctx, userID, rec)
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = announceMessage(rec.Announce)
+
+	case "digest":
+		if !isSubscribed {
+			response = NotSubscribedMessage
+			break
+		}
+
+		rec.Digest = cmdArgs[0] == "on"
+		err = pl.RDB.Set(ctx, userID, rec)
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = digestMessage(rec.Digest)
+
+	case "reminders":
+		if !isSubscribed {
+			response = NotSubscribedMessage
+			break
+		}
+
+		rec.Reminders = cmdArgs[0] == "on"
+		err = pl.RDB.Set(ctx, userID, rec)
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = remindersMessage(rec.Reminders)
+
+	case "batch":
+		if !isSubscribed {
+			response = NotSubscribedMessage
+			break
+		}
+		// with no arguments, it just says what we know
+		if len(cmdArgs) == 0 {
+			response = batchMessage(rec)
+			break
+		}
+
+		rec.Batch = cmdArgs[0]
+		rec.BatchEnd, _ = time.Parse(storage.BatchDateLayout, cmdArgs[1])
+		err = pl.RDB.Set(ctx, userID, rec)
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = batchMessage(rec)
+
+	case "stay":
+		if !isSubscribed {
+			response = NotSubscribedMessage
+			break
+		}
+
+		rec.AskedToStay = false
+		err = pl.RDB.Set(ctx, userID, rec)
+		if err != nil {
+			response = pl.writeErrorMessage()
+			break
+		}
+		response = StayMessage
+
+	case "history":
+		n := defaultHistoryLength
+		if len(cmdArgs) > 0 {
+			n, _ = strconv.Atoi(cmdArgs[0])
+		}
+		if n > maxHistoryLength {
+			n = maxHistoryLength
+		}
+
+		var matches []storage.Match
+		matches, err = pl.MDB.ListByUserID(ctx, userID)
+		if err != nil {
+			response = pl.readErrorMessage()
+			break
+		}
+		response = formatHistory(matches, userID, n)
+
+	case "stats":
+		var matches []storage.Match
+		matches, err = pl.MDB.ListByUserID(ctx, userID)
+		if err != nil {
+			response = pl.readErrorMessage()
+			break
+		}
+		response = formatStats(ComputeStats(matches, userID))
+
+	case "mydata":
+		// this works whether or not they're subscribed, since they might
+		// still be in someone's match history
+		response, err = pl.myData(ctx, rec)
+
+	case "forget":
+		response, err = pl.forgetMe(ctx, rec, cmdArgs)
+
+	case "admin":
+		// admin commands are only for admins. everyone else gets the help message
+		if !pl.isAdmin(userID) {
+			response = HelpMessage
+			break
+		}
+		response, err = dispatchAdmin(ctx, pl, cmdArgs, userID, userName)
+
+	case "help":
+		response = HelpMessage
+	default:
+		// this won't execute because all input has been sanitized
+		// by ParseCmd() and all cases are handled explicitly above
+	}
+	return response, err
+}
+
+// formatSchedule makes a lil nice-lookin schedule string, like "Mondays, Wednesdays, and Fridays"
+func formatSchedule(schedule map[string]interface{}) string {
+	// this particular days list is for sorting and printing the
+	// schedule correctly, since it's stored in a map in all lowercase
+	var daysList = []string{
+		"Monday",
+		"Tuesday",
+		"Wednesday",
+		"Thursday",
+		"Friday",
+		"Saturday",
+		"Sunday"}
+
+	// make a sorted list of their schedule
+	var days []string
+	for _, day := range daysList {
+		if on, _ := schedule[strings.ToLower(day)].(bool); on {
+			days = append(days, day)
+		}
+	}
+	if len(days) == 0 {
+		return ""
+	}
+
+	var scheduleStr string
+	for i := range days[:len(days)-1] {
+		scheduleStr += days[i] + "s, "
+	}
+	if len(days) > 1 {
+		scheduleStr += "and " + days[len(days)-1] + "s"
+	} else {
+		scheduleStr += days[0] + "s"
+	}
+	return scheduleStr
+}
+
+// notifyViaMessage says how someone's going to hear about their matches
+func notifyViaMessage(via, email string) string {
+	switch via {
+	case storage.NotifyViaEmail:
+		return fmt.Sprintf("I'll tell you about your matches **by email**, at %v", email)
+	case storage.NotifyViaBoth:
+		return fmt.Sprintf("I'll tell you about your matches **here and by email**, at %v", email)
+	default:
+		return "I'll tell you about your matches **here**"
+	}
+}
+
+// announceMessage says whether someone's named when the day's pairings are announced
+func announceMessage(announce bool) string {
+	if announce {
+		return "I'll mention you **by name** when I announce the day's pairings"
+	}
+	return "I **won't** mention you by name when I announce the day's pairings"
+}
+
+// digestMessage says whether someone gets the weekly digest
+func digestMessage(digest bool) string {
+	if digest {
+		return "I'll send you a **weekly digest** of your pairing"
+	}
+	return "I **won't** send you a weekly digest"
+}
+
+// remindersMessage says whether someone gets reminded the evening before they're matched
+func remindersMessage(reminders bool) string {
+	if reminders {
+		return "I'll **remind you** the evening before you're going to be matched"
+	}
+	return "I **won't** remind you before you're matched"
+}
@@ -0,0 +1,4 @@
+// Package commands is what pairing bot does. PairingLogic answers the
+// commands people send it, and runs the scheduled jobs (matching, check-ins,
+// digests, reminders and end of batch) that the server's cron routes call.
+package commands
@@ -0,0 +1,318 @@
+package commands
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/internal/format"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// the end of batch happens in two steps, so one stray request can't offboard
+// everyone. first "endofbatch" (or `admin endofbatch`) makes a plan, which
+// changes nothing. then an admin reads it and runs it with
+// `admin endofbatch confirm <code>`. offboarded people are kept in the
+// "offboarded" collection for a while, so `admin restore` can bring them back
+
+// RestoredMessage tells someone that `admin restore` has brought them back
+const RestoredMessage = "Hi! One of Pairing Bot's admins has resubscribed you, with the same schedule you had before.\n\nIf you'd rather not pair, just send me a message that says `unsubscribe`."
+
+// how long a plan can be confirmed for, before it's too old to trust
+const EndOfBatchPlanTTL = 24 * time.Hour
+
+// how long offboarded people can be restored for, if PB_OFFBOARD_RETENTION_DAYS isn't set
+const DefaultOffboardRetention = 30 * 24 * time.Hour
+
+// EndOfBatchPlanSummary is what "endofbatch" sends back as JSON
+type EndOfBatchPlanSummary struct {
+	Code     string `json:"code"`
+	Offboard int    `json:"offboard"`
+	Prompt   int    `json:"prompt"`
+}
+
+// PurgeSummary is what "purgeoffboarded" sends back as JSON
+type PurgeSummary struct {
+	Purged int `json:"purged"`
+}
+
+// Retention is how long offboarded people can be restored for, before
+// "purgeoffboarded" deletes them for good
+func (pl *PairingLogic) Retention() time.Duration {
+	if pl.OffboardRetention == 0 {
+		return DefaultOffboardRetention
+	}
+	return pl.OffboardRetention
+}
+
+// newPlanCode makes the code an admin has to type to confirm a plan, so
+// they can't confirm one they haven't seen
+func newPlanCode() (string, error) {
+	b := make([]byte, 3)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// PlanEndOfBatch works out who the end of batch would offboard and who it'd
+// ask to stay, and saves that as the plan waiting to be confirmed (replacing
+// any older one). it doesn't change anyone
+func (pl *PairingLogic) PlanEndOfBatch(ctx context.Context, createdBy string) (storage.EndOfBatchPlan, error) {
+	now := time.Now()
+	code, err := newPlanCode()
+	if err != nil {
+		return storage.EndOfBatchPlan{}, err
+	}
+	plan := storage.EndOfBatchPlan{Code: code, Created: now, CreatedBy: createdBy}
+
+	recursersList, err := pl.RDB.GetAllUsers(ctx)
+	if err != nil {
+		return storage.EndOfBatchPlan{}, err
+	}
+	for _, rec := range recursersList {
+		rec, alum, err := withBatchFrom(ctx, pl.Directory, rec)
+		if err != nil {
+			log.Printf("Could not look up the batch for recurser %v: %s\n", rec.ID, err)
+		}
+		if reason := offboardReason(rec, alum, now); reason != "" {
+			plan.Offboard = append(plan.Offboard, storage.PlannedOffboard{ID: rec.ID, Name: rec.Name, Reason: reason})
+		} else {
+			plan.Prompt = append(plan.Prompt, rec.ID)
+		}
+	}
+
+	err = pl.CDB.SetEndOfBatchPlan(ctx, plan)
+	if err != nil {
+		return storage.EndOfBatchPlan{}, err
+	}
+	return plan, nil
+}
+
+// planProblem says why a plan can't be confirmed with code, or "" if it can
+func planProblem(plan storage.EndOfBatchPlan, code string, now time.Time) string {
+	switch {
+	case plan.Code == "":
+		return "There's no end-of-batch plan waiting. Make one with `admin endofbatch`."
+	case plan.Code != code:
+		return fmt.Sprintf("The end-of-batch plan waiting is `%v`, not `%v`. Have a look at it with `admin endofbatch status` first.", plan.Code, code)
+	case now.Sub(plan.Created) > EndOfBatchPlanTTL:
+		return "That end-of-batch plan is more than a day old, so things might have changed since. Make a new one with `admin endofbatch`."
+	}
+	return ""
+}
+
+// RunEndOfBatch carries out a plan: it offboards the people it says to, and
+// asks the rest whether they're staying. anyone who's changed since the plan
+// was made (unsubscribed, said `stay`, or has a new batch) is left alone, and
+// so is anyone it would ask who's since become due to be offboarded
+func (pl *PairingLogic) RunEndOfBatch(ctx context.Context, plan storage.EndOfBatchPlan) (EndOfBatchSummary, error) {
+	var summary EndOfBatchSummary
+	now := time.Now()
+
+	// throw the plan away first, so it can only ever run once
+	err := pl.CDB.SetEndOfBatchPlan(ctx, storage.EndOfBatchPlan{})
+	if err != nil {
+		return summary, err
+	}
+
+	recursersList, err := pl.RDB.GetAllUsers(ctx)
+	if err != nil {
+		return summary, err
+	}
+	current := map[string]storage.Recurser{}
+	for _, rec := range recursersList {
+		current[rec.ID] = rec
+	}
+
+	var notifications []chat.Notification
+	for _, o := range plan.Offboard {
+		rec, ok := current[o.ID]
+		if !ok {
+			summary.Skipped++
+			continue
+		}
+		rec, alum, err := withBatchFrom(ctx, pl.Directory, rec)
+		if err != nil {
+			log.Printf("Could not look up the batch for recurser %v: %s\n", rec.ID, err)
+		}
+		if offboardReason(rec, alum, now) != o.Reason {
+			summary.Skipped++
+			continue
+		}
+
+		var message string
+		err = pl.RDB.Offboard(ctx, rec.ID, o.Reason, now)
+		if err != nil {
+			log.Println(err)
+			message = fmt.Sprintf("Uh oh, I was trying to offboard you since it's the end of batch, but something went wrong. Consider messaging %v to let them know this happened.", pl.Config.Owner.Mention)
+		} else {
+			log.Println("A user was offboarded because it's the end of a batch.")
+			message = offboardMessages[o.Reason]
+			summary.Offboarded++
+		}
+		notifications = append(notifications, pl.notificationsTo([]storage.Recurser{rec}, message)...)
+	}
+
+	for _, id := range plan.Prompt {
+		rec, ok := current[id]
+		if !ok {
+			summary.Skipped++
+			continue
+		}
+		// they might be due to be offboarded by now, so asking would be wrong
+		withBatch, alum, err := withBatchFrom(ctx, pl.Directory, rec)
+		if err != nil {
+			log.Printf("Could not look up the batch for recurser %v: %s\n", rec.ID, err)
+		}
+		if offboardReason(withBatch, alum, now) != "" {
+			summary.Skipped++
+			continue
+		}
+		rec.AskedToStay = true
+		err = pl.RDB.Set(ctx, rec.ID, rec)
+		if err != nil {
+			// if we can't remember asking, asking would just confuse them
+			log.Printf("Could not ask recurser %v whether they're staying: %s\n", rec.ID, err)
+			continue
+		}
+		summary.Prompted++
+		notifications = append(notifications, pl.notificationsTo([]storage.Recurser{rec}, StayPromptMessage)...)
+	}
+
+	botPassword, err := pl.ADB.GetKey(ctx, "apiauth", "key")
+	if err != nil {
+		log.Println("Something weird happened trying to read the auth token from the database")
+	}
+
+	summary.Notifications = chat.SendAll(ctx, pl.UN, botPassword, notifications, pl.NotifyWorkers)
+	return summary, nil
+}
+
+// TellAdmins messages every admin who's subscribed (we don't know how to reach the others)
+func (pl *PairingLogic) TellAdmins(ctx context.Context, message string) {
+	recursersList, err := pl.RDB.GetAllUsers(ctx)
+	if err != nil {
+		log.Printf("Could not read the admins from DB: %s\n", err)
+		return
+	}
+	var notifications []chat.Notification
+	for i, rec := range recursersList {
+		if pl.isAdmin(rec.ID) {
+			notifications = append(notifications, pl.notificationsTo(recursersList[i:i+1], message)...)
+		}
+	}
+
+	botPassword, err := pl.ADB.GetKey(ctx, "apiauth", "key")
+	if err != nil {
+		log.Println("Something weird happened trying to read the auth token from the database")
+	}
+	chat.SendAll(ctx, pl.UN, botPassword, notifications, pl.NotifyWorkers)
+}
+
+// FormatPlan is a plan as a message for admins: who'll be offboarded and why,
+// how many people will be asked to stay, and how to confirm or cancel it.
+// retention is how long the offboarded can be restored for
+func FormatPlan(plan storage.EndOfBatchPlan, retention time.Duration) string {
+	if plan.Code == "" {
+		return "There's no end-of-batch plan waiting. Make one with `admin endofbatch`."
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**End-of-batch plan `%v`**, made by %v. It can be confirmed until %v.\n\n",
+		plan.Code, plan.CreatedBy, plan.Created.Add(EndOfBatchPlanTTL).UTC().Format("Mon Jan 2 15:04 MST"))
+	if len(plan.Offboard) > 0 {
+		fmt.Fprintf(&b, "I'll offboard **%d** people:\n\n| Name | ID | Why |\n|---|---|---|\n", len(plan.Offboard))
+		for _, o := range plan.Offboard {
+			fmt.Fprintf(&b, "| %v | %v | %v |\n", o.Name, o.ID, o.Reason)
+		}
+		b.WriteString("\n")
+	} else {
+		b.WriteString("I won't offboard anyone.\n\n")
+	}
+	fmt.Fprintf(&b, "I'll ask **%d** people whether they're staying.\n\n", len(plan.Prompt))
+	fmt.Fprintf(&b, "Nothing has happened yet. To run it, send `admin endofbatch confirm %v`, or `admin endofbatch cancel` to throw it away. Offboarded people can be brought back with `admin restore` for %d days.",
+		plan.Code, int(retention.Hours()/24))
+	return b.String()
+}
+
+func formatOffboarded(offboarded []storage.OffboardedRecurser, retention time.Duration) string {
+	if len(offboarded) == 0 {
+		return "Nobody has been offboarded recently."
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%d offboarded:**\n\n| Name | ID | Email | Offboarded | Why | Restorable until |\n|---|---|---|---|---|---|\n", len(offboarded))
+	for _, o := range offboarded {
+		fmt.Fprintf(&b, "| %v | %v | %v | %v | %v | %v |\n", o.Recurser.Name, o.Recurser.ID, o.Recurser.Email,
+			format.Date(o.OffboardedAt), o.Reason, format.Date(o.OffboardedAt.Add(retention)))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// restore brings back one offboarded person (by ID or email), or everyone
+// for "all", and tells them so
+func (pl *PairingLogic) restore(ctx context.Context, who string) (string, error) {
+	offboarded, err := pl.RDB.ListOffboarded(ctx)
+	if err != nil {
+		return pl.readErrorMessage(), err
+	}
+
+	var toRestore []storage.Recurser
+	for _, o := range offboarded {
+		if who == "all" || o.Recurser.ID == who || strings.EqualFold(o.Recurser.Email, who) {
+			toRestore = append(toRestore, o.Recurser)
+		}
+	}
+	if len(toRestore) == 0 {
+		return fmt.Sprintf("I couldn't find anyone offboarded with the ID or email `%v`", who), nil
+	}
+
+	var restored []storage.Recurser
+	var resubscribed []string
+	for _, r := range toRestore {
+		rec, err := pl.RDB.Restore(ctx, r.ID)
+		if err == storage.ErrStillSubscribed {
+			resubscribed = append(resubscribed, r.Name)
+			continue
+		}
+		if err != nil {
+			return pl.writeErrorMessage(), err
+		}
+		// they start over, rather than being offboarded again for not saying `stay`
+		rec.AskedToStay = false
+		err = pl.RDB.Set(ctx, rec.ID, rec)
+		if err != nil {
+			log.Printf("Could not reset recurser %v after restoring them: %s\n", rec.ID, err)
+		}
+		restored = append(restored, rec)
+	}
+
+	var notifications []chat.Notification
+	for i := range restored {
+		notifications = append(notifications, pl.notificationsTo(restored[i:i+1], RestoredMessage)...)
+	}
+	botPassword, err := pl.ADB.GetKey(ctx, "apiauth", "key")
+	if err != nil {
+		log.Println("Something weird happened trying to read the auth token from the database")
+	}
+	chat.SendAll(ctx, pl.UN, botPassword, notifications, pl.NotifyWorkers)
+
+	message := fmt.Sprintf("Done! I restored **%d** people.", len(restored))
+	if len(resubscribed) > 0 {
+		message += fmt.Sprintf(" %v subscribed again on their own, so I left them as they are.", joinNames(resubscribed))
+	}
+	return message, nil
+}
+
+// RunPurge deletes everyone who was offboarded too long ago to be restored
+func (pl *PairingLogic) RunPurge(ctx context.Context, now time.Time) (PurgeSummary, error) {
+	purged, err := pl.RDB.PurgeOffboarded(ctx, now.Add(-pl.Retention()))
+	return PurgeSummary{Purged: purged}, err
+}
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+func TestPlanProblem(t *testing.T) {
+	now := time.Date(2024, 3, 29, 12, 0, 0, 0, time.UTC)
+	plan := storage.EndOfBatchPlan{Code: "1a2b3c", Created: now.Add(-time.Hour)}
+	tests := []struct {
+		testName string
+		plan     storage.EndOfBatchPlan
+		code     string
+		wantOK   bool
+	}{
+		{"ok", plan, "1a2b3c", true},
+		{"no_plan", storage.EndOfBatchPlan{}, "1a2b3c", false},
+		{"wrong_code", plan, "ffffff", false},
+		{"too_old", storage.EndOfBatchPlan{Code: "1a2b3c", Created: now.Add(-EndOfBatchPlanTTL - time.Minute)}, "1a2b3c", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if got := planProblem(tt.plan, tt.code, now); (got == "") != tt.wantOK {
+				t.Errorf("got %q", got)
+			}
+		})
+	}
+}
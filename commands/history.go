@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const noHistoryMessage = "You haven't been matched with anyone yet! Once you have, you'll be able to see them here."
+
+// how many matches `history` shows when it isn't told how many
+const defaultHistoryLength = 10
+
+// the most `history` will show, to keep the message a reasonable size
+const maxHistoryLength = 50
+
+// formatHistory makes a table of userID's matches, which are newest first
+func formatHistory(matches []storage.Match, userID string, n int) string {
+	if len(matches) == 0 {
+		return noHistoryMessage
+	}
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "**Your last %d matches:**\n\n| Date | Partner | Check-in |\n|---|---|---|\n", len(matches))
+	for _, m := range matches {
+		c, answered := m.CheckIns[userID]
+		fmt.Fprintf(&b, "| %v | %v | %v |\n", m.Date.Format("Mon Jan 2, 2006"), m.Names[m.PartnerOf(userID)], formatCheckIn(c, answered))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func formatCheckIn(c storage.CheckIn, answered bool) string {
+	switch {
+	case !answered:
+		return "-"
+	case !c.Happened:
+		return "didn't happen"
+	case c.Rating > 0:
+		return fmt.Sprintf("paired, %d/5", c.Rating)
+	default:
+		return "paired"
+	}
+}
+
+// PairingStats is what `stats` shows
+type PairingStats struct {
+	Matches        int
+	sessions       int
+	uniquePartners int
+	streak         int
+	NoShows        int
+}
+
+// ComputeStats works out userID's stats from their matches, which are newest first.
+// a match counts as a session unless they told us it didn't happen (a no-show),
+// and their streak is how many sessions in a row they've had, counting back from the latest
+func ComputeStats(matches []storage.Match, userID string) PairingStats {
+	s := PairingStats{Matches: len(matches)}
+	partners := map[string]bool{}
+	streakOver := false
+
+	for _, m := range matches {
+		partners[m.IDs[m.PartnerOf(userID)]] = true
+
+		c, ok := m.CheckIns[userID]
+		if ok && !c.Happened {
+			s.NoShows++
+			streakOver = true
+			continue
+		}
+		s.sessions++
+		if !streakOver {
+			s.streak++
+		}
+	}
+	s.uniquePartners = len(partners)
+	return s
+}
+
+func formatStats(s PairingStats) string {
+	if s.Matches == 0 {
+		return noHistoryMessage
+	}
+	return fmt.Sprintf("**Your pairing stats:**\n\n| | |\n|---|---|\n| Times matched | %d |\n| Pairing sessions | %d |\n| Unique partners | %d |\n| Current streak | %d |\n| No-shows | %d |",
+		s.Matches, s.sessions, s.uniquePartners, s.streak, s.NoShows)
+}
@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+func TestComputeStats(t *testing.T) {
+	day := time.Date(2020, time.March, 2, 4, 0, 0, 0, time.UTC)
+	// newest first, like ListByUserID gives them to us
+	matches := []storage.Match{
+		{Date: day, IDs: []string{"me", "a"}, CheckIns: map[string]storage.CheckIn{"me": {Happened: true, Rating: 5}}},
+		{Date: day.AddDate(0, 0, -1), IDs: []string{"b", "me"}},
+		{Date: day.AddDate(0, 0, -2), IDs: []string{"me", "a"}, CheckIns: map[string]storage.CheckIn{"me": {Happened: false}}},
+		{Date: day.AddDate(0, 0, -3), IDs: []string{"c", "me"}, CheckIns: map[string]storage.CheckIn{"c": {Happened: false}}},
+	}
+
+	got := ComputeStats(matches, "me")
+	want := PairingStats{Matches: 4, sessions: 3, uniquePartners: 3, streak: 2, NoShows: 1}
+	if got != want {
+		t.Errorf("got %+v, wanted %+v\n", got, want)
+	}
+}
+
+func TestFormatHistory(t *testing.T) {
+	day := time.Date(2020, time.March, 2, 4, 0, 0, 0, time.UTC)
+	matches := []storage.Match{
+		{Date: day, IDs: []string{"me", "a"}, Names: []string{"Me", "Ada"}, CheckIns: map[string]storage.CheckIn{"me": {Happened: true, Rating: 4}}},
+		{Date: day.AddDate(0, 0, -1), IDs: []string{"b", "me"}, Names: []string{"Bea", "Me"}},
+	}
+
+	got := formatHistory(matches, "me", 1)
+	if !strings.Contains(got, "| Mon Mar 2, 2020 | Ada | paired, 4/5 |") {
+		t.Errorf("history is missing the latest match:\n%v\n", got)
+	}
+	if strings.Contains(got, "Bea") {
+		t.Errorf("history has more matches than asked for:\n%v\n", got)
+	}
+
+	if formatHistory(nil, "me", 10) != noHistoryMessage {
+		t.Errorf("expected the no-history message for someone who's never been matched\n")
+	}
+}
@@ -0,0 +1,166 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// `mydata` shows someone everything we have on them, and `forget me` gets rid
+// of it. there's no separate list of skips: the only skip we keep is
+// isSkippingTomorrow, which is in their record
+
+// ForgetMeMessage is the answer to `forget me`, which only asks
+const ForgetMeMessage = "This will delete everything I have on you: your subscription, your schedule and settings, anything kept from an end of batch, and which chat rooms I've opened with you. " +
+	"In the match history of the people you've paired with, and in the audit log, your name, email and ID will be replaced with \"" + storage.ForgottenName + "\", and your check-in answers deleted.\n\n" +
+	"It can't be undone, and you'd start from scratch if you `subscribe` again. If you're sure, send `forget me confirm`. (`mydata` shows you what I have first.)"
+
+// ForgottenMessage is the answer to `forget me confirm`, once it's done
+const ForgottenMessage = "Done. I've forgotten you: there's nothing left with your name, email or ID on it. Take care :)"
+
+// zulip won't take a message much longer than 10000 characters
+const maxMyDataLength = 9000
+
+// MyData is what `mydata` sends back. it reuses the backup format, so it
+// matches what an export would have on them
+type MyData struct {
+	Subscribed bool                      `json:"subscribed"`
+	Recurser   *storage.BackupRecurser   `json:"recurser,omitempty"`
+	Offboarded *storage.BackupOffboarded `json:"offboarded,omitempty"`
+	Matches    []MyMatch                 `json:"matches"`
+	// how many of the oldest matches were left out to fit in a message
+	OlderMatchesLeftOut int `json:"olderMatchesLeftOut,omitempty"`
+}
+
+// MyMatch is one of someone's matches, with only their own check-in
+type MyMatch struct {
+	Date     time.Time              `json:"date"`
+	Partners []string               `json:"partners"`
+	CheckIn  *storage.BackupCheckIn `json:"checkIn,omitempty"`
+}
+
+func (pl *PairingLogic) myData(ctx context.Context, rec storage.Recurser) (string, error) {
+	data := MyData{Subscribed: rec.IsSubscribed, Matches: []MyMatch{}}
+	if rec.IsSubscribed {
+		b := storage.ToBackupRecurser(rec)
+		data.Recurser = &b
+	}
+
+	offboarded, err := pl.RDB.ListOffboarded(ctx)
+	if err != nil {
+		return pl.readErrorMessage(), err
+	}
+	for _, o := range offboarded {
+		if o.Recurser.ID == rec.ID {
+			data.Offboarded = &storage.BackupOffboarded{BackupRecurser: storage.ToBackupRecurser(o.Recurser), OffboardedAt: o.OffboardedAt, OffboardReason: o.Reason}
+		}
+	}
+
+	matches, err := pl.MDB.ListByUserID(ctx, rec.ID)
+	if err != nil {
+		return pl.readErrorMessage(), err
+	}
+	for _, m := range matches {
+		mm := MyMatch{Date: m.Date, Partners: []string{}}
+		for i, id := range m.IDs {
+			if id != rec.ID {
+				mm.Partners = append(mm.Partners, m.Names[i])
+			}
+		}
+		if c, ok := m.CheckIns[rec.ID]; ok {
+			mm.CheckIn = &storage.BackupCheckIn{Happened: c.Happened, Rating: c.Rating}
+		}
+		data.Matches = append(data.Matches, mm)
+	}
+
+	// matches are newest first, so drop from the end until it fits
+	for {
+		js, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return pl.readErrorMessage(), err
+		}
+		if len(js) <= maxMyDataLength || len(data.Matches) == 0 {
+			return "Here's everything I have on you:\n```json\n" + string(js) + "\n```", nil
+		}
+		data.Matches = data.Matches[:len(data.Matches)-1]
+		data.OlderMatchesLeftOut++
+	}
+}
+
+// forget deletes rec, anonymises them everywhere else they show up, and drops
+// anything still waiting to be sent to them
+func (pl *PairingLogic) forget(ctx context.Context, rec storage.Recurser) error {
+	err := pl.RDB.Forget(ctx, rec.ID)
+	if err != nil {
+		return err
+	}
+
+	matches, err := pl.MDB.Forget(ctx, rec.ID)
+	if err != nil {
+		return err
+	}
+
+	entries, err := pl.Audit.Forget(ctx, rec.ID, rec.Email)
+	if err != nil {
+		return err
+	}
+
+	rooms := 0
+	if platform := storage.PlatformOf(rec.ID); pl.Rooms != nil && platform != storage.ZulipPlatform {
+		rooms, err = pl.Rooms.Forget(ctx, platform, strings.TrimPrefix(rec.ID, platform+":"))
+		if err != nil {
+			return err
+		}
+	}
+
+	dropped := 0
+	if pl.Outbox != nil {
+		msgs, err := pl.Outbox.ODB.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if !isRecipient(msg.To, rec) {
+				continue
+			}
+			err = pl.Outbox.ODB.Delete(ctx, msg.ID)
+			if err != nil {
+				return err
+			}
+			dropped++
+		}
+	}
+
+	// no names in the log, that's the whole point
+	log.Printf("Forgot someone: anonymised %d matches and %d audit entries, dropped %d rooms and %d outbox messages", matches, entries, rooms, dropped)
+	return nil
+}
+
+// isRecipient says whether rec is one of the people to is for. it can be a
+// comma-separated group, like a pair's match message
+func isRecipient(to string, rec storage.Recurser) bool {
+	for _, addr := range strings.Split(to, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == rec.ID || (rec.Email != "" && strings.EqualFold(addr, rec.Email)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pl *PairingLogic) forgetMe(ctx context.Context, rec storage.Recurser, cmdArgs []string) (string, error) {
+	if len(cmdArgs) == 0 {
+		return ForgetMeMessage, nil
+	}
+
+	err := pl.forget(ctx, rec)
+	if err != nil {
+		return fmt.Sprintf("%v Some of your data may already be gone, so it's fine to send `forget me confirm` again.", pl.writeErrorMessage()), err
+	}
+	return ForgottenMessage, nil
+}
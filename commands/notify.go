@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// notificationsTo works out how to send message to a group of recursers, going
+// by how each of them wants to hear from us. Everyone who wants chat messages
+// gets the one message together, and everyone who wants email gets their own.
+// People we don't have an email address for always get a chat message
+func (pl *PairingLogic) notificationsTo(recs []storage.Recurser, message string) []chat.Notification {
+	var onChat []string
+	var emails []chat.Notification
+	for _, r := range recs {
+		canEmail := pl.EmailEnabled && r.Email != ""
+		if r.NotifyVia != storage.NotifyViaEmail || !canEmail {
+			onChat = append(onChat, r.Address())
+		}
+		if (r.NotifyVia == storage.NotifyViaEmail || r.NotifyVia == storage.NotifyViaBoth) && canEmail {
+			emails = append(emails, chat.Notification{To: chat.EmailAddress(r.Email), Message: withRecipients(message, recs)})
+		}
+	}
+
+	var notifications []chat.Notification
+	if len(onChat) > 0 {
+		notifications = append(notifications, chat.Notification{To: strings.Join(onChat, ", "), Message: message})
+	}
+	return append(notifications, emails...)
+}
+
+// withRecipients adds who a group message went to, since an email to just one
+// of them doesn't show that like a group chat does
+func withRecipients(message string, recs []storage.Recurser) string {
+	if len(recs) < 2 {
+		return message
+	}
+	var names []string
+	for _, r := range recs {
+		if r.Email != "" {
+			names = append(names, fmt.Sprintf("%s (%s)", r.Name, r.Email))
+		} else {
+			names = append(names, r.Name)
+		}
+	}
+	return message + "\n\nThis message went to: " + strings.Join(names, ", ")
+}
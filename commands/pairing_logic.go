@@ -0,0 +1,222 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/config"
+	"github.com/thwidge/pairing-bot/matching"
+	"github.com/thwidge/pairing-bot/metrics"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// OddOneOutMessage goes to whoever's left without a partner
+const OddOneOutMessage string = "OK this is awkward.\nThere were an odd number of people in the match-set today, which means that one person couldn't get paired. Unfortunately, it was you -- I'm really sorry :(\nI promise it's not personal: I always pick whoever has gone the longest without being left out, so this shouldn't happen again for a while. Enjoy your day! <3"
+
+// MatchedMessage goes to each pair. with a directory, introductions are added to it
+const MatchedMessage = "Hi you two! You've been matched for pairing :)\n\nHave fun!"
+
+const defaultMaintenanceMessage = `pairing bot is down for maintenance`
+
+// PairingLogic holds everything the bot needs to answer commands and run its
+// jobs. the zero value of the optional fields turns those features off
+type PairingLogic struct {
+	// who the owner is, and whether PB_MAINT is on
+	Config config.Config
+	RDB    storage.RecurserDB
+	ADB    storage.APIAuthDB
+	MDB    storage.MatchDB
+	CDB    storage.ConfigDB
+	// every admin command gets written here
+	Audit storage.AuditDB
+	UR    chat.UserRequest
+	UN    chat.UserNotification
+	// for posting in zulip streams, like the announcement after matching
+	Streams chat.StreamNotification
+	// where messages that couldn't be delivered wait to be retried
+	Outbox *chat.OutboxUserNotification
+	// IDs of the people who can use `admin` commands (zulip IDs, or prefixed ones like slack:U024BE7LH)
+	Admins []string
+	// how many messages to send at once. 0 means chat.SendAll's default
+	NotifyWorkers int
+	// chat platforms other than Zulip. each gets its own /<name>/events endpoint
+	Platforms []chat.Platform
+	// the rooms we've opened on platforms like Matrix, so `forget me` can drop
+	// the ones someone's in. nil if no platform opens rooms
+	Rooms storage.RoomDB
+	// whether UN can send email, so people can ask for their notifications by email
+	EmailEnabled bool
+	// where to look people up, for their batch and to introduce them to their
+	// matches. anyone it says isn't current (an alum) isn't matched, and is
+	// offboarded at the end of batch. nil means we only know what people tell us
+	Directory Directory
+	// how long offboarded people can be restored for. 0 means DefaultOffboardRetention
+	OffboardRetention time.Duration
+}
+
+var randSrc = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// ErrMaintenance is what Respond fails with when it only said that the bot's
+// down for maintenance
+var ErrMaintenance = errors.New("maintenance mode is on")
+
+// Respond works out what to say back to a command, on any chat platform.
+// there's always something to say, even when it fails, and the error (which
+// has already been logged) is just so the caller knows how it went
+func (pl *PairingLogic) Respond(ctx context.Context, userData *chat.UserDataFromJSON, cmd string, cmdArgs []string) (string, error) {
+	// this responds with a maintenance message and quits if maintenance mode is on,
+	// unless the request is coming from an admin or someone on the maintenance allow-list
+	maintenance, err := pl.CDB.GetMaintenance(ctx)
+	if err != nil {
+		log.Printf("Could not read maintenance mode from DB: %s\n", err)
+	}
+	if pl.Config.Maintenance {
+		maintenance.Enabled = true
+	}
+	if maintenance.Enabled && !pl.isAdmin(userData.UserID) && !contains(maintenance.AllowedUsers, userData.UserID) {
+		if maintenance.Message == "" {
+			return defaultMaintenanceMessage, ErrMaintenance
+		}
+		return maintenance.Message, ErrMaintenance
+	}
+
+	// the tofu and potatoes right here y'all
+
+	response, err := dispatch(ctx, pl, cmd, cmdArgs, userData.UserID, userData.UserEmail, userData.UserName)
+	if err != nil {
+		log.Println(err)
+		return response, err
+	}
+
+	// so the weekly digest doesn't suggest commands people already know
+	pl.noteTriedCommand(ctx, userData, cmd)
+	return response, nil
+}
+
+// MatchSummary is what "match" sends back as JSON
+type MatchSummary struct {
+	Pairs int `json:"pairs"`
+	// one per chat platform at most
+	OddOneOuts    int                      `json:"oddOneOuts"`
+	Notifications chat.NotificationSummary `json:"notifications"`
+	// whether the day's matches were announced in a stream
+	Announced bool `json:"announced"`
+	// people subscribed for the day who the directory says are alums, so weren't matched
+	Alums int `json:"alums"`
+}
+
+// EndOfBatchSummary is how running an end-of-batch plan went
+type EndOfBatchSummary struct {
+	Offboarded int `json:"offboarded"`
+	// everyone who wasn't offboarded was asked to `stay`
+	Prompted int `json:"prompted"`
+	// people in the plan who'd changed by the time it ran, so were left alone
+	Skipped       int                      `json:"skipped"`
+	Notifications chat.NotificationSummary `json:"notifications"`
+}
+
+// InMaintenance checks whether the scheduled jobs should be paused.
+// if we can't tell, we carry on as normal
+func (pl *PairingLogic) InMaintenance(ctx context.Context) bool {
+	if pl.Config.Maintenance {
+		return true
+	}
+	maintenance, err := pl.CDB.GetMaintenance(ctx)
+	if err != nil {
+		log.Printf("Could not read maintenance mode from DB: %s\n", err)
+		return false
+	}
+	return maintenance.Enabled
+}
+
+// RunMatch does the actual matching for "match" and for `admin match now`,
+// and sends everyone their messages. with a directory, it leaves out anyone
+// who's an alum, and introduces pairs to each other
+func (pl *PairingLogic) RunMatch(ctx context.Context) MatchSummary {
+	var summary MatchSummary
+
+	recursersList, err := pl.RDB.ListPairingTomorrow(ctx)
+	if err != nil {
+		log.Printf("Could not get list of recursers from DB: %s\n", err)
+	}
+
+	recursersList, summary.Alums = withoutAlums(ctx, pl.Directory, recursersList)
+
+	skippersList, err := pl.RDB.ListSkippingTomorrow(ctx)
+	if err != nil {
+		log.Printf("Could not get list of skippers from DB: %s\n", err)
+	}
+
+	// get everyone who was set to skip today and set them back to isSkippingTomorrow = false
+	for _, skipper := range skippersList {
+		err := pl.RDB.UnsetSkippingTomorrow(ctx, skipper)
+		if err != nil {
+			log.Printf("Could not unset skipping for recurser %v: %s\n", skipper.ID, err)
+		}
+	}
+
+	// if for some reason there's no matches today, we're done
+	if len(recursersList) == 0 {
+		log.Println("No one was signed up to pair today -- so there were no matches")
+		metrics.MatchRun(0, 0)
+		return summary
+	}
+
+	var notifications []chat.Notification
+	// everyone who got a match, for the announcement
+	var paired []storage.Recurser
+
+	// don't pair people up again if they both told us their last session didn't happen
+	recentMatches, err := pl.MDB.ListSince(ctx, time.Now().AddDate(0, 0, -28))
+	if err != nil {
+		log.Printf("Could not get recent matches from DB: %s\n", err)
+	}
+	plan := matching.PlanMatches(recursersList, matching.NoShowPairs(recentMatches), randSrc)
+
+	// tell the odd ones out they don't get a match today
+	for _, recurser := range plan.OddOneOuts {
+		summary.OddOneOuts++
+		log.Println("Someone was the odd-one-out today")
+
+		err := pl.RDB.RecordOddOneOut(ctx, recurser, time.Now())
+		if err != nil {
+			log.Printf("Could not record odd-one-out for recurser %v: %s\n", recurser.ID, err)
+		}
+
+		notifications = append(notifications, pl.notificationsTo([]storage.Recurser{recurser}, OddOneOutMessage)...)
+	}
+
+	for _, pair := range plan.Pairs {
+
+		addresses := pair[0].Address() + ", " + pair[1].Address()
+		paired = append(paired, pair[:]...)
+		notifications = append(notifications, pl.notificationsTo(pair[:], pl.matchMessage(ctx, pair[:]))...)
+		log.Println(pair[0].Address(), "was", "matched", "with", pair[1].Address())
+
+		err = pl.MDB.Add(ctx, storage.Match{
+			Date:   time.Now(),
+			IDs:    []string{pair[0].ID, pair[1].ID},
+			Names:  []string{pair[0].Name, pair[1].Name},
+			Emails: []string{pair[0].Address(), pair[1].Address()},
+		})
+		if err != nil {
+			log.Printf("Could not record match for %s: %s\n", addresses, err)
+		}
+	}
+	summary.Pairs = len(plan.Pairs)
+	metrics.MatchRun(summary.Pairs, summary.OddOneOuts)
+
+	// message the peeps!
+	botPassword, err := pl.ADB.GetKey(ctx, "apiauth", "key")
+	if err != nil {
+		log.Println("Something weird happened trying to read the auth token from the database")
+	}
+
+	summary.Notifications = chat.SendAll(ctx, pl.UN, botPassword, notifications, pl.NotifyWorkers)
+	summary.Announced = pl.announceMatches(ctx, botPassword, paired, summary)
+	return summary
+}
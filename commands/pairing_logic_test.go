@@ -0,0 +1,27 @@
+package commands
+
+import (
+	"context"
+	"testing"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/config"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+func TestConfigMaintenance(t *testing.T) {
+	ctx := context.Background()
+	pl := &PairingLogic{
+		Config: config.Config{Maintenance: true},
+		CDB:    storage.NewMemoryConfigDB(),
+	}
+
+	// PB_MAINT wins, even though the database says maintenance is off
+	if !pl.InMaintenance(ctx) {
+		t.Error("the scheduled jobs aren't paused")
+	}
+	got, err := pl.Respond(ctx, &chat.UserDataFromJSON{UserID: "2"}, "status", nil)
+	if got != defaultMaintenanceMessage || err != ErrMaintenance {
+		t.Errorf("got %q, %v, wanted the maintenance message", got, err)
+	}
+}
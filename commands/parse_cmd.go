@@ -0,0 +1,223 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+type parsingErr struct{ msg string }
+
+func (e parsingErr) Error() string {
+	return fmt.Sprintf("Error when parsing command: %s", e.msg)
+}
+
+// every command Pairing Bot knows
+var cmdList = []string{
+	"subscribe",
+	"unsubscribe",
+	"help",
+	"schedule",
+	"skip",
+	"unskip",
+	"status",
+	"yes",
+	"no",
+	"rate",
+	"history",
+	"stats",
+	"notify",
+	"announce",
+	"digest",
+	"reminders",
+	"batch",
+	"stay",
+	"mydata",
+	"forget",
+	"admin"}
+
+// ParseCmd turns what someone typed into a command and its arguments. case
+// and extra spaces don't matter, except in arguments that have to be kept as
+// they were typed, like a batch name or an admin broadcast. anything it can't
+// make sense of is "help", with an error saying what was wrong
+func ParseCmd(cmdStr string) (string, []string, error) {
+	var err error
+	var daysList = []string{
+		"monday",
+		"tuesday",
+		"wednesday",
+		"thursday",
+		"friday",
+		"saturday",
+		"sunday"}
+
+	var ratingsList = []string{"1", "2", "3", "4", "5"}
+
+	var notifyViaList = []string{storage.NotifyViaZulip, storage.NotifyViaEmail, storage.NotifyViaBoth}
+
+	// some arguments (like an admin broadcast) have to be passed on exactly as
+	// they were typed, so hang on to the original before we clean it up
+	rawStr := cmdStr
+
+	// convert the string to a slice
+	// after this, we have a value "cmd" of type []string
+	// where cmd[0] is the command and cmd[1:] are any arguments
+	space := regexp.MustCompile(`\s+`)
+	cmdStr = space.ReplaceAllString(cmdStr, ` `)
+	cmdStr = strings.TrimSpace(cmdStr)
+	cmdStr = strings.ToLower(cmdStr)
+	cmd := strings.Split(cmdStr, ` `)
+
+	// Big validation logic -- hellooo darkness my old frieeend
+	switch {
+	// if there's nothing in the command string srray
+	case len(cmd) == 0:
+		err = errors.New("the user-issued command was blank")
+		return "help", nil, err
+
+	// if there's a valid command and if there's no arguments
+	case contains(cmdList, cmd[0]) && len(cmd) == 1:
+		if cmd[0] == "schedule" || cmd[0] == "skip" || cmd[0] == "unskip" || cmd[0] == "rate" || cmd[0] == "notify" || cmd[0] == "announce" || cmd[0] == "digest" || cmd[0] == "reminders" || cmd[0] == "forget" || cmd[0] == "admin" {
+			err = &parsingErr{"the user issued a command without args, but it reqired args"}
+			return "help", nil, err
+		}
+		return cmd[0], nil, err
+
+	// if there's a valid command and there's some arguments
+	case contains(cmdList, cmd[0]) && len(cmd) > 1:
+		switch {
+		case cmd[0] == "subscribe" || cmd[0] == "unsubscribe" || cmd[0] == "help" || cmd[0] == "status" || cmd[0] == "yes" || cmd[0] == "no" || cmd[0] == "stats" || cmd[0] == "stay" || cmd[0] == "mydata":
+			err = &parsingErr{"the user issued a command with args, but it disallowed args"}
+			return "help", nil, err
+		case cmd[0] == "skip" && (len(cmd) != 2 || cmd[1] != "tomorrow"):
+			err = &parsingErr{"the user issued SKIP with malformed arguments"}
+			return "help", nil, err
+		case cmd[0] == "unskip" && (len(cmd) != 2 || cmd[1] != "tomorrow"):
+			err = &parsingErr{"the user issued UNSKIP with malformed arguments"}
+			return "help", nil, err
+		case cmd[0] == "rate" && (len(cmd) != 2 || !contains(ratingsList, cmd[1])):
+			err = &parsingErr{"the user issued RATE with malformed arguments"}
+			return "help", nil, err
+		case cmd[0] == "history" && (len(cmd) != 2 || !isPositiveInt(cmd[1])):
+			err = &parsingErr{"the user issued HISTORY with malformed arguments"}
+			return "help", nil, err
+		case cmd[0] == "notify" && (len(cmd) != 3 || cmd[1] != "via" || !contains(notifyViaList, cmd[2])):
+			err = &parsingErr{"the user issued NOTIFY with malformed arguments"}
+			return "help", nil, err
+		case cmd[0] == "notify":
+			return "notify", cmd[2:], err
+		case cmd[0] == "announce" && (len(cmd) != 2 || (cmd[1] != "on" && cmd[1] != "off")):
+			err = &parsingErr{"the user issued ANNOUNCE with malformed arguments"}
+			return "help", nil, err
+		case cmd[0] == "digest" && (len(cmd) != 2 || (cmd[1] != "on" && cmd[1] != "off")):
+			err = &parsingErr{"the user issued DIGEST with malformed arguments"}
+			return "help", nil, err
+		case cmd[0] == "reminders" && (len(cmd) != 2 || (cmd[1] != "on" && cmd[1] != "off")):
+			err = &parsingErr{"the user issued REMINDERS with malformed arguments"}
+			return "help", nil, err
+		case cmd[0] == "batch" && (len(cmd) != 3 || !isDate(cmd[2])):
+			err = &parsingErr{"the user issued BATCH with malformed arguments"}
+			return "help", nil, err
+		case cmd[0] == "batch":
+			// batch names keep the capitalization they were typed with, like W1'24
+			return "batch", []string{strings.Fields(rawStr)[1], cmd[2]}, err
+		case cmd[0] == "forget" && (cmd[1] != "me" || len(cmd) > 3 || (len(cmd) == 3 && cmd[2] != "confirm")):
+			err = &parsingErr{"the user issued FORGET with malformed arguments"}
+			return "help", nil, err
+		case cmd[0] == "forget":
+			// `forget me` asks for confirmation, and `forget me confirm` gives it
+			return "forget", cmd[2:], err
+		case cmd[0] == "admin":
+			return parseAdminCmd(cmd[1:], rawStr)
+		case cmd[0] == "schedule":
+			for _, v := range cmd[1:] {
+				if !contains(daysList, v) {
+					err = &parsingErr{"the user issued SCHEDULE with malformed arguments"}
+					return "help", nil, err
+				}
+			}
+			fallthrough
+		default:
+			return cmd[0], cmd[1:], err
+		}
+
+	// if there's not a valid command
+	default:
+		err = &parsingErr{"the user-issued command wasn't valid"}
+		return "help", nil, err
+	}
+}
+
+// parseAdminCmd validates everything after `admin`. The command it returns is
+// always "admin", with the admin subcommand as the first argument
+func parseAdminCmd(args []string, rawStr string) (string, []string, error) {
+	switch {
+	case (args[0] == "list" || args[0] == "endofbatch" || args[0] == "report" || args[0] == "outbox" || args[0] == "offboarded") && len(args) == 1:
+		return "admin", args, nil
+	case args[0] == "endofbatch" && len(args) == 2 && (args[1] == "status" || args[1] == "cancel"):
+		return "admin", args, nil
+	case args[0] == "endofbatch" && len(args) == 3 && args[1] == "confirm":
+		return "admin", args, nil
+	case (args[0] == "show" || args[0] == "unsubscribe" || args[0] == "restore") && len(args) == 2:
+		return "admin", args, nil
+	case args[0] == "match" && len(args) == 2 && args[1] == "now":
+		return "admin", args, nil
+	case args[0] == "maintenance" && len(args) == 2 && (args[1] == "off" || args[1] == "status"):
+		return "admin", args, nil
+	case args[0] == "maintenance" && len(args) >= 2 && args[1] == "on":
+		// an optional message for people who message the bot during maintenance,
+		// kept exactly as the admin typed it
+		return "admin", []string{"maintenance", "on", skipWords(rawStr, 3)}, nil
+	case args[0] == "maintenance" && len(args) == 3 && (args[1] == "allow" || args[1] == "disallow"):
+		return "admin", args, nil
+	case args[0] == "announce" && len(args) == 2 && (args[1] == "on" || args[1] == "off" || args[1] == "status"):
+		return "admin", args, nil
+	case args[0] == "announce" && len(args) > 2 && (args[1] == "stream" || args[1] == "topic" || args[1] == "template"):
+		// stream names, topics and templates are kept exactly as the admin typed them
+		return "admin", []string{"announce", args[1], skipWords(rawStr, 3)}, nil
+	case args[0] == "broadcast" && len(args) > 1:
+		// the message goes out exactly as the admin typed it
+		return "admin", []string{"broadcast", skipWords(rawStr, 2)}, nil
+	default:
+		return "help", nil, &parsingErr{"the user issued ADMIN with malformed arguments"}
+	}
+}
+
+// skipWords returns what's left of s after its first n words, with its
+// original capitalization and whitespace (minus any at the very start)
+func skipWords(s string, n int) string {
+	for i := 0; i < n; i++ {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if end := strings.IndexFunc(s, unicode.IsSpace); end >= 0 {
+			s = s[end:]
+		} else {
+			s = ""
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+func contains(list []string, cmd string) bool {
+	for _, v := range list {
+		if v == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+func isDate(s string) bool {
+	_, err := time.Parse(storage.BatchDateLayout, s)
+	return err == nil
+}
+
+func isPositiveInt(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0
+}
@@ -0,0 +1,245 @@
+package commands
+
+import "testing"
+
+var daysList = map[string]struct{}{
+	"monday":    {},
+	"tuesday":   {},
+	"wednesday": {},
+	"thursday":  {},
+	"friday":    {},
+	"saturday":  {},
+	"sunday":    {},
+}
+
+// due to the difficulties around comparing error return values (and because we don't want to compare error messages),
+// the struct contains expectErr to indicate whether an error is expected, instead of an actual error value
+var tableNoArgs = []struct {
+	testName   string
+	inputStr   string
+	wantedCmd  string
+	wantedArgs []string
+	expectErr  bool
+}{
+	{"subscribe_correct_usage", "subscribe", "subscribe", nil, false},
+	{"subscribe_wrong_usage", "subscribe mon", "help", nil, true},
+	{"unsubscribe_correct_usage", "unsubscribe", "unsubscribe", nil, false},
+	{"unsubscribe_wrong_usage", "unsubscribe tuesday", "help", nil, true},
+	{"help_correct_usage", "help", "help", nil, false},
+	{"help_wrong_usage", "help me", "help", nil, true},
+	{"status_correct_usage", "status", "status", nil, false},
+	{"status_wrong_usage", "status me", "help", nil, true},
+	{"yes_correct_usage", "yes", "yes", nil, false},
+	{"yes_wrong_usage", "yes please", "help", nil, true},
+	{"no_correct_usage", "no", "no", nil, false},
+	{"no_wrong_usage", "no thanks", "help", nil, true},
+	{"history_correct_usage", "history", "history", nil, false},
+	{"stats_correct_usage", "stats", "stats", nil, false},
+	{"stats_wrong_usage", "stats please", "help", nil, true},
+	{"stay_correct_usage", "stay", "stay", nil, false},
+	{"stay_wrong_usage", "stay here", "help", nil, true},
+	{"mydata_correct_usage", "mydata", "mydata", nil, false},
+	{"mydata_wrong_usage", "mydata please", "help", nil, true},
+	{"forget_no_args", "forget", "help", nil, true},
+	{"batch_correct_usage", "batch", "batch", nil, false},
+}
+
+func TestParseCmdNoArgs(t *testing.T) {
+	for _, tt := range tableNoArgs {
+		t.Run(tt.testName, func(t *testing.T) {
+			gotCmd, gotArgs, gotErr := ParseCmd(tt.inputStr)
+			if gotCmd != tt.wantedCmd {
+				t.Errorf("got %v, %v\n", gotCmd, gotArgs)
+			}
+
+			_, ok := gotErr.(*parsingErr)
+
+			if tt.expectErr && !ok {
+				t.Errorf("Expected parsingErr but didn't get one\n")
+			} else if !tt.expectErr && ok {
+				t.Errorf("Got unexpected parsingError\n")
+			}
+		})
+	}
+}
+
+var tableWithArgs = []struct {
+	testName   string
+	inputStr   string
+	wantedCmd  string
+	wantedArgs []string
+	expectErr  bool
+}{
+	{"schedule_1_arg", "schedule monday", "schedule", []string{"monday"}, false},
+	{"schedule_2_args", "schedule monday friday", "schedule", []string{"monday", "friday"}, false},
+	{"schedule_3_args", "schedule monday wednesday friday", "schedule", []string{"monday", "wednesday", "friday"}, false},
+	{"schedule_4_args", "schedule monday wednesday friday sunday", "schedule", []string{"monday", "wednesday", "friday", "sunday"}, false},
+	{"schedule_weekend_only", "schedule sunday", "schedule", []string{"sunday"}, false},
+	{"schedule_wrong_usage", "schedule", "help", nil, true},
+	{"skip_correct_usage", "skip tomorrow", "skip", []string{"tomorrow"}, false},
+	{"skip_wrong_usage", "skip monday", "help", nil, true},
+	{"skip_wrong_usage", "skip whenever", "help", nil, true},
+	{"skip_wrong_usage", "skip", "help", nil, true},
+	{"unskip_correct_usage", "unskip tomorrow", "unskip", []string{"tomorrow"}, false},
+	{"unskip_wrong_usage", "unskip today", "help", nil, true},
+	{"unskip_wrong_usage", "unskip friday", "help", nil, true},
+	{"unskip_wrong_usage", "unskip", "help", nil, true},
+	{"rate_correct_usage", "rate 4", "rate", []string{"4"}, false},
+	{"rate_wrong_usage", "rate", "help", nil, true},
+	{"rate_wrong_usage", "rate 6", "help", nil, true},
+	{"rate_wrong_usage", "rate 0", "help", nil, true},
+	{"rate_wrong_usage", "rate 4 5", "help", nil, true},
+	{"history_correct_usage", "history 20", "history", []string{"20"}, false},
+	{"history_wrong_usage", "history lots", "help", nil, true},
+	{"history_wrong_usage", "history 0", "help", nil, true},
+	{"history_wrong_usage", "history 5 6", "help", nil, true},
+	{"notify_correct_usage", "notify via email", "notify", []string{"email"}, false},
+	{"notify_correct_usage", "notify via both", "notify", []string{"both"}, false},
+	{"notify_correct_usage", "notify via zulip", "notify", []string{"zulip"}, false},
+	{"notify_wrong_usage", "notify", "help", nil, true},
+	{"notify_wrong_usage", "notify email", "help", nil, true},
+	{"notify_wrong_usage", "notify via pigeon", "help", nil, true},
+	{"announce_correct_usage", "announce on", "announce", []string{"on"}, false},
+	{"announce_correct_usage", "announce off", "announce", []string{"off"}, false},
+	{"announce_wrong_usage", "announce", "help", nil, true},
+	{"announce_wrong_usage", "announce maybe", "help", nil, true},
+	{"digest_correct_usage", "digest off", "digest", []string{"off"}, false},
+	{"digest_correct_usage", "digest on", "digest", []string{"on"}, false},
+	{"digest_wrong_usage", "digest", "help", nil, true},
+	{"digest_wrong_usage", "digest weekly", "help", nil, true},
+	{"reminders_correct_usage", "reminders on", "reminders", []string{"on"}, false},
+	{"reminders_correct_usage", "reminders off", "reminders", []string{"off"}, false},
+	{"reminders_wrong_usage", "reminders", "help", nil, true},
+	{"reminders_wrong_usage", "reminders on please", "help", nil, true},
+	{"batch_correct_usage", "batch W1'24 2024-03-29", "batch", []string{"W1'24", "2024-03-29"}, false},
+	{"batch_wrong_usage", "batch W1'24", "help", nil, true},
+	{"batch_wrong_usage", "batch W1'24 march", "help", nil, true},
+	{"batch_wrong_usage", "batch W1'24 2024-02-30", "help", nil, true},
+	{"admin_list", "admin list", "admin", []string{"list"}, false},
+	{"admin_report", "admin report", "admin", []string{"report"}, false},
+	{"admin_endofbatch", "admin endofbatch", "admin", []string{"endofbatch"}, false},
+	{"admin_endofbatch_status", "admin endofbatch status", "admin", []string{"endofbatch", "status"}, false},
+	{"admin_endofbatch_cancel", "admin endofbatch cancel", "admin", []string{"endofbatch", "cancel"}, false},
+	{"admin_endofbatch_confirm", "admin endofbatch confirm 1a2b3c", "admin", []string{"endofbatch", "confirm", "1a2b3c"}, false},
+	{"admin_offboarded", "admin offboarded", "admin", []string{"offboarded"}, false},
+	{"admin_restore", "admin restore 215391", "admin", []string{"restore", "215391"}, false},
+	{"admin_restore_all", "admin restore all", "admin", []string{"restore", "all"}, false},
+	{"admin_outbox", "admin outbox", "admin", []string{"outbox"}, false},
+	{"admin_show", "admin show 215391", "admin", []string{"show", "215391"}, false},
+	{"admin_unsubscribe", "admin unsubscribe 215391", "admin", []string{"unsubscribe", "215391"}, false},
+	{"admin_match_now", "admin match now", "admin", []string{"match", "now"}, false},
+	{"admin_maintenance_on", "admin maintenance on", "admin", []string{"maintenance", "on", ""}, false},
+	{"admin_maintenance_on_message", "admin maintenance on Back at 5pm  UTC!", "admin", []string{"maintenance", "on", "Back at 5pm  UTC!"}, false},
+	{"admin_maintenance_off", "admin maintenance off", "admin", []string{"maintenance", "off"}, false},
+	{"admin_maintenance_status", "admin maintenance status", "admin", []string{"maintenance", "status"}, false},
+	{"admin_maintenance_allow", "admin maintenance allow 215391", "admin", []string{"maintenance", "allow", "215391"}, false},
+	{"admin_maintenance_disallow", "admin maintenance disallow 215391", "admin", []string{"maintenance", "disallow", "215391"}, false},
+	{"admin_announce_on", "admin announce on", "admin", []string{"announce", "on"}, false},
+	{"admin_announce_status", "admin announce status", "admin", []string{"announce", "status"}, false},
+	{"admin_announce_stream", "admin announce stream Pairing Bot", "admin", []string{"announce", "stream", "Pairing Bot"}, false},
+	{"admin_announce_template", "admin announce template {{.Pairs}} Pairs!", "admin", []string{"announce", "template", "{{.Pairs}} Pairs!"}, false},
+	{"admin_broadcast", "admin broadcast Hello, World!", "admin", []string{"broadcast", "Hello, World!"}, false},
+	{"admin_wrong_usage", "admin", "help", nil, true},
+	{"admin_wrong_usage", "admin list everyone", "help", nil, true},
+	{"admin_wrong_usage", "admin show", "help", nil, true},
+	{"admin_wrong_usage", "admin match later", "help", nil, true},
+	{"admin_wrong_usage", "admin maintenance maybe", "help", nil, true},
+	{"admin_wrong_usage", "admin maintenance allow", "help", nil, true},
+	{"admin_wrong_usage", "admin maintenance off now", "help", nil, true},
+	{"admin_wrong_usage", "admin endofbatch confirm", "help", nil, true},
+	{"admin_wrong_usage", "admin endofbatch now", "help", nil, true},
+	{"admin_wrong_usage", "admin restore", "help", nil, true},
+	{"admin_wrong_usage", "admin announce", "help", nil, true},
+	{"admin_wrong_usage", "admin announce topic", "help", nil, true},
+	{"admin_wrong_usage", "admin broadcast", "help", nil, true},
+	{"admin_wrong_usage", "admin reboot", "help", nil, true},
+	{"forget_me", "forget me", "forget", []string{}, false},
+	{"forget_me_confirm", "Forget me CONFIRM", "forget", []string{"confirm"}, false},
+	{"forget_someone_else", "forget ada", "help", nil, true},
+	{"forget_me_extra", "forget me confirm now", "help", nil, true},
+}
+
+func TestParseCmdWithArgs(t *testing.T) {
+	for _, tt := range tableWithArgs {
+		t.Run(tt.testName, func(t *testing.T) {
+			gotCmd, gotArgs, gotErr := ParseCmd(tt.inputStr)
+			if gotCmd != tt.wantedCmd || len(gotArgs) != len(tt.wantedArgs) {
+				t.Errorf("got %v, %v, wanted %v, %v\n", gotCmd, gotArgs, tt.wantedCmd, tt.wantedArgs)
+			}
+
+			switch gotCmd {
+			case "schedule":
+				for i, arg := range gotArgs {
+					if _, ok := daysList[arg]; !ok {
+						t.Errorf("Wrong argument %v for command %v\n", gotArgs[i], gotCmd)
+					}
+				}
+			case "skip":
+				if gotArgs[0] != "tomorrow" {
+					t.Errorf("Wrong argument %v for command %v\n", gotArgs[0], gotCmd)
+				}
+			case "unskip":
+				if gotArgs[0] != "tomorrow" {
+					t.Errorf("Wrong argument %v for command %v\n", gotArgs[0], gotCmd)
+				}
+			case "rate", "history", "notify", "announce", "digest", "reminders":
+				if gotArgs[0] != tt.wantedArgs[0] {
+					t.Errorf("Wrong argument %v for command %v\n", gotArgs[0], gotCmd)
+				}
+			case "batch":
+				if gotArgs[0] != tt.wantedArgs[0] || gotArgs[1] != tt.wantedArgs[1] {
+					t.Errorf("Wrong arguments %v for command %v\n", gotArgs, gotCmd)
+				}
+			case "admin", "forget":
+				for i, arg := range gotArgs {
+					if arg != tt.wantedArgs[i] {
+						t.Errorf("Wrong argument %v for command %v, wanted %v\n", arg, gotCmd, tt.wantedArgs[i])
+					}
+				}
+			default:
+				if gotCmd != "help" {
+					t.Errorf("unknown command %v\n", gotCmd)
+				}
+			}
+
+			_, ok := gotErr.(*parsingErr)
+
+			if tt.expectErr && !ok {
+				t.Errorf("Expected parsingErr but didn't get one\n")
+			} else if !tt.expectErr && ok {
+				t.Errorf("Got unexpected parsingError\n")
+			}
+		})
+	}
+}
+
+var tableMisc = []struct {
+	testName   string
+	inputStr   string
+	wantedCmd  string
+	wantedArgs []string
+	expectErr  bool
+}{
+	{"command_is_superstring", "scheduleing monday", "help", nil, true},
+	{"command_is_substring", "schedul monday", "help", nil, true},
+	{"command_is_undefined", "mooh", "help", nil, true},
+	{"command_is_capitalized", "Help", "help", nil, false},
+}
+
+func TestParseCmdMisc(t *testing.T) {
+	for _, tt := range tableMisc {
+		t.Run(tt.testName, func(t *testing.T) {
+			gotCmd, gotArgs, gotErr := ParseCmd(tt.inputStr)
+			if gotCmd != tt.wantedCmd {
+				t.Errorf("got %v, %v, wanted %v, %v\n", gotCmd, gotArgs, tt.wantedCmd, tt.wantedArgs)
+			}
+			_, ok := gotErr.(*parsingErr)
+
+			if tt.expectErr && !ok {
+				t.Errorf("Expected parsingErr but didn't get one\n")
+			} else if !tt.expectErr && ok {
+				t.Errorf("Got unexpected parsingError\n")
+			}
+		})
+	}
+}
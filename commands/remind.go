@@ -0,0 +1,66 @@
+package commands
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+)
+
+// people who've said `reminders on` get a message the evening before they're
+// going to be matched, while there's still time to `skip tomorrow`
+
+// ReminderMessage goes to people with reminders on, the evening before they're matched
+const ReminderMessage = "Hi! You're scheduled to pair tomorrow :pear:\n\nIf you can't make it, reply `skip tomorrow` before matches go out at 04:00 UTC.\n\n(Send `reminders off` if you don't want these reminders.)"
+
+// the hour (UTC) that the match cron job runs at, from cron.yaml
+const matchHourUTC = 4
+
+// ReminderSummary is what "remind" sends back as JSON
+type ReminderSummary struct {
+	Reminded      int                      `json:"reminded"`
+	Notifications chat.NotificationSummary `json:"notifications"`
+}
+
+// RunReminders sends ReminderMessage to everyone with reminders on who's
+// going to be matched at the next match run after now. it leaves out anyone
+// who's skipping; while matching is paused for maintenance, "remind" doesn't
+// call it at all
+func (pl *PairingLogic) RunReminders(ctx context.Context, now time.Time) (ReminderSummary, error) {
+	var summary ReminderSummary
+
+	// this leaves out anyone who's already skipping
+	recursersList, err := pl.RDB.ListPairingOn(ctx, NextMatchDay(now))
+	if err != nil {
+		return summary, err
+	}
+
+	var notifications []chat.Notification
+	for i, rec := range recursersList {
+		if !rec.Reminders {
+			continue
+		}
+		notifications = append(notifications, pl.notificationsTo(recursersList[i:i+1], ReminderMessage)...)
+		summary.Reminded++
+	}
+
+	botPassword, err := pl.ADB.GetKey(ctx, "apiauth", "key")
+	if err != nil {
+		log.Println("Something weird happened trying to read the auth token from the database")
+	}
+
+	summary.Notifications = chat.SendAll(ctx, pl.UN, botPassword, notifications, pl.NotifyWorkers)
+	return summary, nil
+}
+
+// NextMatchDay is the day (lowercase, like "monday") that the next match job
+// after now will match people for
+func NextMatchDay(now time.Time) string {
+	now = now.UTC()
+	if now.Hour() >= matchHourUTC {
+		now = now.AddDate(0, 0, 1)
+	}
+	return strings.ToLower(now.Weekday().String())
+}
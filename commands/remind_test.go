@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextMatchDay(t *testing.T) {
+	tests := []struct {
+		now    string
+		wanted string
+	}{
+		// Monday evening reminds people about Tuesday's matches
+		{"2024-01-01T20:00:00Z", "tuesday"},
+		// but just after midnight, Tuesday's matches haven't gone out yet
+		{"2024-01-02T01:00:00Z", "tuesday"},
+		{"2024-01-02T04:00:00Z", "wednesday"},
+		{"2024-01-07T23:59:00Z", "monday"},
+		// 20:00 in New York is already the next day in UTC
+		{"2024-01-01T20:00:00-05:00", "tuesday"},
+	}
+	for _, tt := range tests {
+		now, err := time.Parse(time.RFC3339, tt.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := NextMatchDay(now); got != tt.wanted {
+			t.Errorf("nextMatchDay(%v) = %v, wanted %v", tt.now, got, tt.wanted)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultPath is where the config is read from when neither -config nor
+// PB_CONFIG says otherwise. unlike a file that's asked for, it's fine for
+// it not to be there, as long as the environment has everything that's needed
+const DefaultPath = "config.yaml"
+
+// DefaultPort is what the bot listens on when nothing sets a port
+const DefaultPort = "8080"
+
+// Config is everything about how pairing bot runs that isn't a secret.
+// secrets stay in Firestore
+type Config struct {
+	// the Google Cloud project Firestore is in
+	Project string `yaml:"project"`
+	Port    string `yaml:"port"`
+	// pauses everything, like `admin maintenance on`, but without needing the database
+	Maintenance bool  `yaml:"maintenance"`
+	Zulip       Zulip `yaml:"zulip"`
+	Owner       Owner `yaml:"owner"`
+	// zulip IDs, or prefixed ones like slack:U024BE7LH, of admins other than the owner
+	Admins    []string  `yaml:"admins"`
+	Slack     Slack     `yaml:"slack"`
+	Matrix    Matrix    `yaml:"matrix"`
+	Discord   Discord   `yaml:"discord"`
+	SMTP      SMTP      `yaml:"smtp"`
+	Directory Directory `yaml:"directory"`
+	// how long offboarded people can be restored for. 0 means the default
+	OffboardRetentionDays int `yaml:"offboard_retention_days"`
+}
+
+// Zulip is the one platform that's always on
+type Zulip struct {
+	// where to send messages, like https://recurse.zulipchat.com/api/v1/messages
+	APIURL      string `yaml:"api_url"`
+	BotUsername string `yaml:"bot_username"`
+}
+
+// Owner is who people are told to ping when something goes wrong. they're
+// always an admin
+type Owner struct {
+	// the "id" field from zulip, a permanent user ID that's not secret
+	ID string `yaml:"id"`
+	// how to mention them in a message, like @_**Maren Beam (SP2'19)**
+	Mention string `yaml:"mention"`
+}
+
+// Slack is the Slack app. its secrets are in slackauth
+type Slack struct {
+	Enabled bool `yaml:"enabled"`
+	// empty means Slack's own API
+	APIURL string `yaml:"api_url"`
+}
+
+// Matrix is the Matrix application service. its tokens are in matrixauth
+type Matrix struct {
+	// empty turns matrix off
+	Homeserver string `yaml:"homeserver"`
+	// the bot's user ID, like @pairing-bot:example.org
+	User string `yaml:"user"`
+}
+
+// Discord is the Discord app. its secrets are in discordauth
+type Discord struct {
+	// empty turns discord off
+	AppID string `yaml:"app_id"`
+	// where match threads get started
+	Channel string `yaml:"channel"`
+}
+
+// SMTP is how email is sent
+type SMTP struct {
+	// host:port. empty turns email off
+	Addr string `yaml:"addr"`
+	From string `yaml:"from"`
+	// if the server wants us to log in. the password is in smtpauth/password
+	Username string `yaml:"username"`
+}
+
+// Directory is where to look people up. at most one of these can be set
+type Directory struct {
+	// a Recurse-style profiles API
+	URL string `yaml:"url"`
+	// a JSON file of profiles
+	File string `yaml:"file"`
+}
+
+// envOverrides are the environment variables that win over the file.
+// empty ones are ignored, so app.yaml can list them all without setting them
+var envOverrides = []struct {
+	name  string
+	field string
+	set   func(c *Config, v string) error
+}{
+	{"PB_PROJECT", "project", func(c *Config, v string) error { c.Project = v; return nil }},
+	{"PORT", "port", func(c *Config, v string) error { c.Port = v; return nil }},
+	{"PB_MAINT", "maintenance", func(c *Config, v string) error { return setBool(&c.Maintenance, v) }},
+	{"PB_ZULIP_API_URL", "zulip.api_url", func(c *Config, v string) error { c.Zulip.APIURL = v; return nil }},
+	{"PB_BOT_USERNAME", "zulip.bot_username", func(c *Config, v string) error { c.Zulip.BotUsername = v; return nil }},
+	{"PB_OWNER_ID", "owner.id", func(c *Config, v string) error { c.Owner.ID = v; return nil }},
+	{"PB_OWNER_MENTION", "owner.mention", func(c *Config, v string) error { c.Owner.Mention = v; return nil }},
+	{"PB_ADMINS", "admins", func(c *Config, v string) error { c.Admins = splitList(v); return nil }},
+	{"PB_SLACK", "slack.enabled", func(c *Config, v string) error { return setBool(&c.Slack.Enabled, v) }},
+	{"PB_SLACK_API_URL", "slack.api_url", func(c *Config, v string) error { c.Slack.APIURL = v; return nil }},
+	{"PB_MATRIX_HOMESERVER", "matrix.homeserver", func(c *Config, v string) error { c.Matrix.Homeserver = v; return nil }},
+	{"PB_MATRIX_USER", "matrix.user", func(c *Config, v string) error { c.Matrix.User = v; return nil }},
+	{"PB_DISCORD_APP_ID", "discord.app_id", func(c *Config, v string) error { c.Discord.AppID = v; return nil }},
+	{"PB_DISCORD_CHANNEL", "discord.channel", func(c *Config, v string) error { c.Discord.Channel = v; return nil }},
+	{"PB_SMTP_ADDR", "smtp.addr", func(c *Config, v string) error { c.SMTP.Addr = v; return nil }},
+	{"PB_SMTP_FROM", "smtp.from", func(c *Config, v string) error { c.SMTP.From = v; return nil }},
+	{"PB_SMTP_USERNAME", "smtp.username", func(c *Config, v string) error { c.SMTP.Username = v; return nil }},
+	{"PB_DIRECTORY_URL", "directory.url", func(c *Config, v string) error { c.Directory.URL = v; return nil }},
+	{"PB_DIRECTORY_FILE", "directory.file", func(c *Config, v string) error { c.Directory.File = v; return nil }},
+	{"PB_OFFBOARD_RETENTION_DAYS", "offboard_retention_days", func(c *Config, v string) error {
+		days, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.New("should be a number of days")
+		}
+		c.OffboardRetentionDays = days
+		return nil
+	}},
+}
+
+// Load reads the config file at path, or PB_CONFIG, or DefaultPath, and then
+// the environment variables from lookupEnv (which is os.LookupEnv outside of
+// tests). the error lists everything that's wrong, not just the first thing
+func Load(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	mustExist := true
+	if path == "" {
+		path, _ = lookupEnv("PB_CONFIG")
+	}
+	if path == "" {
+		path = DefaultPath
+		mustExist = false
+	}
+
+	c := &Config{}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		err = yaml.UnmarshalStrict(data, c)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read the config in %v: %w", path, err)
+		}
+	case os.IsNotExist(err) && !mustExist:
+		// everything has to come from the environment, then
+	default:
+		return nil, fmt.Errorf("couldn't read the config: %w", err)
+	}
+
+	var problems []string
+	for _, o := range envOverrides {
+		v, ok := lookupEnv(o.name)
+		if !ok || v == "" {
+			continue
+		}
+		err := o.set(c, v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%v (%v=%q) %v", o.field, o.name, v, err))
+		}
+	}
+	if c.Port == "" {
+		c.Port = DefaultPort
+	}
+
+	problems = append(problems, c.problems()...)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("the config isn't right:\n  %v", strings.Join(problems, "\n  "))
+	}
+	return c, nil
+}
+
+// problems is everything that's missing or doesn't make sense
+func (c *Config) problems() []string {
+	var problems []string
+	missing := func(field, value string) {
+		if value == "" {
+			problems = append(problems, fmt.Sprintf("%v is missing: set it in the config file or with %v", field, envName(field)))
+		}
+	}
+	missing("project", c.Project)
+	missing("zulip.api_url", c.Zulip.APIURL)
+	missing("zulip.bot_username", c.Zulip.BotUsername)
+	missing("owner.id", c.Owner.ID)
+	missing("owner.mention", c.Owner.Mention)
+
+	badURL := func(field, value string) {
+		if value == "" {
+			return
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%v should be an http or https URL, not %q", field, value))
+		}
+	}
+	badURL("zulip.api_url", c.Zulip.APIURL)
+	badURL("slack.api_url", c.Slack.APIURL)
+	badURL("matrix.homeserver", c.Matrix.Homeserver)
+	badURL("directory.url", c.Directory.URL)
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("port should be a number from 1 to 65535, not %q", c.Port))
+	}
+	for _, id := range c.Admins {
+		if strings.TrimSpace(id) == "" {
+			problems = append(problems, "admins has an empty ID in it")
+			break
+		}
+	}
+	if c.OffboardRetentionDays < 0 {
+		problems = append(problems, fmt.Sprintf("offboard_retention_days shouldn't be negative, not %v", c.OffboardRetentionDays))
+	}
+
+	// the platforms that are turned on need everything they use
+	if c.Matrix.Homeserver != "" {
+		missing("matrix.user", c.Matrix.User)
+	}
+	if c.Discord.AppID != "" {
+		missing("discord.channel", c.Discord.Channel)
+	}
+	if c.SMTP.Addr != "" {
+		missing("smtp.from", c.SMTP.From)
+	}
+	if c.Directory.URL != "" && c.Directory.File != "" {
+		problems = append(problems, "only one of directory.url and directory.file can be set")
+	}
+	return problems
+}
+
+// AllAdmins is the owner and everyone in Admins
+func (c *Config) AllAdmins() []string {
+	return append([]string{c.Owner.ID}, c.Admins...)
+}
+
+// String is the config as YAML, for --check-config
+func (c *Config) String() string {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("%+v", *c)
+	}
+	return string(out)
+}
+
+func envName(field string) string {
+	for _, o := range envOverrides {
+		if o.field == field {
+			return o.name
+		}
+	}
+	return ""
+}
+
+func setBool(b *bool, v string) error {
+	parsed, err := strconv.ParseBool(v)
+	if err != nil {
+		return errors.New("should be true or false")
+	}
+	*b = parsed
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `project: test-project
+zulip:
+  api_url: https://zulip.example.com/api/v1/messages
+  bot_username: bot@zulip.example.com
+owner:
+  id: "215391"
+  mention: "@_**Ada**"
+admins: ["7"]
+`
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, testConfig)
+
+	tests := []struct {
+		testName string
+		file     string
+		env      map[string]string
+		check    func(c *Config) bool
+		// every one of these has to be in the error
+		wantErrs []string
+	}{
+		{"file", testConfig, nil, func(c *Config) bool {
+			return c.Project == "test-project" && c.Port == DefaultPort && c.Owner.Mention == "@_**Ada**" &&
+				strings.Join(c.AllAdmins(), ",") == "215391,7"
+		}, nil},
+		{"env_wins", testConfig, map[string]string{"PB_PROJECT": "other", "PORT": "9000", "PB_MAINT": "true", "PB_ADMINS": "1, slack:U1,"}, func(c *Config) bool {
+			return c.Project == "other" && c.Port == "9000" && c.Maintenance && strings.Join(c.Admins, ",") == "1,slack:U1"
+		}, nil},
+		{"empty_env_ignored", testConfig, map[string]string{"PB_PROJECT": "", "PB_MAINT": ""}, func(c *Config) bool {
+			return c.Project == "test-project" && !c.Maintenance
+		}, nil},
+		{"missing", "", nil, nil, []string{"project is missing", "PB_OWNER_ID", "owner.mention is missing"}},
+		{"bad_values", testConfig, map[string]string{"PB_MAINT": "maybe", "PORT": "99999", "PB_ZULIP_API_URL": "zulip", "PB_OFFBOARD_RETENTION_DAYS": "a month"},
+			nil, []string{"PB_MAINT", "port should be", "zulip.api_url should be", "offboard_retention_days"}},
+		{"platforms_need_everything", testConfig, map[string]string{"PB_MATRIX_HOMESERVER": "https://matrix.example.org", "PB_DISCORD_APP_ID": "1", "PB_SMTP_ADDR": "smtp.example.com:587"},
+			nil, []string{"matrix.user is missing", "discord.channel is missing", "smtp.from is missing"}},
+		{"two_directories", testConfig, map[string]string{"PB_DIRECTORY_URL": "https://example.com", "PB_DIRECTORY_FILE": "profiles.json"},
+			nil, []string{"only one of directory.url and directory.file"}},
+		{"unknown_field", testConfig + "colour: blue\n", nil, nil, []string{"colour"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := ioutil.WriteFile(path, []byte(tt.file), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := Load(path, env(tt.env))
+			if tt.wantErrs == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !tt.check(c) {
+					t.Errorf("got %+v", c)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("got %+v, wanted an error", c)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error doesn't mention %q: %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPath(t *testing.T) {
+	path := writeConfig(t, testConfig)
+
+	// PB_CONFIG is used when there's no -config
+	c, err := Load("", env(map[string]string{"PB_CONFIG": path}))
+	if err != nil || c.Project != "test-project" {
+		t.Errorf("got %+v, %v", c, err)
+	}
+
+	// a file that was asked for has to be there
+	_, err = Load(path+".missing", env(nil))
+	if err == nil {
+		t.Error("a missing file wasn't an error")
+	}
+}
@@ -0,0 +1,3 @@
+// Package config reads pairing bot's settings: a YAML file, with environment
+// variables on top, all checked before anything starts.
+package config
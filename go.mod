@@ -4,6 +4,8 @@ go 1.15
 
 require (
 	cloud.google.com/go/firestore v1.5.0
+	github.com/prometheus/client_golang v1.9.0
 	google.golang.org/api v0.40.0
 	google.golang.org/grpc v1.36.0
+	gopkg.in/yaml.v2 v2.4.0
 )
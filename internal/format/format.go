@@ -0,0 +1,22 @@
+// Package format is how the bot and pairingbotctl show things like dates to
+// people, so that the two say them the same way. It's internal because
+// nobody else should depend on exactly how that looks.
+package format
+
+import "time"
+
+// YesNo is "yes" or "no"
+func YesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// Date is a day like "Mon Jan 2, 2006", or "never" for the zero time
+func Date(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("Mon Jan 2, 2006")
+}
@@ -0,0 +1,3 @@
+// Package matching decides who pairs with whom on a given day, without
+// recording or sending anything, and can simulate weeks of matching.
+package matching
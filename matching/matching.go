@@ -0,0 +1,125 @@
+package matching
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// MatchPlan is who gets paired on a day, and who sits out
+type MatchPlan struct {
+	Pairs [][2]storage.Recurser
+	// one per chat platform at most
+	OddOneOuts []storage.Recurser
+}
+
+// PlanMatches shuffles recursersList with rnd and pairs everyone up with
+// someone on the same chat platform, steering clear of the pairs in avoid.
+// it doesn't change or send anything, so it's also what dry runs and
+// simulations use
+func PlanMatches(recursersList []storage.Recurser, avoid map[string]bool, rnd *rand.Rand) MatchPlan {
+	var plan MatchPlan
+
+	// shuffle our recursers. This will not error if the list is empty
+	rnd.Shuffle(len(recursersList), func(i, j int) { recursersList[i], recursersList[j] = recursersList[j], recursersList[i] })
+
+	// people can only be paired with someone on the same chat platform
+	for _, group := range byPlatform(recursersList) {
+
+		// if there's an odd number today, pick whoever was left out least
+		// recently and knock them off the list
+		if len(group)%2 != 0 {
+			var recurser storage.Recurser
+			recurser, group = pickOddOneOut(group)
+			plan.OddOneOuts = append(plan.OddOneOuts, recurser)
+		}
+
+		plan.Pairs = append(plan.Pairs, pairUp(group, avoid)...)
+	}
+	return plan
+}
+
+// pickOddOneOut chooses who sits out when there's an odd number of people in
+// today's match-set. It picks whoever was left out least recently (people
+// who've never been left out count as the least recent of all), so the same
+// person can't end up alone several days running.
+//
+// recursersList should already be shuffled: ties are broken by list order,
+// which keeps the choice random among people with the same history.
+// It returns the odd one out and everyone else, in their original order.
+func pickOddOneOut(recursersList []storage.Recurser) (storage.Recurser, []storage.Recurser) {
+	pick := 0
+	for i, r := range recursersList {
+		if r.LastOddOneOut.Before(recursersList[pick].LastOddOneOut) {
+			pick = i
+		}
+	}
+
+	rest := make([]storage.Recurser, 0, len(recursersList)-1)
+	rest = append(rest, recursersList[:pick]...)
+	rest = append(rest, recursersList[pick+1:]...)
+	return recursersList[pick], rest
+}
+
+// pairUp splits an even-length list of recursers into pairs, going down the
+// list in order. If someone's next-in-line partner is one they should avoid,
+// it looks further down the list for someone else. When there's nobody else
+// left, the pair happens anyway -- better an awkward pair than no pair.
+func pairUp(recursersList []storage.Recurser, avoid map[string]bool) [][2]storage.Recurser {
+	var pairs [][2]storage.Recurser
+	for i := 0; i+1 < len(recursersList); i += 2 {
+		for j := i + 1; j < len(recursersList); j++ {
+			if !avoid[pairKey(recursersList[i].ID, recursersList[j].ID)] {
+				recursersList[i+1], recursersList[j] = recursersList[j], recursersList[i+1]
+				break
+			}
+		}
+		pairs = append(pairs, [2]storage.Recurser{recursersList[i], recursersList[i+1]})
+	}
+	return pairs
+}
+
+// pairKey makes the same key for two recursers no matter which order they're in
+func pairKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + ":" + b
+}
+
+// NoShowPairs finds the pairs where both people told us their session
+// didn't happen. We don't match them with each other again.
+func NoShowPairs(matches []storage.Match) map[string]bool {
+	avoid := map[string]bool{}
+	for _, m := range matches {
+		first, ok1 := m.CheckIns[m.IDs[0]]
+		second, ok2 := m.CheckIns[m.IDs[1]]
+		if ok1 && ok2 && !first.Happened && !second.Happened {
+			avoid[pairKey(m.IDs[0], m.IDs[1])] = true
+		}
+	}
+	return avoid
+}
+
+// byPlatform splits recursers up by the chat platform they're on, since we
+// can only pair people who can message each other. The groups come back in
+// a fixed order, with each group in the same order it was in before
+func byPlatform(recursersList []storage.Recurser) [][]storage.Recurser {
+	groups := map[string][]storage.Recurser{}
+	var names []string
+	for _, r := range recursersList {
+		p := storage.PlatformOf(r.ID)
+		if _, ok := groups[p]; !ok {
+			names = append(names, p)
+		}
+		groups[p] = append(groups[p], r)
+	}
+	sort.Strings(names)
+
+	var grouped [][]storage.Recurser
+	for _, p := range names {
+		grouped = append(grouped, groups[p])
+	}
+	return grouped
+}
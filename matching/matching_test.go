@@ -0,0 +1,148 @@
+package matching
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+func TestPickOddOneOutPrefersLeastRecent(t *testing.T) {
+	day := time.Date(2020, time.March, 2, 4, 0, 0, 0, time.UTC)
+	recursersList := []storage.Recurser{
+		{ID: "1", LastOddOneOut: day.AddDate(0, 0, -1)},
+		{ID: "2", LastOddOneOut: day.AddDate(0, 0, -7)},
+		{ID: "3", LastOddOneOut: day.AddDate(0, 0, -3)},
+	}
+
+	got, rest := pickOddOneOut(recursersList)
+	if got.ID != "2" {
+		t.Errorf("got %v, wanted the person left out longest ago (2)\n", got.ID)
+	}
+	if len(rest) != 2 || rest[0].ID != "1" || rest[1].ID != "3" {
+		t.Errorf("got rest %v, wanted 1 and 3 in their original order\n", rest)
+	}
+
+	// never having been left out beats any date
+	recursersList = append(recursersList, storage.Recurser{ID: "4"})
+	got, _ = pickOddOneOut(recursersList)
+	if got.ID != "4" {
+		t.Errorf("got %v, wanted the person who's never been left out (4)\n", got.ID)
+	}
+}
+
+// simulate 60 days of matching with an odd-sized match-set every day,
+// and check that being the odd one out gets spread around evenly
+func TestPickOddOneOutFairness(t *testing.T) {
+	const days = 60
+	rng := rand.New(rand.NewSource(1))
+
+	for _, poolSize := range []int{3, 5, 7, 11, 15} {
+		t.Run("pool_of_"+strconv.Itoa(poolSize), func(t *testing.T) {
+			pool := make([]storage.Recurser, poolSize)
+			for i := range pool {
+				pool[i] = storage.Recurser{ID: strconv.Itoa(i)}
+			}
+			counts := map[string]int{}
+
+			day := time.Date(2020, time.March, 2, 4, 0, 0, 0, time.UTC)
+			for i := 0; i < days; i++ {
+				rng.Shuffle(len(pool), func(a, b int) { pool[a], pool[b] = pool[b], pool[a] })
+
+				oddOneOut, rest := pickOddOneOut(pool)
+				if len(rest) != poolSize-1 {
+					t.Fatalf("got %d people left to match, wanted %d\n", len(rest), poolSize-1)
+				}
+				counts[oddOneOut.ID]++
+
+				for j := range pool {
+					if pool[j].ID == oddOneOut.ID {
+						pool[j].LastOddOneOut = day
+					}
+				}
+				day = day.AddDate(0, 0, 1)
+			}
+
+			average := float64(days) / float64(poolSize)
+			for id, n := range counts {
+				if float64(n) > average+1 {
+					t.Errorf("recurser %v was left out %d times, average is %.1f\n", id, n, average)
+				}
+			}
+		})
+	}
+}
+
+// same thing, but people come and go: every day a random odd-sized
+// subset of the subscribers is in the match-set
+func TestPickOddOneOutFairnessVaryingAttendance(t *testing.T) {
+	const days = 60
+	const subscribers = 9
+	rng := rand.New(rand.NewSource(1))
+
+	everyone := make([]storage.Recurser, subscribers)
+	for i := range everyone {
+		everyone[i] = storage.Recurser{ID: strconv.Itoa(i)}
+	}
+	counts := map[string]int{}
+	attended := map[string]int{}
+
+	day := time.Date(2020, time.March, 2, 4, 0, 0, 0, time.UTC)
+	for i := 0; i < days; i++ {
+		rng.Shuffle(len(everyone), func(a, b int) { everyone[a], everyone[b] = everyone[b], everyone[a] })
+		size := 2*rng.Intn(subscribers/2) + 3 // 3, 5, 7 or 9
+		matchSet := everyone[:size]
+		for _, r := range matchSet {
+			attended[r.ID]++
+		}
+
+		oddOneOut, _ := pickOddOneOut(matchSet)
+		counts[oddOneOut.ID]++
+		for j := range everyone {
+			if everyone[j].ID == oddOneOut.ID {
+				everyone[j].LastOddOneOut = day
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+
+	average := float64(days) / float64(subscribers)
+	for id, n := range counts {
+		if float64(n) > 2*average {
+			t.Errorf("recurser %v was left out %d times (in the match-set %d times), average is %.1f\n", id, n, attended[id], average)
+		}
+	}
+}
+
+func TestPairUpAvoidsNoShows(t *testing.T) {
+	recursersList := []storage.Recurser{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}}
+	matches := []storage.Match{
+		// both said no: don't pair 1 and 2 again
+		{IDs: []string{"1", "2"}, CheckIns: map[string]storage.CheckIn{"1": {Happened: false}, "2": {Happened: false}}},
+		// only one said no: that's fine to pair again
+		{IDs: []string{"3", "4"}, CheckIns: map[string]storage.CheckIn{"3": {Happened: false}, "4": {Happened: true, Rating: 2}}},
+	}
+
+	avoid := NoShowPairs(matches)
+	if len(avoid) != 1 || !avoid[pairKey("2", "1")] {
+		t.Fatalf("got %v, wanted only 1 and 2 to be avoided\n", avoid)
+	}
+
+	pairs := pairUp(recursersList, avoid)
+	if len(pairs) != 2 {
+		t.Fatalf("got %d pairs, wanted 2\n", len(pairs))
+	}
+	for _, p := range pairs {
+		if pairKey(p[0].ID, p[1].ID) == pairKey("1", "2") {
+			t.Errorf("1 and 2 were paired again\n")
+		}
+	}
+
+	// when there's no way around it, they get paired anyway
+	pairs = pairUp([]storage.Recurser{{ID: "1"}, {ID: "2"}}, avoid)
+	if len(pairs) != 1 {
+		t.Errorf("got %d pairs, wanted 1\n", len(pairs))
+	}
+}
@@ -0,0 +1,115 @@
+package matching
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// PlanDay is what matching would do on day: everyone whose schedule has that
+// day, minus anyone skipping tomorrow, avoiding recent no-show pairs
+func PlanDay(ctx context.Context, rdb storage.RecurserDB, mdb storage.MatchDB, day time.Time, rnd *rand.Rand) (MatchPlan, error) {
+	recursersList, err := rdb.ListPairingOn(ctx, strings.ToLower(day.Weekday().String()))
+	if err != nil {
+		return MatchPlan{}, err
+	}
+	recentMatches, err := mdb.ListSince(ctx, day.AddDate(0, 0, -28))
+	if err != nil {
+		return MatchPlan{}, err
+	}
+	return PlanMatches(recursersList, NoShowPairs(recentMatches), rnd), nil
+}
+
+// SimulatedRecurser is how one person does over a simulation
+type SimulatedRecurser struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Matches    int    `json:"matches"`
+	Partners   int    `json:"partners"`
+	OddOneOuts int    `json:"oddOneOuts"`
+	// matches with someone they'd already had in the simulation
+	Repeats int `json:"repeats"`
+}
+
+// Simulation is the result of SimulateMatching, totalled over every day
+type Simulation struct {
+	From       string              `json:"from"`
+	Days       int                 `json:"days"`
+	Pairs      int                 `json:"pairs"`
+	OddOneOuts int                 `json:"oddOneOuts"`
+	Recursers  []SimulatedRecurser `json:"recursers"`
+}
+
+// SimulateMatching matches everyone for days days from from, on a copy of
+// what's in rdb and mdb so that nothing real changes. odd ones out are
+// remembered like they would be, and everyone's schedule is followed, but
+// nobody skips or checks in
+func SimulateMatching(ctx context.Context, rdb storage.RecurserDB, mdb storage.MatchDB, from time.Time, days int, rnd *rand.Rand) (Simulation, error) {
+	sim := Simulation{From: from.Format(storage.BatchDateLayout), Days: days, Recursers: []SimulatedRecurser{}}
+
+	recursersList, err := rdb.GetAllUsers(ctx)
+	if err != nil {
+		return sim, err
+	}
+	matches, err := mdb.ListSince(ctx, from.AddDate(0, 0, -28))
+	if err != nil {
+		return sim, err
+	}
+	srdb, smdb := storage.NewMemoryRecurserDB(), storage.NewMemoryMatchDB()
+	for _, r := range recursersList {
+		r.IsSkippingTomorrow = false
+		srdb.Set(ctx, r.ID, r)
+	}
+	for _, m := range matches {
+		smdb.Add(ctx, m)
+	}
+
+	stats := map[string]*SimulatedRecurser{}
+	partners := map[string]map[string]bool{}
+	for _, r := range recursersList {
+		stats[r.ID] = &SimulatedRecurser{ID: r.ID, Name: r.Name}
+		partners[r.ID] = map[string]bool{}
+	}
+
+	for i := 0; i < days; i++ {
+		day := from.AddDate(0, 0, i)
+		plan, err := PlanDay(ctx, srdb, smdb, day, rnd)
+		if err != nil {
+			return sim, err
+		}
+		for _, r := range plan.OddOneOuts {
+			srdb.RecordOddOneOut(ctx, r, day)
+			stats[r.ID].OddOneOuts++
+		}
+		for _, p := range plan.Pairs {
+			smdb.Add(ctx, storage.Match{
+				Date:   day,
+				IDs:    []string{p[0].ID, p[1].ID},
+				Names:  []string{p[0].Name, p[1].Name},
+				Emails: []string{p[0].Address(), p[1].Address()},
+			})
+			for _, pair := range [][2]string{{p[0].ID, p[1].ID}, {p[1].ID, p[0].ID}} {
+				s := stats[pair[0]]
+				s.Matches++
+				if partners[pair[0]][pair[1]] {
+					s.Repeats++
+				}
+				partners[pair[0]][pair[1]] = true
+			}
+		}
+		sim.Pairs += len(plan.Pairs)
+		sim.OddOneOuts += len(plan.OddOneOuts)
+	}
+
+	for _, r := range recursersList {
+		s := stats[r.ID]
+		s.Partners = len(partners[r.ID])
+		sim.Recursers = append(sim.Recursers, *s)
+	}
+	sort.Slice(sim.Recursers, func(i, j int) bool { return sim.Recursers[i].ID < sim.Recursers[j].ID })
+	return sim, nil
+}
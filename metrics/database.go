@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const (
+	read  = "read"
+	write = "write"
+)
+
+// InstrumentedRecurserDB times every call to RDB, and counts the ones that fail
+// implements storage.RecurserDB
+type InstrumentedRecurserDB struct {
+	RDB storage.RecurserDB
+}
+
+func (i *InstrumentedRecurserDB) GetByUserID(ctx context.Context, userID, userEmail, userName string) (r storage.Recurser, err error) {
+	defer observeDB("recursers", "GetByUserID", read, time.Now(), &err)
+	return i.RDB.GetByUserID(ctx, userID, userEmail, userName)
+}
+
+func (i *InstrumentedRecurserDB) GetAllUsers(ctx context.Context) (rs []storage.Recurser, err error) {
+	defer observeDB("recursers", "GetAllUsers", read, time.Now(), &err)
+	return i.RDB.GetAllUsers(ctx)
+}
+
+func (i *InstrumentedRecurserDB) Set(ctx context.Context, userID string, recurser storage.Recurser) (err error) {
+	defer observeDB("recursers", "Set", write, time.Now(), &err)
+	return i.RDB.Set(ctx, userID, recurser)
+}
+
+func (i *InstrumentedRecurserDB) Delete(ctx context.Context, userID string) (err error) {
+	defer observeDB("recursers", "Delete", write, time.Now(), &err)
+	return i.RDB.Delete(ctx, userID)
+}
+
+func (i *InstrumentedRecurserDB) ListPairingTomorrow(ctx context.Context) (rs []storage.Recurser, err error) {
+	defer observeDB("recursers", "ListPairingTomorrow", read, time.Now(), &err)
+	return i.RDB.ListPairingTomorrow(ctx)
+}
+
+func (i *InstrumentedRecurserDB) ListPairingOn(ctx context.Context, day string) (rs []storage.Recurser, err error) {
+	defer observeDB("recursers", "ListPairingOn", read, time.Now(), &err)
+	return i.RDB.ListPairingOn(ctx, day)
+}
+
+func (i *InstrumentedRecurserDB) ListSkippingTomorrow(ctx context.Context) (rs []storage.Recurser, err error) {
+	defer observeDB("recursers", "ListSkippingTomorrow", read, time.Now(), &err)
+	return i.RDB.ListSkippingTomorrow(ctx)
+}
+
+func (i *InstrumentedRecurserDB) UnsetSkippingTomorrow(ctx context.Context, recurser storage.Recurser) (err error) {
+	defer observeDB("recursers", "UnsetSkippingTomorrow", write, time.Now(), &err)
+	return i.RDB.UnsetSkippingTomorrow(ctx, recurser)
+}
+
+func (i *InstrumentedRecurserDB) RecordOddOneOut(ctx context.Context, recurser storage.Recurser, when time.Time) (err error) {
+	defer observeDB("recursers", "RecordOddOneOut", write, time.Now(), &err)
+	return i.RDB.RecordOddOneOut(ctx, recurser, when)
+}
+
+func (i *InstrumentedRecurserDB) Offboard(ctx context.Context, userID, reason string, when time.Time) (err error) {
+	defer observeDB("recursers", "Offboard", write, time.Now(), &err)
+	return i.RDB.Offboard(ctx, userID, reason, when)
+}
+
+func (i *InstrumentedRecurserDB) ListOffboarded(ctx context.Context) (os []storage.OffboardedRecurser, err error) {
+	defer observeDB("recursers", "ListOffboarded", read, time.Now(), &err)
+	return i.RDB.ListOffboarded(ctx)
+}
+
+func (i *InstrumentedRecurserDB) Restore(ctx context.Context, userID string) (r storage.Recurser, err error) {
+	start := time.Now()
+	r, err = i.RDB.Restore(ctx, userID)
+	// someone subscribing again isn't the database's fault
+	dbErr := err
+	if errors.Is(err, storage.ErrStillSubscribed) {
+		dbErr = nil
+	}
+	observeDB("recursers", "Restore", write, start, &dbErr)
+	return r, err
+}
+
+func (i *InstrumentedRecurserDB) PurgeOffboarded(ctx context.Context, before time.Time) (n int, err error) {
+	defer observeDB("recursers", "PurgeOffboarded", write, time.Now(), &err)
+	return i.RDB.PurgeOffboarded(ctx, before)
+}
+
+func (i *InstrumentedRecurserDB) Forget(ctx context.Context, userID string) (err error) {
+	defer observeDB("recursers", "Forget", write, time.Now(), &err)
+	return i.RDB.Forget(ctx, userID)
+}
+
+func (i *InstrumentedRecurserDB) Migrate(ctx context.Context, dryRun bool) (n int, err error) {
+	defer observeDB("recursers", "Migrate", write, time.Now(), &err)
+	return i.RDB.Migrate(ctx, dryRun)
+}
+
+// InstrumentedAPIAuthDB times every call to ADB, and counts the ones that fail
+// implements storage.APIAuthDB
+type InstrumentedAPIAuthDB struct {
+	ADB storage.APIAuthDB
+}
+
+func (i *InstrumentedAPIAuthDB) GetKey(ctx context.Context, col, doc string) (key string, err error) {
+	defer observeDB(col, "GetKey", read, time.Now(), &err)
+	return i.ADB.GetKey(ctx, col, doc)
+}
@@ -0,0 +1,4 @@
+// Package metrics is what /metrics shows Prometheus: webhooks, parse errors,
+// database and send latency, match runs and subscriber counts. the
+// Instrumented types wrap the storage and chat interfaces to measure them
+package metrics
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry has every metric in here, plus the usual Go and process ones
+var Registry = prometheus.NewRegistry()
+
+// webhook outcomes. commands answer with ok, error or maintenance, and
+// requests that never get as far as a command are rejected or ignored
+const (
+	OutcomeOK          = "ok"
+	OutcomeError       = "error"
+	OutcomeMaintenance = "maintenance"
+	OutcomeRejected    = "rejected"
+	OutcomeIgnored     = "ignored"
+)
+
+var (
+	webhooks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pairing_bot_webhooks_total",
+		Help: "Commands (and other requests) from the chat platforms, by platform, command and outcome.",
+	}, []string{"platform", "command", "outcome"})
+
+	parseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pairing_bot_parse_errors_total",
+		Help: "Messages that weren't a command we know, by platform.",
+	}, []string{"platform"})
+
+	dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pairing_bot_db_duration_seconds",
+		Help:    "How long database calls take, by collection, operation and whether it's a read or a write.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"db", "op", "kind"})
+
+	dbErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pairing_bot_db_errors_total",
+		Help: "Database calls that failed, by collection, operation and whether it's a read or a write.",
+	}, []string{"db", "op", "kind"})
+
+	sendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pairing_bot_send_duration_seconds",
+		Help:    "How long sending someone a message takes, retries included, by platform.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"platform"})
+
+	sendFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pairing_bot_send_failures_total",
+		Help: "Messages that couldn't be sent even after retrying, by platform.",
+	}, []string{"platform"})
+
+	matchPairs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pairing_bot_match_pairs",
+		Help:    "How many pairs each match run made.",
+		Buckets: []float64{0, 1, 2, 5, 10, 20, 50, 100},
+	})
+
+	oddOneOuts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pairing_bot_odd_one_outs_total",
+		Help: "People who were left without a partner in a match run.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		webhooks, parseErrors, dbDuration, dbErrors, sendDuration, sendFailures, matchPairs, oddOneOuts,
+	)
+}
+
+// Handler serves /metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Webhook counts a request from a chat platform. command is empty when it
+// didn't get as far as having one
+func Webhook(platform, command, outcome string) {
+	webhooks.WithLabelValues(platform, command, outcome).Inc()
+}
+
+// ParseError counts a message that wasn't a command
+func ParseError(platform string) {
+	parseErrors.WithLabelValues(platform).Inc()
+}
+
+// MatchRun records how a match run went
+func MatchRun(pairs, oddOnes int) {
+	matchPairs.Observe(float64(pairs))
+	oddOneOuts.Add(float64(oddOnes))
+}
+
+// observeDB is deferred by the database wrappers, so it sees the error they
+// return: defer observeDB("recursers", "Set", write, time.Now(), &err)
+func observeDB(db, op, kind string, start time.Time, err *error) {
+	dbDuration.WithLabelValues(db, op, kind).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		dbErrors.WithLabelValues(db, op, kind).Inc()
+	}
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// failingUN fails every message
+type failingUN struct{}
+
+func (failingUN) SendUserMessage(ctx context.Context, botPassword, user, message string) error {
+	return errors.New("zulip is down")
+}
+
+func TestInstrumentedRecurserDB(t *testing.T) {
+	ctx := context.Background()
+	rdb := &InstrumentedRecurserDB{RDB: storage.NewMemoryRecurserDB()}
+
+	errs := func(op string) float64 {
+		return testutil.ToFloat64(dbErrors.WithLabelValues("recursers", op, write))
+	}
+	setErrs, restoreErrs := errs("Set"), errs("Restore")
+
+	err := rdb.Set(ctx, "2", storage.NewRecurser("2", "ada@example.com", "Ada"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// they're still subscribed, which isn't the database going wrong
+	_, err = rdb.Restore(ctx, "2")
+	if !errors.Is(err, storage.ErrStillSubscribed) {
+		t.Fatalf("got %v, wanted ErrStillSubscribed", err)
+	}
+	// but restoring someone who was never offboarded is
+	_, err = rdb.Restore(ctx, "3")
+	if err == nil {
+		t.Fatal("restored someone who was never offboarded")
+	}
+
+	if got := errs("Set") - setErrs; got != 0 {
+		t.Errorf("counted %v errors for Set, wanted 0", got)
+	}
+	if got := errs("Restore") - restoreErrs; got != 1 {
+		t.Errorf("counted %v errors for Restore, wanted 1", got)
+	}
+}
+
+func TestInstrumentedUserNotification(t *testing.T) {
+	un := &InstrumentedUserNotification{UN: failingUN{}, Platform: "test"}
+	err := un.SendUserMessage(context.Background(), "", "ada@example.com", "hi")
+	if err == nil {
+		t.Fatal("the error got lost")
+	}
+	if got := testutil.ToFloat64(sendFailures.WithLabelValues("test")); got != 1 {
+		t.Errorf("counted %v failures, wanted 1", got)
+	}
+}
+
+func TestSubscriberCollector(t *testing.T) {
+	ctx := context.Background()
+	rdb := storage.NewMemoryRecurserDB()
+	for _, id := range []string{"2", "3", "slack:U1"} {
+		err := rdb.Set(ctx, id, storage.NewRecurser(id, id+"@example.com", id))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sc := &subscriberCollector{rdb: rdb}
+	want := `
+# HELP pairing_bot_subscribers People subscribed to Pairing Bot, by platform.
+# TYPE pairing_bot_subscribers gauge
+pairing_bot_subscribers{platform="slack"} 1
+pairing_bot_subscribers{platform="zulip"} 2
+`
+	err := testutil.CollectAndCompare(sc, strings.NewReader(want))
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the counts are reused for a while
+	rdb.Delete(ctx, "2")
+	err = testutil.CollectAndCompare(sc, strings.NewReader(want))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	MatchRun(3, 1)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{"pairing_bot_match_pairs_count", "pairing_bot_odd_one_outs_total", "go_goroutines"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("/metrics doesn't have %v", want)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+)
+
+// InstrumentedUserNotification times every message sent through UN, and
+// counts the ones that couldn't be sent
+// implements chat.UserNotification
+type InstrumentedUserNotification struct {
+	UN chat.UserNotification
+	// the label it's counted under, like "zulip"
+	Platform string
+}
+
+func (i *InstrumentedUserNotification) SendUserMessage(ctx context.Context, botPassword, user, message string) error {
+	start := time.Now()
+	err := i.UN.SendUserMessage(ctx, botPassword, user, message)
+	sendDuration.WithLabelValues(i.Platform).Observe(time.Since(start).Seconds())
+	if err != nil {
+		sendFailures.WithLabelValues(i.Platform).Inc()
+	}
+	return err
+}
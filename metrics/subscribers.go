@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// how long subscriber counts are reused for. counting them reads every
+// document, which isn't worth doing on every scrape
+const subscriberCountTTL = 5 * time.Minute
+
+var subscribersDesc = prometheus.NewDesc(
+	"pairing_bot_subscribers",
+	"People subscribed to Pairing Bot, by platform.",
+	[]string{"platform"}, nil,
+)
+
+// subscriberCollector counts subscribers when /metrics is scraped
+// implements prometheus.Collector
+type subscriberCollector struct {
+	rdb storage.RecurserDB
+
+	mu      sync.Mutex
+	counted time.Time
+	counts  map[string]int
+}
+
+// WatchSubscribers adds the subscriber counts in rdb to /metrics
+func WatchSubscribers(rdb storage.RecurserDB) error {
+	return Registry.Register(&subscriberCollector{rdb: rdb})
+}
+
+func (sc *subscriberCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- subscribersDesc
+}
+
+func (sc *subscriberCollector) Collect(ch chan<- prometheus.Metric) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if time.Since(sc.counted) > subscriberCountTTL {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		recursers, err := sc.rdb.GetAllUsers(ctx)
+		if err != nil {
+			// the last counts we had are better than nothing
+			log.Printf("Could not count subscribers: %s\n", err)
+		} else {
+			sc.counts = map[string]int{}
+			for _, r := range recursers {
+				sc.counts[storage.PlatformOf(r.ID)]++
+			}
+			sc.counted = time.Now()
+		}
+	}
+
+	for platform, n := range sc.counts {
+		ch <- prometheus.MustNewConstMetric(subscribersDesc, prometheus.GaugeValue, float64(n), platform)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/commands"
+)
+
+func TestIntegrationAnnouncements(t *testing.T) {
+	bot := newTestBot(t)
+	today := strings.ToLower(time.Now().Weekday().String())
+	admin := fakeZulipUser{1, "admin@example.com", "Admin"}
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+	cat := fakeZulipUser{4, "cat@example.com", "Cat"}
+	dan := fakeZulipUser{5, "dan@example.com", "Dan"}
+
+	for _, u := range []fakeZulipUser{ada, bea, cat, dan} {
+		bot.pm(t, u, "subscribe")
+		bot.pm(t, u, "schedule "+today)
+	}
+	for _, u := range []fakeZulipUser{ada, cat} {
+		if got := bot.pm(t, u, "announce on"); !strings.Contains(got, "**by name**") {
+			t.Errorf("announce on got %q", got)
+		}
+	}
+	if got := bot.pm(t, cat, "announce off"); !strings.Contains(got, "**won't**") {
+		t.Errorf("announce off got %q", got)
+	}
+	if got := bot.pm(t, ada, "status"); !strings.Contains(got, "**by name**") {
+		t.Errorf("status doesn't say Ada's named: %q", got)
+	}
+
+	// announcements are off until an admin picks a stream and turns them on
+	var ms commands.MatchSummary
+	bot.cron(t, "/match", &ms)
+	if ms.Pairs != 2 || ms.Announced {
+		t.Fatalf("got match summary %+v", ms)
+	}
+
+	if got := bot.pm(t, admin, "admin announce on"); !strings.Contains(got, "Which stream") {
+		t.Errorf("turning announcements on without a stream got %q", got)
+	}
+	bot.pm(t, admin, "admin announce stream #Pairing")
+	bot.pm(t, admin, "admin announce topic Today's Pairs")
+	if got := bot.pm(t, admin, "admin announce template {{.Nope}}"); !strings.Contains(got, "didn't save it") {
+		t.Errorf("a broken template got %q", got)
+	}
+	if got := bot.pm(t, admin, "admin announce on"); !strings.Contains(got, "are **on**") || !strings.Contains(got, "#Pairing") || !strings.Contains(got, "Happy pairing, Ada and Bea!") {
+		t.Errorf("turning announcements on got %q", got)
+	}
+
+	bot.cron(t, "/match", &ms)
+	if !ms.Announced {
+		t.Fatalf("got match summary %+v", ms)
+	}
+	got := bot.zulip.streamMessages("Pairing", "Today's Pairs")
+	if len(got) != 1 || got[0] != "Today **4** people paired in **2** pairs! :pear:\n\nHappy pairing, Ada!" {
+		t.Errorf("got announcements %q", got)
+	}
+
+	bot.pm(t, admin, "admin announce template Pairs today: {{.Pairs}}")
+	bot.pm(t, admin, "admin announce off")
+	bot.cron(t, "/match", &ms)
+	if ms.Announced || len(bot.zulip.streamMessages("Pairing", "Today's Pairs")) != 1 {
+		t.Errorf("announced the matches with announcements off")
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thwidge/pairing-bot/commands"
+)
+
+// "digest" sends everyone their weekly digest
+// it runs once a week (it's triggered with app engine's cron service)
+func (s *Server) digest(w http.ResponseWriter, r *http.Request) {
+	// Check that the request is originating from within app engine
+	// https://cloud.google.com/appengine/docs/flexible/go/scheduling-jobs-with-cron-yaml#validating_cron_requests
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if s.InMaintenance(r.Context()) {
+		log.Println("Maintenance mode is on, so there's no digest this week")
+		return
+	}
+
+	summary, err := s.RunDigest(r.Context(), time.Now())
+	if err != nil {
+		log.Printf("Could not send the weekly digest: %s\n", err)
+		http.Error(w, "could not send the weekly digest", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Sent %d digests, %d messages failed\n", summary.Digests, summary.Notifications.Failed)
+	writeSummary(w, summary)
+}
+
+// "purgeoffboarded" deletes offboarded people for good once they can't be restored anymore
+// it runs every day (it's triggered with app engine's cron service)
+func (s *Server) purgeoffboarded(w http.ResponseWriter, r *http.Request) {
+	// Check that the request is originating from within app engine
+	// https://cloud.google.com/appengine/docs/flexible/go/scheduling-jobs-with-cron-yaml#validating_cron_requests
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if s.InMaintenance(r.Context()) {
+		log.Println("Maintenance mode is on, so offboarded people are kept for now")
+		return
+	}
+
+	summary, err := s.RunPurge(r.Context(), time.Now())
+	if err != nil {
+		log.Printf("Could not purge offboarded recursers: %s\n", err)
+		http.Error(w, "could not purge offboarded recursers", http.StatusInternalServerError)
+		return
+	}
+	if summary.Purged > 0 {
+		log.Printf("Purged %d offboarded people\n", summary.Purged)
+	}
+	writeSummary(w, summary)
+}
+
+// "match" makes matches for pairing, and messages those people to notify them of their match
+// it runs once per day at 8am (it's triggered with app engine's cron service)
+func (s *Server) match(w http.ResponseWriter, r *http.Request) {
+	// Check that the request is originating from within app engine
+	// https://cloud.google.com/appengine/docs/flexible/go/scheduling-jobs-with-cron-yaml#validating_cron_requests
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if s.InMaintenance(r.Context()) {
+		log.Println("Maintenance mode is on, so there's no matching today")
+		return
+	}
+
+	summary := s.RunMatch(r.Context())
+	log.Printf("Made %d pairs, sent %d messages, %d failed\n", summary.Pairs, summary.Notifications.Sent, summary.Notifications.Failed)
+	writeSummary(w, summary)
+}
+
+func writeSummary(w http.ResponseWriter, summary interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(summary)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// "checkin" asks everyone who got matched today whether their pairing session actually happened.
+// it runs once per day, a while after "match" (it's triggered with app engine's cron service)
+func (s *Server) checkin(w http.ResponseWriter, r *http.Request) {
+	// Check that the request is originating from within app engine
+	// https://cloud.google.com/appengine/docs/flexible/go/scheduling-jobs-with-cron-yaml#validating_cron_requests
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		http.NotFound(w, r)
+		return
+	}
+
+	summary, err := s.RunCheckIns(r.Context())
+	if err != nil {
+		log.Printf("Could not get today's matches from DB: %s\n", err)
+		return
+	}
+	log.Printf("Sent %d check-in messages, %d failed\n", summary.Sent, summary.Failed)
+	writeSummary(w, summary)
+}
+
+// "endofbatch" makes an end-of-batch plan and sends it to the admins. it
+// doesn't offboard anyone until an admin confirms the plan
+func (s *Server) endofbatch(w http.ResponseWriter, r *http.Request) {
+	// Check that the request is originating from within app engine
+	// https://cloud.google.com/appengine/docs/flexible/go/scheduling-jobs-with-cron-yaml#validating_cron_requests
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if s.InMaintenance(r.Context()) {
+		log.Println("Maintenance mode is on, so there's no end-of-batch offboarding")
+		return
+	}
+
+	plan, err := s.PlanEndOfBatch(r.Context(), "the end-of-batch job")
+	if err != nil {
+		log.Printf("Could not make an end-of-batch plan: %s\n", err)
+		http.Error(w, "could not make an end-of-batch plan", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Made end-of-batch plan %s: offboard %d people, ask %d to stay\n", plan.Code, len(plan.Offboard), len(plan.Prompt))
+	s.TellAdmins(r.Context(), commands.FormatPlan(plan, s.Retention()))
+	writeSummary(w, commands.EndOfBatchPlanSummary{Code: plan.Code, Offboard: len(plan.Offboard), Prompt: len(plan.Prompt)})
+}
+
+// "retryoutbox" tries to deliver any messages that failed earlier, even after retrying
+// it runs every half hour (it's triggered with app engine's cron service)
+func (s *Server) retryoutbox(w http.ResponseWriter, r *http.Request) {
+	// Check that the request is originating from within app engine
+	// https://cloud.google.com/appengine/docs/flexible/go/scheduling-jobs-with-cron-yaml#validating_cron_requests
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := r.Context()
+
+	botPassword, err := s.ADB.GetKey(ctx, "apiauth", "key")
+	if err != nil {
+		log.Println("Something weird happened trying to read the auth token from the database")
+	}
+
+	sent, left, err := s.Outbox.Retry(ctx, botPassword)
+	if err != nil {
+		log.Printf("Could not read the outbox from DB: %s\n", err)
+		return
+	}
+	if sent > 0 || left > 0 {
+		log.Printf("Sent %d messages from the outbox, %d still waiting\n", sent, left)
+	}
+}
+
+// "remind" reminds people who'll be matched next that it's coming up
+// it runs once a day, in the evening (it's triggered with app engine's cron service)
+func (s *Server) remind(w http.ResponseWriter, r *http.Request) {
+	// Check that the request is originating from within app engine
+	// https://cloud.google.com/appengine/docs/flexible/go/scheduling-jobs-with-cron-yaml#validating_cron_requests
+	if r.Header.Get("X-Appengine-Cron") != "true" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// matching is paused too, so there's nothing to remind anyone about
+	if s.InMaintenance(r.Context()) {
+		log.Println("Maintenance mode is on, so there are no reminders today")
+		return
+	}
+
+	summary, err := s.RunReminders(r.Context(), time.Now())
+	if err != nil {
+		log.Printf("Could not send reminders: %s\n", err)
+		http.Error(w, "could not send reminders", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Reminded %d people, %d messages failed\n", summary.Reminded, summary.Notifications.Failed)
+	writeSummary(w, summary)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/commands"
+)
+
+func TestIntegrationDigest(t *testing.T) {
+	bot := newTestBot(t)
+	today := strings.ToLower(time.Now().Weekday().String())
+	notToday := strings.ToLower(time.Now().AddDate(0, 0, 1).Weekday().String())
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+	cat := fakeZulipUser{4, "cat@example.com", "Cat"}
+
+	for _, u := range []fakeZulipUser{ada, bea, cat} {
+		bot.pm(t, u, "subscribe")
+	}
+	for _, u := range []fakeZulipUser{ada, bea} {
+		bot.pm(t, u, "schedule "+today)
+	}
+	bot.pm(t, cat, "schedule "+notToday)
+	bot.pm(t, bea, "history")
+	if got := bot.pm(t, cat, "digest off"); !strings.Contains(got, "**won't**") {
+		t.Errorf("digest off got %q", got)
+	}
+
+	var ms commands.MatchSummary
+	bot.cron(t, "/match", &ms)
+	if ms.Pairs != 1 {
+		t.Fatalf("got match summary %+v", ms)
+	}
+
+	var ds commands.DigestSummary
+	bot.cron(t, "/digest", &ds)
+	if ds.Digests != 2 || ds.Notifications.Sent != 2 {
+		t.Fatalf("got digest summary %+v", ds)
+	}
+	adaGot := bot.zulip.messagesTo(ada.email)
+	if len(adaGot) != 1 || !strings.Contains(adaGot[0], "paired with **Bea**") || !strings.Contains(adaGot[0], "`history`") {
+		t.Errorf("Ada got %q", adaGot)
+	}
+	// Bea has already used history, so they aren't told about it
+	beaGot := bot.zulip.messagesTo(bea.email)
+	if len(beaGot) != 1 || !strings.Contains(beaGot[0], "paired with **Ada**") || strings.Contains(beaGot[0], "`history`") {
+		t.Errorf("Bea got %q", beaGot)
+	}
+	if got := bot.zulip.messagesTo(cat.email); len(got) != 0 {
+		t.Errorf("Cat turned the digest off, but got %q", got)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/commands"
+)
+
+func TestIntegrationMatchIntroductions(t *testing.T) {
+	bot := newTestBot(t)
+	bot.pl.Directory = &commands.StaticDirectory{Profiles: map[string]commands.Profile{
+		"2": {Pronouns: "they/them", Batch: "W1'24", Current: true},
+	}}
+	today := strings.ToLower(time.Now().Weekday().String())
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+	for _, u := range []fakeZulipUser{ada, bea} {
+		bot.pm(t, u, "subscribe")
+		bot.pm(t, u, "schedule "+today)
+	}
+
+	var ms commands.MatchSummary
+	bot.cron(t, "/match", &ms)
+	if ms.Pairs != 1 {
+		t.Fatalf("got match summary %+v", ms)
+	}
+	// Bea isn't in the directory, so all we know is their name
+	got := bot.zulip.messagesTo(ada.email + ", " + bea.email)
+	if len(got) != 1 || !strings.HasPrefix(got[0], commands.MatchedMessage) ||
+		!strings.Contains(got[0], "\n* **Ada** (they/them), W1'24") || !strings.Contains(got[0], "\n* **Bea**") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestIntegrationAlumsArentMatched(t *testing.T) {
+	bot := newTestBot(t)
+	bot.pl.Directory = &commands.StaticDirectory{Profiles: map[string]commands.Profile{
+		"2": {Batch: "W1'24", Current: true},
+		"4": {Batch: "F2'23", Current: false},
+	}}
+	today := strings.ToLower(time.Now().Weekday().String())
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+	cat := fakeZulipUser{4, "cat@example.com", "Cat"}
+	for _, u := range []fakeZulipUser{ada, bea, cat} {
+		bot.pm(t, u, "subscribe")
+		bot.pm(t, u, "schedule "+today)
+	}
+
+	// Cat's an alum, so Ada and Bea are matched and nobody's the odd one out
+	var ms commands.MatchSummary
+	bot.cron(t, "/match", &ms)
+	if ms.Pairs != 1 || ms.OddOneOuts != 0 || ms.Alums != 1 {
+		t.Fatalf("got match summary %+v", ms)
+	}
+	if got := bot.zulip.messagesTo(ada.email + ", " + bea.email); len(got) != 1 {
+		t.Errorf("Ada and Bea got %q", got)
+	}
+	if got := bot.zulip.messagesTo(cat.email); len(got) != 0 {
+		t.Errorf("Cat got %q", got)
+	}
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/commands"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const (
+	testDiscordAppID    = "1000"
+	testDiscordChannel  = "2000"
+	testDiscordBotToken = "discord-bot-token"
+	testDiscordSignedAt = "1700000000"
+)
+
+// fakeDiscord stands in for Discord's HTTP API. It remembers the slash
+// commands registered with it, the replies to interactions, and every message
+// posted in a DM or thread, and it can send the bot signed interactions.
+type fakeDiscord struct {
+	*httptest.Server
+
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+
+	mu       sync.Mutex
+	commands []map[string]interface{}
+	// interaction token -> what the bot replied with
+	replies map[string]string
+	// channel ID -> the users in it
+	channels map[string][]string
+	threads  int
+	posted   []sentMessage
+	nextID   int
+}
+
+type fakeDiscordUser struct {
+	id   string
+	name string
+}
+
+func newFakeDiscord(t *testing.T) *fakeDiscord {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDiscord{
+		privateKey: privateKey,
+		publicKey:  publicKey,
+		replies:    map[string]string{},
+		channels:   map[string][]string{},
+	}
+	d.Server = httptest.NewServer(http.HandlerFunc(d.handle))
+	t.Cleanup(d.Close)
+	return d
+}
+
+// platform is a DiscordPlatform pointed at this fake
+func (d *fakeDiscord) platform() *chat.DiscordPlatform {
+	adb := storage.NewMemoryAPIAuthDB()
+	adb.SetKey("discordauth", "publickey", hex.EncodeToString(d.publicKey))
+	adb.SetKey("discordauth", "bottoken", testDiscordBotToken)
+	return &chat.DiscordPlatform{
+		APIURL:      d.URL,
+		AppID:       testDiscordAppID,
+		ChannelID:   testDiscordChannel,
+		Commands:    commands.DiscordCommands,
+		ADB:         adb,
+		MaxRetries:  1,
+		BaseBackoff: time.Millisecond,
+	}
+}
+
+func (d *fakeDiscord) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(status int, msg string) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"message": %q, "code": 0}`, msg)
+	}
+
+	if r.Header.Get("Authorization") != "Bot "+testDiscordBotToken {
+		fail(http.StatusUnauthorized, "401: Unauthorized")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	raw, _ := ioutil.ReadAll(r.Body)
+	var body map[string]interface{}
+	json.Unmarshal(raw, &body)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case r.Method == "PUT" && len(parts) == 3 && parts[0] == "applications" && parts[2] == "commands":
+		// registering commands is the one call that sends a list
+		d.commands = nil
+		json.Unmarshal(raw, &d.commands)
+		w.Write(raw)
+
+	case r.Method == "PATCH" && len(parts) == 5 && parts[0] == "webhooks" && parts[1] == testDiscordAppID:
+		d.replies[parts[2]] = fmt.Sprint(body["content"])
+		fmt.Fprint(w, `{"id": "1"}`)
+
+	case r.Method == "POST" && r.URL.Path == "/users/@me/channels":
+		user := fmt.Sprint(body["recipient_id"])
+		channel := "dm-" + user
+		d.channels[channel] = []string{user}
+		fmt.Fprintf(w, `{"id": %q, "type": 1}`, channel)
+
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "channels" && parts[2] == "threads":
+		if parts[1] != testDiscordChannel || body["type"] != float64(chat.DiscordPrivateThread) {
+			fail(http.StatusBadRequest, "Invalid Form Body")
+			return
+		}
+		d.threads++
+		thread := "thread-" + strconv.Itoa(d.threads)
+		d.channels[thread] = nil
+		fmt.Fprintf(w, `{"id": %q, "type": 12}`, thread)
+
+	case r.Method == "PUT" && len(parts) == 4 && parts[0] == "channels" && parts[2] == "thread-members":
+		if _, ok := d.channels[parts[1]]; !ok {
+			fail(http.StatusNotFound, "Unknown Channel")
+			return
+		}
+		d.channels[parts[1]] = append(d.channels[parts[1]], parts[3])
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "channels" && parts[2] == "messages":
+		if _, ok := d.channels[parts[1]]; !ok {
+			fail(http.StatusNotFound, "Unknown Channel")
+			return
+		}
+		d.posted = append(d.posted, sentMessage{to: parts[1], content: fmt.Sprint(body["content"])})
+		fmt.Fprintf(w, `{"id": "%d"}`, len(d.posted))
+
+	default:
+		fail(http.StatusNotFound, "404: Not Found")
+	}
+}
+
+// messagesTo returns every message posted in a DM or thread with exactly these users in it
+func (d *fakeDiscord) messagesTo(users ...string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var contents []string
+	for _, m := range d.posted {
+		if sameRecipients(strings.Join(d.channels[m.to], ","), strings.Join(users, ",")) {
+			contents = append(contents, m.content)
+		}
+	}
+	return contents
+}
+
+// slash uses a slash command as user, and returns the bot's (deferred) reply
+func (d *fakeDiscord) slash(t *testing.T, botURL string, from fakeDiscordUser, command string, args ...string) string {
+	t.Helper()
+
+	d.mu.Lock()
+	d.nextID++
+	token := "token-" + strconv.Itoa(d.nextID)
+	d.mu.Unlock()
+
+	var options []map[string]interface{}
+	for _, a := range args {
+		options = append(options, map[string]interface{}{"name": "args", "type": 3, "value": a})
+	}
+	status, body := d.interact(t, botURL, map[string]interface{}{
+		"type":           chat.DiscordApplicationCommand,
+		"application_id": testDiscordAppID,
+		"token":          token,
+		"data":           map[string]interface{}{"name": command, "options": options},
+		"member": map[string]interface{}{
+			"user": map[string]interface{}{"id": from.id, "username": strings.ToLower(from.name), "global_name": from.name},
+		},
+	}, d.privateKey)
+	if status != http.StatusOK || !strings.Contains(string(body), `"type":5`) {
+		t.Fatalf("bot answered /%s with HTTP %d: %s", command, status, body)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	reply, ok := d.replies[token]
+	if !ok {
+		t.Fatalf("bot never followed up on /%s", command)
+	}
+	return reply
+}
+
+// interact sends the bot an interaction, signed with key
+func (d *fakeDiscord) interact(t *testing.T, botURL string, interaction interface{}, key ed25519.PrivateKey) (int, []byte) {
+	t.Helper()
+
+	b, err := json.Marshal(interaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", botURL+"/discord/events", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := ed25519.Sign(key, append([]byte(testDiscordSignedAt), b...))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature-Timestamp", testDiscordSignedAt)
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(sig))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, buf.Bytes()
+}
+
+func TestDiscordVerifiesRequests(t *testing.T) {
+	discord := newFakeDiscord(t)
+	bot := newTestBot(t, discord.platform())
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ping := map[string]interface{}{"type": chat.DiscordPing}
+	if status, body := discord.interact(t, bot.URL, ping, discord.privateKey); status != http.StatusOK || strings.TrimSpace(string(body)) != `{"type":1}` {
+		t.Errorf("ping got HTTP %d: %s", status, body)
+	}
+	if status, _ := discord.interact(t, bot.URL, ping, otherKey); status != http.StatusUnauthorized {
+		t.Errorf("got HTTP %d for a bad signature, wanted 401", status)
+	}
+}
+
+func TestDiscordCommandsAndMatch(t *testing.T) {
+	discord := newFakeDiscord(t)
+	bot := newTestBot(t, discord.platform())
+	today := strings.ToLower(time.Now().Weekday().String())
+
+	ada := fakeDiscordUser{"11", "Ada"}
+	bea := fakeDiscordUser{"12", "Bea"}
+	cat := fakeDiscordUser{"13", "Cat"}
+	for _, u := range []fakeDiscordUser{ada, bea, cat} {
+		if got := discord.slash(t, bot.URL, u, "subscribe"); got != commands.SubscribeMessage {
+			t.Fatalf("%s subscribed and got %q", u.name, got)
+		}
+		if got := discord.slash(t, bot.URL, u, "schedule", today); !strings.Contains(got, "new schedule's been set") {
+			t.Fatalf("%s set their schedule and got %q", u.name, got)
+		}
+	}
+	if got := discord.slash(t, bot.URL, ada, "status"); !strings.Contains(got, "You're Ada") {
+		t.Errorf("status got %q", got)
+	}
+	if got := discord.slash(t, bot.URL, ada, "skip"); got != commands.HelpMessage {
+		t.Errorf("skip without an argument got %q", got)
+	}
+
+	var ms commands.MatchSummary
+	bot.cron(t, "/match", &ms)
+	if ms.Pairs != 1 || ms.OddOneOuts != 1 || ms.Notifications.Sent != 2 {
+		t.Fatalf("got match summary %+v", ms)
+	}
+
+	// the pair get a private thread, and the odd one out gets a DM
+	var threads, dms int
+	for _, pair := range [][]string{{ada.id, bea.id}, {ada.id, cat.id}, {bea.id, cat.id}} {
+		if msgs := discord.messagesTo(pair...); len(msgs) == 1 && msgs[0] == commands.MatchedMessage {
+			threads++
+		}
+	}
+	for _, u := range []fakeDiscordUser{ada, bea, cat} {
+		if msgs := discord.messagesTo(u.id); len(msgs) == 1 && msgs[0] == commands.OddOneOutMessage {
+			dms++
+		}
+	}
+	if threads != 1 || dms != 1 {
+		t.Errorf("got %d match threads and %d odd-one-out DMs", threads, dms)
+	}
+}
+
+func TestDiscordRegistersCommands(t *testing.T) {
+	discord := newFakeDiscord(t)
+	err := discord.platform().RegisterCommands(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discord.commands) != len(commands.DiscordCommands) {
+		t.Errorf("registered %d slash commands, wanted %d", len(discord.commands), len(commands.DiscordCommands))
+	}
+}
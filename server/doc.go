@@ -0,0 +1,3 @@
+// Package server routes HTTP requests to a commands.PairingLogic: Zulip's
+// webhook, the other platforms' events, and App Engine's cron jobs.
+package server
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/commands"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// fakeEmail stands in for email in the integration tests, and keeps every
+// message. Sending real email is tested against fakeSMTP, in the chat package
+type fakeEmail struct {
+	mu    sync.Mutex
+	mails map[string][]string
+}
+
+// implements UserNotification
+func (e *fakeEmail) SendUserMessage(ctx context.Context, botPassword, user, message string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.mails[user] = append(e.mails[user], message)
+	return nil
+}
+
+func (e *fakeEmail) mailTo(addr string) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mails[addr]
+}
+
+func TestIntegrationNotifyViaEmail(t *testing.T) {
+	email := &fakeEmail{mails: map[string][]string{}}
+	bot := newTestBot(t)
+	today := strings.ToLower(time.Now().Weekday().String())
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+
+	for _, u := range []fakeZulipUser{ada, bea} {
+		bot.pm(t, u, "subscribe")
+		bot.pm(t, u, "schedule "+today)
+		bot.pm(t, u, "batch W1'24 "+time.Now().Format(storage.BatchDateLayout))
+	}
+	if got := bot.pm(t, ada, "notify via email"); !strings.Contains(got, "can't send email") {
+		t.Errorf("asking for email without it being set up got %q", got)
+	}
+
+	bot.notify.Email = email
+	bot.pl.EmailEnabled = true
+	if got := bot.pm(t, ada, "notify via email"); !strings.Contains(got, "**by email**") {
+		t.Errorf("notify via email got %q", got)
+	}
+	if got := bot.pm(t, ada, "status"); !strings.Contains(got, "**by email**, at ada@example.com") {
+		t.Errorf("status doesn't say Ada gets email: %q", got)
+	}
+
+	// Ada hears about their match by email, and Bea on Zulip
+	var ms commands.MatchSummary
+	bot.cron(t, "/match", &ms)
+	if ms.Pairs != 1 || ms.Notifications.Sent != 2 {
+		t.Fatalf("got match summary %+v", ms)
+	}
+	if got := email.mailTo(ada.email); len(got) != 1 || !strings.Contains(got[0], "This message went to: ") || !strings.Contains(got[0], "Bea (bea@example.com)") {
+		t.Errorf("Ada got %q by email", got)
+	}
+	if got := bot.zulip.messagesTo(bea.email); len(got) != 1 || got[0] != commands.MatchedMessage {
+		t.Errorf("Bea got %q on Zulip", got)
+	}
+	if got := bot.zulip.messagesTo(ada.email + ", " + bea.email); len(got) != 0 {
+		t.Errorf("Ada got a Zulip message too: %q", got)
+	}
+
+	// when Zulip turns Bea's end-of-batch message away, it goes by email instead
+	bot.zulip.mu.Lock()
+	bot.zulip.botPassword = "rotated"
+	bot.zulip.mu.Unlock()
+
+	es := bot.endOfBatch(t)
+	if es.Offboarded != 2 || es.Notifications.Sent != 2 || es.Notifications.Failed != 0 {
+		t.Errorf("got end-of-batch summary %+v", es)
+	}
+	if got := email.mailTo(bea.email); len(got) != 1 || !strings.Contains(got[0], "couldn't reach you on Zulip") {
+		t.Errorf("Bea got %q by email", got)
+	}
+}
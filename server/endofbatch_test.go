@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/commands"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+func TestIntegrationEndOfBatchConfirmation(t *testing.T) {
+	bot := newTestBot(t)
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+	cat := fakeZulipUser{4, "cat@example.com", "Cat"}
+	dan := fakeZulipUser{5, "dan@example.com", "Dan"}
+	eve := fakeZulipUser{6, "eve@example.com", "Eve"}
+
+	// Ada and Cat's batch ended yesterday, and Bea's goes on
+	yesterday := time.Now().AddDate(0, 0, -1).Format(storage.BatchDateLayout)
+	nextMonth := time.Now().AddDate(0, 1, 0).Format(storage.BatchDateLayout)
+	for _, u := range []fakeZulipUser{testAdmin, ada, bea, cat, dan, eve} {
+		bot.pm(t, u, "subscribe")
+	}
+	bot.pm(t, ada, "batch W1'24 "+yesterday)
+	bot.pm(t, cat, "batch W1'24 "+yesterday)
+	bot.pm(t, bea, "batch SP1'24 "+nextMonth)
+
+	// the cron job only makes a plan, and sends it to the admin
+	var ps commands.EndOfBatchPlanSummary
+	bot.cron(t, "/endofbatch", &ps)
+	if ps.Code == "" || ps.Offboard != 2 || ps.Prompt != 4 {
+		t.Fatalf("got end-of-batch plan summary %+v", ps)
+	}
+	msgs := bot.zulip.messagesTo(testAdmin.email)
+	if len(msgs) != 1 || !strings.Contains(msgs[0], "confirm "+ps.Code) || !strings.Contains(msgs[0], "| Ada | 2 | batch ended |") {
+		t.Errorf("the admin got %q", msgs)
+	}
+	if got := bot.pm(t, ada, "status"); got == commands.NotSubscribedMessage {
+		t.Fatal("Ada was offboarded before anyone confirmed the plan")
+	}
+
+	if got := bot.pm(t, testAdmin, "admin endofbatch confirm ffffff"); !strings.Contains(got, "not `ffffff`") {
+		t.Errorf("confirming with the wrong code got %q", got)
+	}
+
+	// Cat turns out to be staying for another batch before the plan runs,
+	// Dan's batch turns out to have ended, and Eve unsubscribes
+	bot.pm(t, cat, "batch SP1'24 "+nextMonth)
+	bot.pm(t, dan, "batch W1'24 "+yesterday)
+	bot.pm(t, eve, "unsubscribe")
+	got := bot.pm(t, testAdmin, "admin endofbatch confirm "+ps.Code)
+	if !strings.Contains(got, "offboarded **1** people, asked **2**") || !strings.Contains(got, "left **3** people alone") {
+		t.Errorf("confirming the plan got %q", got)
+	}
+	if got := bot.pm(t, ada, "status"); got != commands.NotSubscribedMessage {
+		t.Errorf("Ada is still subscribed after end of batch: %q", got)
+	}
+	if got := bot.pm(t, cat, "status"); got == commands.NotSubscribedMessage {
+		t.Error("Cat was offboarded even though their batch changed")
+	}
+	for _, u := range []fakeZulipUser{dan, eve} {
+		if msgs := bot.zulip.messagesTo(u.email); len(msgs) > 0 && msgs[len(msgs)-1] == commands.StayPromptMessage {
+			t.Errorf("%v was asked to stay after the plan was made", u.name)
+		}
+	}
+	if got := bot.pm(t, testAdmin, "admin endofbatch confirm "+ps.Code); !strings.Contains(got, "no end-of-batch plan waiting") {
+		t.Errorf("confirming the plan twice got %q", got)
+	}
+
+	// and Ada can be brought back, just as they were
+	if got := bot.pm(t, testAdmin, "admin offboarded"); !strings.Contains(got, "| Ada | 2 | ada@example.com |") {
+		t.Errorf("admin offboarded got %q", got)
+	}
+	if got := bot.pm(t, testAdmin, "admin restore ada@example.com"); !strings.Contains(got, "restored **1** people") {
+		t.Errorf("restoring Ada got %q", got)
+	}
+	if msgs := bot.zulip.messagesTo(ada.email); len(msgs) == 0 || msgs[len(msgs)-1] != commands.RestoredMessage {
+		t.Errorf("Ada wasn't told they were restored: %q", msgs)
+	}
+	if got := bot.pm(t, ada, "status"); !strings.Contains(got, "You're in batch **W1'24**") {
+		t.Errorf("Ada lost their settings: %q", got)
+	}
+}
+
+func TestIntegrationEndOfBatchCancel(t *testing.T) {
+	bot := newTestBot(t)
+	ctx := context.Background()
+
+	if got := bot.pm(t, testAdmin, "admin endofbatch"); !strings.Contains(got, "Nothing has happened yet") {
+		t.Errorf("admin endofbatch got %q", got)
+	}
+	if got := bot.pm(t, testAdmin, "admin endofbatch cancel"); !strings.Contains(got, "threw away") {
+		t.Errorf("admin endofbatch cancel got %q", got)
+	}
+	if got := bot.pm(t, testAdmin, "admin endofbatch status"); !strings.Contains(got, "no end-of-batch plan waiting") {
+		t.Errorf("admin endofbatch status got %q", got)
+	}
+
+	// a plan that's been sitting around too long can't be confirmed
+	bot.pm(t, testAdmin, "admin endofbatch")
+	plan, err := bot.pl.CDB.GetEndOfBatchPlan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan.Created = plan.Created.Add(-2 * commands.EndOfBatchPlanTTL)
+	bot.pl.CDB.SetEndOfBatchPlan(ctx, plan)
+	if got := bot.pm(t, testAdmin, "admin endofbatch confirm "+plan.Code); !strings.Contains(got, "more than a day old") {
+		t.Errorf("confirming an old plan got %q", got)
+	}
+}
+
+func TestIntegrationRestoreAllAndPurge(t *testing.T) {
+	bot := newTestBot(t)
+	ctx := context.Background()
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+
+	yesterday := time.Now().AddDate(0, 0, -1).Format(storage.BatchDateLayout)
+	for _, u := range []fakeZulipUser{ada, bea} {
+		bot.pm(t, u, "subscribe")
+		bot.pm(t, u, "batch W1'24 "+yesterday)
+	}
+	if es := bot.endOfBatch(t); es.Offboarded != 2 {
+		t.Fatalf("got end-of-batch summary %+v", es)
+	}
+
+	// Bea subscribes again on their own, so restoring everyone leaves them be
+	bot.pm(t, bea, "subscribe")
+	got := bot.pm(t, testAdmin, "admin restore all")
+	if !strings.Contains(got, "restored **1** people") || !strings.Contains(got, "Bea subscribed again") {
+		t.Errorf("admin restore all got %q", got)
+	}
+	if got := bot.pm(t, bea, "status"); strings.Contains(got, "W1'24") {
+		t.Errorf("Bea's old settings came back over their new ones: %q", got)
+	}
+
+	// nobody is purged until the retention window is over
+	var purged commands.PurgeSummary
+	bot.cron(t, "/purgeoffboarded", &purged)
+	if purged.Purged != 0 {
+		t.Errorf("purged %d people too early", purged.Purged)
+	}
+	err := bot.rdb.Offboard(ctx, "2", storage.ReasonBatchEnded, time.Now().Add(-commands.DefaultOffboardRetention-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.cron(t, "/purgeoffboarded", &purged)
+	if purged.Purged != 1 {
+		t.Errorf("purged %d people, wanted 1", purged.Purged)
+	}
+	if got := bot.pm(t, testAdmin, "admin restore 2"); !strings.Contains(got, "couldn't find") {
+		t.Errorf("restoring someone who was purged got %q", got)
+	}
+}
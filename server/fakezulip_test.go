@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thwidge/pairing-bot/chat"
+)
+
+// fakeZulip stands in for recurse.zulipchat.com. It accepts messages on
+// /api/v1/messages from a bot with the right credentials and remembers them,
+// and it can send outgoing-webhook requests to the bot like Zulip does when
+// someone PMs it.
+type fakeZulip struct {
+	*httptest.Server
+
+	botUsername string
+	// the token Zulip puts in every outgoing webhook
+	webhookToken string
+
+	mu sync.Mutex
+	// tests can change this to see what happens when the bot's API key is wrong
+	botPassword string
+	sent        []sentMessage
+}
+
+type sentMessage struct {
+	msgType string
+	to      string
+	// only for stream messages
+	topic   string
+	content string
+}
+
+type fakeZulipUser struct {
+	id    int
+	email string
+	name  string
+}
+
+func newFakeZulip(t *testing.T, botUsername, botPassword, webhookToken string) *fakeZulip {
+	z := &fakeZulip{
+		botUsername:  botUsername,
+		botPassword:  botPassword,
+		webhookToken: webhookToken,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/messages", z.handleMessages)
+	z.Server = httptest.NewServer(mux)
+	t.Cleanup(z.Close)
+	return z
+}
+
+// messagesURL is what the bot should use as its zulipAPIURL
+func (z *fakeZulip) messagesURL() string {
+	return z.URL + "/api/v1/messages"
+}
+
+func (z *fakeZulip) handleMessages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"result": "error", "msg": "Method not allowed"}`)
+		return
+	}
+
+	z.mu.Lock()
+	botPassword := z.botPassword
+	z.mu.Unlock()
+
+	user, password, ok := r.BasicAuth()
+	if !ok || user != z.botUsername || password != botPassword {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"result": "error", "msg": "Invalid API key", "code": "INVALID_API_KEY"}`)
+		return
+	}
+
+	msg := sentMessage{
+		msgType: r.PostFormValue("type"),
+		to:      r.PostFormValue("to"),
+		topic:   r.PostFormValue("topic"),
+		content: r.PostFormValue("content"),
+	}
+	if msg.msgType == "" || msg.to == "" || msg.content == "" || (msg.msgType == "stream" && msg.topic == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"result": "error", "msg": "Missing argument", "code": "REQUEST_VARIABLE_MISSING"}`)
+		return
+	}
+
+	z.mu.Lock()
+	z.sent = append(z.sent, msg)
+	id := len(z.sent)
+	z.mu.Unlock()
+
+	fmt.Fprintf(w, `{"result": "success", "msg": "", "id": %d}`, id)
+}
+
+// messagesTo returns the content of every message sent to exactly this
+// recipient, which can be a comma-separated group of emails
+func (z *fakeZulip) messagesTo(to string) []string {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	var contents []string
+	for _, m := range z.sent {
+		if m.msgType == "private" && sameRecipients(m.to, to) {
+			contents = append(contents, m.content)
+		}
+	}
+	return contents
+}
+
+// streamMessages returns the content of every message posted in a stream's topic
+func (z *fakeZulip) streamMessages(stream, topic string) []string {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	var contents []string
+	for _, m := range z.sent {
+		if m.msgType == "stream" && m.to == stream && m.topic == topic {
+			contents = append(contents, m.content)
+		}
+	}
+	return contents
+}
+
+// sameRecipients compares two comma-separated lists of emails, ignoring order
+func sameRecipients(a, b string) bool {
+	split := func(s string) map[string]bool {
+		set := map[string]bool{}
+		for _, e := range strings.Split(s, ",") {
+			set[strings.TrimSpace(e)] = true
+		}
+		return set
+	}
+	as, bs := split(a), split(b)
+	if len(as) != len(bs) {
+		return false
+	}
+	for e := range as {
+		if !bs[e] {
+			return false
+		}
+	}
+	return true
+}
+
+func (z *fakeZulip) sentCount() int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return len(z.sent)
+}
+
+// sendPM delivers a private message from user to the bot's /webhooks endpoint,
+// the same way a Zulip outgoing webhook would, and returns the bot's reply
+func (z *fakeZulip) sendPM(t *testing.T, botURL string, from fakeZulipUser, content string) string {
+	t.Helper()
+
+	payload := map[string]interface{}{
+		"data":    content,
+		"token":   z.webhookToken,
+		"trigger": "private_message",
+		"message": map[string]interface{}{
+			"sender_id":        from.id,
+			"sender_email":     from.email,
+			"sender_full_name": from.name,
+			"display_recipient": []interface{}{
+				map[string]interface{}{"email": from.email, "full_name": from.name, "id": from.id},
+				map[string]interface{}{"email": z.botUsername, "full_name": "Pairing Bot", "id": 1},
+			},
+		},
+	}
+	status, body := z.postWebhook(t, botURL, payload)
+	if status != http.StatusOK {
+		t.Fatalf("bot answered %q with HTTP %d: %s", content, status, body)
+	}
+
+	var reply chat.BotResponse
+	err := json.Unmarshal(body, &reply)
+	if err != nil {
+		t.Fatalf("bot answered %q with something that's not JSON: %s", content, body)
+	}
+	return reply.Message
+}
+
+// postWebhook sends an arbitrary outgoing-webhook payload to the bot
+func (z *fakeZulip) postWebhook(t *testing.T, botURL string, payload interface{}) (int, []byte) {
+	t.Helper()
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(botURL+"/webhooks", "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, buf.Bytes()
+}
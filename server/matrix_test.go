@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/commands"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const (
+	testMatrixBot     = "@pairing-bot:example.org"
+	testMatrixASToken = "as-token"
+	testMatrixHSToken = "hs-token"
+)
+
+// fakeHomeserver stands in for a Matrix homeserver with Pairing Bot
+// registered as an application service. It serves the few client-server API
+// endpoints the bot uses, and it can push transactions to the bot like a real
+// homeserver does.
+type fakeHomeserver struct {
+	*httptest.Server
+
+	// user ID -> display name
+	users map[string]string
+
+	mu sync.Mutex
+	// room ID -> everyone in it, or invited to it
+	rooms   map[string][]string
+	created int
+	posted  []sentMessage
+	// transaction IDs of the messages the bot has sent, so retries aren't posted twice
+	sendTxns map[string]bool
+	nextTxn  int
+}
+
+func newFakeHomeserver(t *testing.T, users map[string]string) *fakeHomeserver {
+	hs := &fakeHomeserver{
+		users:    users,
+		rooms:    map[string][]string{},
+		sendTxns: map[string]bool{},
+	}
+	hs.Server = httptest.NewServer(http.HandlerFunc(hs.handle))
+	t.Cleanup(hs.Close)
+	return hs
+}
+
+// platform is a MatrixPlatform pointed at this homeserver
+func (hs *fakeHomeserver) platform() *chat.MatrixPlatform {
+	adb := storage.NewMemoryAPIAuthDB()
+	adb.SetKey("matrixauth", "astoken", testMatrixASToken)
+	adb.SetKey("matrixauth", "hstoken", testMatrixHSToken)
+	return &chat.MatrixPlatform{
+		Homeserver:  hs.URL,
+		BotUserID:   testMatrixBot,
+		ADB:         adb,
+		Rooms:       storage.NewMemoryRoomDB(),
+		MaxRetries:  1,
+		BaseBackoff: time.Millisecond,
+	}
+}
+
+func (hs *fakeHomeserver) handle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fail := func(status int, errcode string) {
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"errcode": %q, "error": "nope"}`, errcode)
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+testMatrixASToken {
+		fail(http.StatusUnauthorized, "M_UNKNOWN_TOKEN")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3")
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	switch {
+	case r.Method == "POST" && path == "/createRoom":
+		var req struct {
+			Invite []string `json:"invite"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		hs.created++
+		room := "!r" + strconv.Itoa(hs.created) + ":example.org"
+		hs.rooms[room] = append(req.Invite, testMatrixBot)
+		fmt.Fprintf(w, `{"room_id": %q}`, room)
+
+	case r.Method == "POST" && len(parts) == 2 && parts[0] == "join":
+		if _, ok := hs.rooms[parts[1]]; !ok {
+			fail(http.StatusNotFound, "M_NOT_FOUND")
+			return
+		}
+		fmt.Fprintf(w, `{"room_id": %q}`, parts[1])
+
+	case r.Method == "GET" && len(parts) == 3 && parts[0] == "profile" && parts[2] == "displayname":
+		name, ok := hs.users[parts[1]]
+		if !ok {
+			fail(http.StatusNotFound, "M_NOT_FOUND")
+			return
+		}
+		fmt.Fprintf(w, `{"displayname": %q}`, name)
+
+	case r.Method == "PUT" && len(parts) == 5 && parts[0] == "rooms" && parts[2] == "send":
+		var content struct {
+			Body string `json:"body"`
+		}
+		json.NewDecoder(r.Body).Decode(&content)
+		if _, ok := hs.rooms[parts[1]]; !ok {
+			fail(http.StatusForbidden, "M_FORBIDDEN")
+			return
+		}
+		if !hs.sendTxns[parts[4]] {
+			hs.sendTxns[parts[4]] = true
+			hs.posted = append(hs.posted, sentMessage{to: parts[1], content: content.Body})
+		}
+		fmt.Fprintf(w, `{"event_id": "$e%d"}`, len(hs.posted))
+
+	default:
+		fail(http.StatusNotFound, "M_UNRECOGNIZED")
+	}
+}
+
+// messagesIn returns the content of every message the bot posted in a room
+func (hs *fakeHomeserver) messagesIn(room string) []string {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	var contents []string
+	for _, m := range hs.posted {
+		if m.to == room {
+			contents = append(contents, m.content)
+		}
+	}
+	return contents
+}
+
+// roomWith finds the room that has exactly these people (and the bot) in it
+func (hs *fakeHomeserver) roomWith(users ...string) string {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	for room, members := range hs.rooms {
+		if sameRecipients(strings.Join(members, ","), strings.Join(append(users, testMatrixBot), ",")) {
+			return room
+		}
+	}
+	return ""
+}
+
+// startDM opens a direct-message room between user and the bot, and tells the bot it's been invited
+func (hs *fakeHomeserver) startDM(t *testing.T, botURL, user string) string {
+	t.Helper()
+
+	hs.mu.Lock()
+	room := "!dm-" + strings.TrimPrefix(user, "@")
+	hs.rooms[room] = []string{user, testMatrixBot}
+	hs.mu.Unlock()
+
+	stateKey := testMatrixBot
+	status := hs.push(t, botURL, hs.txnID(), testMatrixHSToken, withContent(chat.MatrixEvent{
+		Type:     "m.room.member",
+		RoomID:   room,
+		Sender:   user,
+		StateKey: &stateKey,
+	}, `{"membership": "invite", "is_direct": true}`))
+	if status != http.StatusOK {
+		t.Fatalf("inviting the bot got HTTP %d", status)
+	}
+	return room
+}
+
+// say sends a message from user in room, and returns whatever the bot posted back
+func (hs *fakeHomeserver) say(t *testing.T, botURL, user, room, text string) []string {
+	t.Helper()
+	return hs.sayInTxn(t, botURL, hs.txnID(), user, room, text)
+}
+
+func (hs *fakeHomeserver) sayInTxn(t *testing.T, botURL, txnID, user, room, text string) []string {
+	t.Helper()
+
+	before := len(hs.messagesIn(room))
+	ev := chat.MatrixEvent{Type: "m.room.message", RoomID: room, Sender: user}
+	ev.Content.MsgType = "m.text"
+	ev.Content.Body = text
+	if status := hs.push(t, botURL, txnID, testMatrixHSToken, ev); status != http.StatusOK {
+		t.Fatalf("saying %q got HTTP %d", text, status)
+	}
+	return hs.messagesIn(room)[before:]
+}
+
+func (hs *fakeHomeserver) txnID() string {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.nextTxn++
+	return strconv.Itoa(hs.nextTxn)
+}
+
+// push sends the bot a transaction, like a homeserver does
+func (hs *fakeHomeserver) push(t *testing.T, botURL, txnID, hsToken string, events ...chat.MatrixEvent) int {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("PUT", botURL+"/matrix/events/_matrix/app/v1/transactions/"+txnID, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+hsToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// withContent fills in the event's content from JSON
+func withContent(ev chat.MatrixEvent, content string) chat.MatrixEvent {
+	json.Unmarshal([]byte(content), &ev.Content)
+	return ev
+}
+
+func TestMatrixTransactions(t *testing.T) {
+	ada := "@ada:example.org"
+	hs := newFakeHomeserver(t, map[string]string{ada: "Ada"})
+	bot := newTestBot(t, hs.platform())
+
+	if status := hs.push(t, bot.URL, hs.txnID(), "not-the-token"); status != http.StatusNotFound {
+		t.Errorf("got HTTP %d for a bad homeserver token, wanted 404", status)
+	}
+
+	dm := hs.startDM(t, bot.URL, ada)
+	if got := hs.sayInTxn(t, bot.URL, "retried", ada, dm, "subscribe"); len(got) != 1 || got[0] != commands.SubscribeMessage {
+		t.Fatalf("subscribing got %q", got)
+	}
+	// the homeserver didn't hear back, so it sends the same transaction again
+	if got := hs.sayInTxn(t, bot.URL, "retried", ada, dm, "subscribe"); len(got) != 0 {
+		t.Errorf("a retried transaction was answered again: %q", got)
+	}
+	if got := hs.say(t, bot.URL, ada, dm, "status"); len(got) != 1 || !strings.Contains(got[0], "You're Ada") {
+		t.Errorf("status got %q", got)
+	}
+}
+
+func TestMatrixSubscribeAndMatch(t *testing.T) {
+	ada, bea := "@ada:example.org", "@bea:example.org"
+	hs := newFakeHomeserver(t, map[string]string{ada: "Ada", bea: "Bea"})
+	matrix := hs.platform()
+	bot := newTestBot(t, matrix)
+	today := strings.ToLower(time.Now().Weekday().String())
+
+	for _, u := range []string{ada, bea} {
+		dm := hs.startDM(t, bot.URL, u)
+		hs.say(t, bot.URL, u, dm, "subscribe")
+		hs.say(t, bot.URL, u, dm, "schedule "+today)
+	}
+	if _, err := bot.rdb.GetByUserID(context.Background(), "matrix:"+ada, "", ""); err != nil {
+		t.Errorf("Ada wasn't stored with a matrix ID: %s", err)
+	}
+
+	var ms commands.MatchSummary
+	bot.cron(t, "/match", &ms)
+	if ms.Pairs != 1 || ms.Notifications.Sent != 1 {
+		t.Fatalf("got match summary %+v", ms)
+	}
+	room := hs.roomWith(ada, bea)
+	if got := hs.messagesIn(room); room == "" || len(got) != 1 || got[0] != commands.MatchedMessage {
+		t.Fatalf("Ada and Bea got %q in room %q", got, room)
+	}
+
+	// the bot doesn't answer in the pair's room
+	if got := hs.say(t, bot.URL, ada, room, "help"); len(got) != 0 {
+		t.Errorf("bot answered in the pair's room: %q", got)
+	}
+
+	// follow-ups to the same people go to the same room
+	err := matrix.SendUserMessage(context.Background(), "", bea+","+ada, "how did it go?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.mu.Lock()
+	created := hs.created
+	hs.mu.Unlock()
+	if created != 1 || len(hs.messagesIn(room)) != 2 {
+		t.Errorf("a follow-up opened a new room")
+	}
+}
+
+func TestMatrixForgetMeDropsRooms(t *testing.T) {
+	ctx := context.Background()
+	ada, bea := "@ada:example.org", "@bea:example.org"
+	hs := newFakeHomeserver(t, map[string]string{ada: "Ada", bea: "Bea"})
+	matrix := hs.platform()
+	bot := newTestBot(t, matrix)
+	bot.pl.Rooms = matrix.Rooms
+
+	dm := hs.startDM(t, bot.URL, ada)
+	hs.startDM(t, bot.URL, bea)
+	hs.say(t, bot.URL, ada, dm, "subscribe")
+	err := matrix.SendUserMessage(ctx, "", ada+","+bea, "hi both")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs.say(t, bot.URL, ada, dm, "forget me confirm")
+	for _, members := range []string{ada, ada + "," + bea} {
+		if room, _ := matrix.Rooms.GetRoom(ctx, "matrix", members); room != "" {
+			t.Errorf("the room for %v is still there", members)
+		}
+	}
+	if room, _ := matrix.Rooms.GetRoom(ctx, "matrix", bea); room == "" {
+		t.Error("Bea's room was dropped too")
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const testMetricsToken = "metrics-token"
+
+// getMetrics fetches /metrics with token, if there is one
+func getMetrics(t *testing.T, bot *testBot, token string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest("GET", bot.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestIntegrationMetrics(t *testing.T) {
+	bot := newTestBot(t)
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+
+	bot.pm(t, ada, "subscribe")
+	bot.pm(t, ada, "pair me with someone nice")
+	bot.zulip.postWebhook(t, bot.URL, map[string]interface{}{
+		"data":    "subscribe",
+		"token":   "not-the-token",
+		"trigger": "private_message",
+		"message": map[string]interface{}{"sender_id": 2, "display_recipient": []interface{}{1, 2}},
+	})
+
+	bot.pl.ADB.(*storage.MemoryAPIAuthDB).SetKey("metricsauth", "token", testMetricsToken)
+	status, body := getMetrics(t, bot, testMetricsToken)
+	if status != http.StatusOK {
+		t.Fatalf("/metrics got HTTP %d", status)
+	}
+
+	for _, want := range []string{
+		`pairing_bot_webhooks_total{command="subscribe",outcome="ok",platform="zulip"}`,
+		`pairing_bot_webhooks_total{command="",outcome="rejected",platform="zulip"}`,
+		`pairing_bot_parse_errors_total{platform="zulip"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics doesn't have %v", want)
+		}
+	}
+}
+
+func TestIntegrationMetricsNeedAToken(t *testing.T) {
+	bot := newTestBot(t)
+
+	// nobody gets them until there's a token
+	if status, _ := getMetrics(t, bot, ""); status != http.StatusNotFound {
+		t.Errorf("/metrics without a token set got HTTP %d", status)
+	}
+
+	bot.pl.ADB.(*storage.MemoryAPIAuthDB).SetKey("metricsauth", "token", testMetricsToken)
+	for _, token := range []string{"", "not-the-token"} {
+		if status, body := getMetrics(t, bot, token); status != http.StatusNotFound || strings.Contains(body, "pairing_bot_") {
+			t.Errorf("/metrics with %q got HTTP %d", token, status)
+		}
+	}
+	if status, _ := getMetrics(t, bot, testMetricsToken); status != http.StatusOK {
+		t.Errorf("/metrics with the token got HTTP %d", status)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/commands"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+func TestIntegrationMyDataAndForgetMe(t *testing.T) {
+	bot := newTestBot(t)
+	ctx := context.Background()
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+
+	bot.pm(t, ada, "subscribe")
+	bot.pm(t, bea, "subscribe")
+	bot.pm(t, ada, "schedule monday friday")
+	bot.mdb.Add(ctx, storage.Match{
+		Date:     time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC),
+		IDs:      []string{"2", "3"},
+		Names:    []string{"Ada", "Bea"},
+		Emails:   []string{"ada@example.com", "bea@example.com"},
+		CheckIns: map[string]storage.CheckIn{"2": {Happened: true, Rating: 4}, "3": {Happened: true, Rating: 5}},
+	})
+	bot.pm(t, testAdmin, "admin show ada@example.com")
+
+	// mydata is JSON in a code block, with only Ada's own check-in
+	got := bot.pm(t, ada, "mydata")
+	start, end := strings.Index(got, "```json\n"), strings.LastIndex(got, "\n```")
+	if start < 0 || end < start {
+		t.Fatalf("mydata got %q", got)
+	}
+	var data commands.MyData
+	err := json.Unmarshal([]byte(got[start+len("```json\n"):end]), &data)
+	if err != nil {
+		t.Fatalf("mydata isn't JSON: %s\n%s", err, got)
+	}
+	if !data.Subscribed || data.Recurser == nil || !data.Recurser.Schedule["friday"] || data.Recurser.Schedule["tuesday"] {
+		t.Errorf("mydata got the wrong record: %+v", data.Recurser)
+	}
+	if len(data.Matches) != 1 || data.Matches[0].Partners[0] != "Bea" || data.Matches[0].CheckIn.Rating != 4 {
+		t.Errorf("mydata got the wrong matches: %+v", data.Matches)
+	}
+
+	// forget me on its own only asks
+	if got := bot.pm(t, ada, "forget me"); got != commands.ForgetMeMessage {
+		t.Errorf("forget me got %q", got)
+	}
+	if got := bot.pm(t, ada, "status"); got == commands.NotSubscribedMessage {
+		t.Fatal("Ada was forgotten before they confirmed")
+	}
+
+	if got := bot.pm(t, ada, "forget me confirm"); got != commands.ForgottenMessage {
+		t.Errorf("forget me confirm got %q", got)
+	}
+	if got := bot.pm(t, ada, "status"); got != commands.NotSubscribedMessage {
+		t.Errorf("Ada is still subscribed: %q", got)
+	}
+
+	// Bea still has the match, just not who it was with
+	matches, _ := bot.mdb.ListByUserID(ctx, "3")
+	if len(matches) != 1 {
+		t.Fatalf("Bea has %d matches, wanted 1", len(matches))
+	}
+	m := matches[0]
+	if m.Names[0] != storage.ForgottenName || m.Emails[0] != "" || m.IDs[0] != storage.ForgottenID || m.CheckIns["3"].Rating != 5 {
+		t.Errorf("the match wasn't anonymised: %+v", m)
+	}
+	if _, ok := m.CheckIns["2"]; ok {
+		t.Error("Ada's check-in is still there")
+	}
+	if matches, _ := bot.mdb.ListByUserID(ctx, "2"); len(matches) != 0 {
+		t.Errorf("Ada still has %d matches", len(matches))
+	}
+	for _, e := range bot.pl.Audit.(*storage.MemoryAuditDB).Entries {
+		if strings.Contains(e.Action, "ada") {
+			t.Errorf("the audit log still mentions Ada: %+v", e)
+		}
+	}
+
+	// and there's nothing left to show them
+	if got := bot.pm(t, ada, "mydata"); strings.Contains(got, "Ada") || strings.Contains(got, "ada@") {
+		t.Errorf("mydata after forgetting got %q", got)
+	}
+}
+
+func TestIntegrationForgetMeDropsWhatsWaiting(t *testing.T) {
+	bot := newTestBot(t)
+	ctx := context.Background()
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+
+	bot.pm(t, ada, "subscribe")
+	bot.pm(t, bea, "subscribe")
+	bot.mdb.Add(ctx, storage.Match{
+		Date:   time.Now().Add(-time.Hour),
+		IDs:    []string{"2", "3"},
+		Names:  []string{"Ada", "Bea"},
+		Emails: []string{"ada@example.com", "bea@example.com"},
+	})
+	// their match message, and something for Bea on their own
+	odb := bot.pl.Outbox.ODB
+	for _, to := range []string{"ada@example.com, bea@example.com", "bea@example.com"} {
+		err := odb.Add(ctx, storage.OutboxMessage{Date: time.Now(), To: to, Content: "hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := bot.pm(t, ada, "forget me confirm"); got != commands.ForgottenMessage {
+		t.Fatalf("forget me confirm got %q", got)
+	}
+	msgs, err := odb.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].To != "bea@example.com" {
+		t.Errorf("the outbox has %+v, wanted only Bea's own message", msgs)
+	}
+
+	// only Bea gets asked how their match went
+	var cs chat.NotificationSummary
+	bot.cron(t, "/checkin", &cs)
+	if len(cs.Results) != 1 || cs.Results[0].To != "bea@example.com" || cs.Sent != 1 {
+		t.Errorf("got check-in summary %+v", cs)
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/commands"
+)
+
+func TestIntegrationReminders(t *testing.T) {
+	bot := newTestBot(t)
+	day := commands.NextMatchDay(time.Now())
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+	cat := fakeZulipUser{4, "cat@example.com", "Cat"}
+
+	for _, u := range []fakeZulipUser{ada, bea, cat} {
+		bot.pm(t, u, "subscribe")
+		bot.pm(t, u, "schedule "+day)
+	}
+	for _, u := range []fakeZulipUser{ada, bea} {
+		if got := bot.pm(t, u, "reminders on"); !strings.Contains(got, "**remind you**") {
+			t.Errorf("reminders on got %q", got)
+		}
+	}
+	// Bea is already skipping, and Cat hasn't asked for reminders
+	bot.pm(t, bea, "skip tomorrow")
+
+	// nobody's reminded while matching is paused
+	bot.pm(t, testAdmin, "admin maintenance on")
+	req, err := http.NewRequest("GET", bot.URL+"/remind", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Appengine-Cron", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := bot.zulip.messagesTo(ada.email); len(got) != 0 {
+		t.Errorf("Ada was reminded in maintenance mode: %q", got)
+	}
+	bot.pm(t, testAdmin, "admin maintenance off")
+
+	var rs commands.ReminderSummary
+	bot.cron(t, "/remind", &rs)
+	if rs.Reminded != 1 || rs.Notifications.Sent != 1 {
+		t.Fatalf("got reminder summary %+v", rs)
+	}
+	if got := bot.zulip.messagesTo(ada.email); len(got) != 1 || got[0] != commands.ReminderMessage {
+		t.Errorf("Ada got %q", got)
+	}
+	for _, u := range []fakeZulipUser{bea, cat} {
+		if got := bot.zulip.messagesTo(u.email); len(got) != 0 {
+			t.Errorf("%v was reminded: %q", u.name, got)
+		}
+	}
+
+	bot.pm(t, ada, "reminders off")
+	bot.cron(t, "/remind", &rs)
+	if rs.Reminded != 0 {
+		t.Errorf("reminded %d people after Ada turned reminders off", rs.Reminded)
+	}
+}
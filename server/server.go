@@ -0,0 +1,162 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/commands"
+	"github.com/thwidge/pairing-bot/metrics"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+// Server serves Pairing Bot's endpoints: commands from the chat platforms, and
+// the scheduled jobs that App Engine's cron service triggers
+type Server struct {
+	*commands.PairingLogic
+}
+
+// Routes is every endpoint Pairing Bot serves
+func (s *Server) Routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", http.NotFound)                    // will this handle anything that's not defined?
+	mux.HandleFunc("/webhooks", s.handle)                 // from zulip
+	mux.HandleFunc("/match", s.match)                     // from GCP
+	mux.HandleFunc("/checkin", s.checkin)                 // from GCP
+	mux.HandleFunc("/retryoutbox", s.retryoutbox)         // from GCP
+	mux.HandleFunc("/digest", s.digest)                   // from GCP
+	mux.HandleFunc("/remind", s.remind)                   // from GCP
+	mux.HandleFunc("/endofbatch", s.endofbatch)           // manually triggered
+	mux.HandleFunc("/purgeoffboarded", s.purgeoffboarded) // from GCP
+	mux.HandleFunc("/metrics", s.serveMetrics)            // from prometheus
+	for _, p := range s.Platforms {
+		// some platforms (matrix) add their own path on the end of the URL we give them
+		mux.HandleFunc("/"+p.Name()+"/events", s.handlePlatform(p))  // from slack etc.
+		mux.HandleFunc("/"+p.Name()+"/events/", s.handlePlatform(p)) // from matrix
+	}
+	return mux
+}
+
+// serveMetrics only shows the metrics to requests with the bearer token in
+// metricsauth/token. until there's a token, nobody gets them
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	token, err := s.ADB.GetKey(r.Context(), "metricsauth", "token")
+	if err != nil {
+		log.Printf("Could not read the metrics token: %s\n", err)
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	metricsHandler.ServeHTTP(w, r)
+}
+
+var metricsHandler = metrics.Handler()
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	responder := json.NewEncoder(w)
+
+	// check and authorize the incoming request
+	// observation: we only validate requests for /webhooks, i.e. user input through zulip
+
+	ctx := r.Context()
+	err := s.UR.ValidateJSON(r)
+	if err != nil {
+		metrics.Webhook(storage.ZulipPlatform, "", metrics.OutcomeRejected)
+		http.NotFound(w, r)
+		return
+	}
+
+	botAuth, err := s.ADB.GetKey(ctx, "botauth", "token")
+	if err != nil {
+		log.Println("Something weird happened trying to read the auth token from the database")
+	}
+
+	if !s.UR.ValidateAuthCreds(botAuth) {
+		metrics.Webhook(storage.ZulipPlatform, "", metrics.OutcomeRejected)
+		http.NotFound(w, r)
+		return
+	}
+
+	intro := s.UR.ValidateInteractionType()
+	if intro != nil {
+		metrics.Webhook(storage.ZulipPlatform, "", metrics.OutcomeIgnored)
+		err = responder.Encode(intro)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	ignore := s.UR.IgnoreInteractionType()
+	if ignore != nil {
+		metrics.Webhook(storage.ZulipPlatform, "", metrics.OutcomeIgnored)
+		err = responder.Encode(ignore)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	userData := s.UR.ExtractUserData()
+
+	// you *should* be able to throw any string at this thing and get back a valid command for dispatch()
+	// if there are no commad arguments, cmdArgs will be nil
+	cmd, cmdArgs, err := commands.ParseCmd(s.UR.UserInput())
+	if err != nil {
+		log.Println(err)
+		metrics.ParseError(storage.ZulipPlatform)
+	}
+
+	response, err := s.Respond(ctx, userData, cmd, cmdArgs)
+	metrics.Webhook(storage.ZulipPlatform, cmd, outcome(err))
+	err = responder.Encode(chat.BotResponse{Message: response})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// handlePlatform serves the endpoint a chat platform sends its events to
+func (s *Server) handlePlatform(p chat.Platform) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cmds, err := p.ReadCommands(w, r)
+		if err != nil {
+			metrics.Webhook(p.Name(), "", metrics.OutcomeRejected)
+			log.Printf("Bad request from %s: %s\n", p.Name(), err)
+			http.NotFound(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		for _, cmd := range cmds {
+			parsed, cmdArgs, err := commands.ParseCmd(cmd.Text)
+			if err != nil {
+				log.Println(err)
+				metrics.ParseError(p.Name())
+			}
+
+			response, err := s.Respond(ctx, &cmd.User, parsed, cmdArgs)
+			metrics.Webhook(p.Name(), parsed, outcome(err))
+			err = p.Reply(ctx, cmd, response)
+			if err != nil {
+				log.Printf("Could not reply on %s: %s\n", p.Name(), err)
+			}
+		}
+	}
+}
+
+// outcome is how Respond went, for metrics.Webhook
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return metrics.OutcomeOK
+	case err == commands.ErrMaintenance:
+		return metrics.OutcomeMaintenance
+	default:
+		return metrics.OutcomeError
+	}
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/commands"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const (
+	testBotUsername  = "pairing-bot@example.zulipchat.com"
+	testBotPassword  = "api-key"
+	testWebhookToken = "webhook-token"
+)
+
+// testBot is a whole Pairing Bot, wired up the way main() does it, but with
+// in-memory databases and a fake Zulip instead of the real ones
+type testBot struct {
+	*httptest.Server
+	zulip *fakeZulip
+	rdb   *storage.MemoryRecurserDB
+	mdb   *storage.MemoryMatchDB
+	pl    *commands.PairingLogic
+	// tests can set up email on this, before they send the bot anything
+	notify *chat.PlatformNotification
+}
+
+// newTestBot starts a bot on Zulip, and on any other platforms given
+func newTestBot(t *testing.T, platforms ...chat.Platform) *testBot {
+	zulip := newFakeZulip(t, testBotUsername, testBotPassword, testWebhookToken)
+
+	adb := storage.NewMemoryAPIAuthDB()
+	adb.SetKey("botauth", "token", testWebhookToken)
+	adb.SetKey("apiauth", "key", testBotPassword)
+
+	zun := &chat.ZulipUserNotification{
+		BotUsername: testBotUsername,
+		ZulipAPIURL: zulip.messagesURL(),
+		MaxRetries:  1,
+		BaseBackoff: time.Millisecond,
+	}
+	pun := &chat.PlatformNotification{
+		Zulip:     zun,
+		Platforms: map[string]chat.Platform{},
+	}
+	for _, p := range platforms {
+		pun.Platforms[p.Name()] = p
+	}
+	un := &chat.OutboxUserNotification{
+		UN:  pun,
+		ODB: storage.NewMemoryOutboxDB(),
+	}
+
+	bot := &testBot{
+		zulip:  zulip,
+		rdb:    storage.NewMemoryRecurserDB(),
+		mdb:    storage.NewMemoryMatchDB(),
+		notify: pun,
+	}
+	pl := &commands.PairingLogic{
+		RDB:       bot.rdb,
+		ADB:       adb,
+		MDB:       bot.mdb,
+		CDB:       storage.NewMemoryConfigDB(),
+		Audit:     storage.NewMemoryAuditDB(),
+		UR:        &chat.ZulipUserRequest{},
+		UN:        un,
+		Streams:   zun,
+		Outbox:    un,
+		Admins:    []string{"1"},
+		Platforms: platforms,
+	}
+	bot.pl = pl
+	bot.Server = httptest.NewServer((&Server{PairingLogic: pl}).Routes())
+	t.Cleanup(bot.Close)
+	return bot
+}
+
+// cron triggers one of the scheduled jobs, like App Engine's cron service does
+func (b *testBot) cron(t *testing.T, path string, summary interface{}) {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", b.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Appengine-Cron", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s answered with HTTP %d", path, resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(summary)
+	if err != nil {
+		t.Fatalf("%s didn't answer with a JSON summary: %s", path, err)
+	}
+}
+
+func (b *testBot) pm(t *testing.T, from fakeZulipUser, content string) string {
+	t.Helper()
+	return b.zulip.sendPM(t, b.URL, from, content)
+}
+
+// the admin every test bot has
+var testAdmin = fakeZulipUser{1, "admin@example.com", "Admin"}
+
+// endOfBatch makes an end-of-batch plan the way the cron job does, has the
+// admin confirm it, and returns how that went
+func (b *testBot) endOfBatch(t *testing.T) commands.EndOfBatchSummary {
+	t.Helper()
+
+	var ps commands.EndOfBatchPlanSummary
+	b.cron(t, "/endofbatch", &ps)
+	plan, err := b.pl.CDB.GetEndOfBatchPlan(context.Background())
+	if err != nil || plan.Code != ps.Code {
+		t.Fatalf("the end-of-batch plan %q wasn't saved: %+v, %v", ps.Code, plan, err)
+	}
+	summary, err := b.pl.RunEndOfBatch(context.Background(), plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary
+}
+
+func TestIntegrationSubscribeToEndOfBatch(t *testing.T) {
+	bot := newTestBot(t)
+	today := strings.ToLower(time.Now().Weekday().String())
+	notToday := strings.ToLower(time.Now().AddDate(0, 0, 1).Weekday().String())
+
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+	cat := fakeZulipUser{4, "cat@example.com", "Cat"}
+	dan := fakeZulipUser{5, "dan@example.com", "Dan"}
+
+	// subscribe
+	for _, u := range []fakeZulipUser{ada, bea, cat, dan} {
+		if got := bot.pm(t, u, "subscribe"); got != commands.SubscribeMessage {
+			t.Fatalf("%s subscribed and got %q", u.name, got)
+		}
+	}
+	if got := bot.pm(t, ada, "subscribe"); !strings.Contains(got, "already subscribed") {
+		t.Errorf("subscribing twice got %q", got)
+	}
+
+	// schedule: Ada, Bea and Cat pair today, Dan doesn't
+	for _, u := range []fakeZulipUser{ada, bea, cat} {
+		if got := bot.pm(t, u, "schedule "+today); !strings.Contains(got, "new schedule's been set") {
+			t.Fatalf("%s set their schedule and got %q", u.name, got)
+		}
+	}
+	bot.pm(t, dan, "schedule "+notToday)
+
+	got := bot.pm(t, ada, "status")
+	if !strings.Contains(got, "You're Ada") || !strings.Contains(got, strings.ToUpper(today[:1])+today[1:]+"s") {
+		t.Errorf("status got %q", got)
+	}
+
+	// match: three people in the match-set, so one pair and one odd one out
+	var ms commands.MatchSummary
+	bot.cron(t, "/match", &ms)
+	if ms.Pairs != 1 || ms.OddOneOuts != 1 || ms.Notifications.Sent != 2 || ms.Notifications.Failed != 0 {
+		t.Fatalf("got match summary %+v", ms)
+	}
+
+	var oddOneOut, partners []fakeZulipUser
+	for _, u := range []fakeZulipUser{ada, bea, cat} {
+		if msgs := bot.zulip.messagesTo(u.email); len(msgs) == 1 && msgs[0] == commands.OddOneOutMessage {
+			oddOneOut = append(oddOneOut, u)
+		} else {
+			partners = append(partners, u)
+		}
+	}
+	if len(oddOneOut) != 1 || len(partners) != 2 {
+		t.Fatalf("got odd one out %v and partners %v", oddOneOut, partners)
+	}
+	if msgs := bot.zulip.messagesTo(partners[0].email + ", " + partners[1].email); len(msgs) != 1 || msgs[0] != commands.MatchedMessage {
+		t.Errorf("%s and %s got %q", partners[0].name, partners[1].name, msgs)
+	}
+	if msgs := bot.zulip.messagesTo(dan.email); len(msgs) != 0 {
+		t.Errorf("Dan wasn't scheduled today but got %q", msgs)
+	}
+	if got := bot.pm(t, oddOneOut[0], "status"); !strings.Contains(got, "last the odd one out") {
+		t.Errorf("the odd one out's status doesn't say so: %q", got)
+	}
+
+	// check in
+	var cs chat.NotificationSummary
+	bot.cron(t, "/checkin", &cs)
+	if cs.Sent != 2 {
+		t.Errorf("got check-in summary %+v", cs)
+	}
+	if got := bot.pm(t, partners[0], "rate 5"); !strings.Contains(got, "5/5") {
+		t.Errorf("rating the session got %q", got)
+	}
+	if got := bot.pm(t, partners[0], "history"); !strings.Contains(got, "| "+partners[1].name+" | paired, 5/5 |") {
+		t.Errorf("history got %q", got)
+	}
+	if got := bot.pm(t, dan, "yes"); got != commands.NoRecentMatchMessage {
+		t.Errorf("checking in without a match got %q", got)
+	}
+
+	// batches: Ada and Bea's ended yesterday, Cat's goes on, and we don't know Dan's
+	yesterday := time.Now().AddDate(0, 0, -1).Format(storage.BatchDateLayout)
+	for _, u := range []fakeZulipUser{ada, bea} {
+		if got := bot.pm(t, u, "batch W1'24 "+yesterday); !strings.Contains(got, "You're in batch **W1'24**") {
+			t.Errorf("%s set their batch and got %q", u.name, got)
+		}
+	}
+	bot.pm(t, cat, "batch SP1'24 "+time.Now().AddDate(0, 1, 0).Format(storage.BatchDateLayout))
+
+	// end of batch: Ada and Bea are offboarded, and Cat and Dan are asked whether they're staying
+	es := bot.endOfBatch(t)
+	if es.Offboarded != 2 || es.Prompted != 2 || es.Notifications.Sent != 4 {
+		t.Errorf("got end-of-batch summary %+v", es)
+	}
+	for _, u := range []fakeZulipUser{ada, bea} {
+		msgs := bot.zulip.messagesTo(u.email)
+		if len(msgs) == 0 || msgs[len(msgs)-1] != commands.BatchEndedMessage {
+			t.Errorf("%s wasn't told they were offboarded: %q", u.name, msgs)
+		}
+		if got := bot.pm(t, u, "status"); got != commands.NotSubscribedMessage {
+			t.Errorf("%s is still subscribed after end of batch: %q", u.name, got)
+		}
+	}
+	for _, u := range []fakeZulipUser{cat, dan} {
+		msgs := bot.zulip.messagesTo(u.email)
+		if len(msgs) == 0 || msgs[len(msgs)-1] != commands.StayPromptMessage {
+			t.Errorf("%s wasn't asked to stay: %q", u.name, msgs)
+		}
+		if got := bot.pm(t, u, "status"); !strings.Contains(got, "Reply `stay`") {
+			t.Errorf("%s's status doesn't say they were asked to stay: %q", u.name, got)
+		}
+	}
+
+	// Cat says they're staying and Dan doesn't answer, so at the next end of batch Dan's offboarded
+	if got := bot.pm(t, cat, "stay"); got != commands.StayMessage {
+		t.Errorf("stay got %q", got)
+	}
+	es = bot.endOfBatch(t)
+	if es.Offboarded != 1 || es.Prompted != 1 {
+		t.Errorf("got end-of-batch summary %+v", es)
+	}
+	if msgs := bot.zulip.messagesTo(dan.email); len(msgs) == 0 || msgs[len(msgs)-1] != commands.NoStayMessage {
+		t.Errorf("Dan wasn't told they were offboarded: %q", msgs)
+	}
+	if got := bot.pm(t, cat, "status"); !strings.Contains(got, "You're in batch **SP1'24**") {
+		t.Errorf("Cat lost their settings: %q", got)
+	}
+}
+
+func TestIntegrationRejectsBadRequests(t *testing.T) {
+	bot := newTestBot(t)
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+
+	// a webhook with the wrong token
+	status, _ := bot.zulip.postWebhook(t, bot.URL, map[string]interface{}{
+		"data":    "subscribe",
+		"token":   "not-the-token",
+		"trigger": "private_message",
+		"message": map[string]interface{}{"sender_id": 2, "display_recipient": []interface{}{1, 2}},
+	})
+	if status != http.StatusNotFound {
+		t.Errorf("got HTTP %d for a bad token, wanted 404", status)
+	}
+	if got := bot.pm(t, ada, "status"); got != commands.NotSubscribedMessage {
+		t.Errorf("the request with a bad token subscribed someone: %q", got)
+	}
+
+	// cron jobs that don't come from cron
+	resp, err := http.Get(bot.URL + "/match")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got HTTP %d for /match without the cron header, wanted 404", resp.StatusCode)
+	}
+
+	// a bot with the wrong API key can't send anything
+	today := strings.ToLower(time.Now().Weekday().String())
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+	for _, u := range []fakeZulipUser{ada, bea} {
+		bot.pm(t, u, "subscribe")
+		bot.pm(t, u, "schedule "+today)
+	}
+
+	bot.zulip.mu.Lock()
+	bot.zulip.botPassword = "rotated"
+	bot.zulip.mu.Unlock()
+
+	var ms commands.MatchSummary
+	bot.cron(t, "/match", &ms)
+	if ms.Pairs != 1 || ms.Notifications.Sent != 0 || ms.Notifications.Failed != 1 || bot.zulip.sentCount() != 0 {
+		t.Errorf("messages went out with the wrong API key: %+v", ms)
+	}
+}
+
+func TestIntegrationBrokenMatchesAreSkipped(t *testing.T) {
+	bot := newTestBot(t)
+	ctx := context.Background()
+	today := strings.ToLower(time.Now().Weekday().String())
+	ada := fakeZulipUser{2, "ada@example.com", "Ada"}
+	bea := fakeZulipUser{3, "bea@example.com", "Bea"}
+
+	for _, u := range []fakeZulipUser{ada, bea} {
+		bot.pm(t, u, "subscribe")
+		bot.pm(t, u, "schedule "+today)
+	}
+	// matches nothing can make sense of, like a hand-edited document
+	bot.mdb.Add(ctx, storage.Match{Date: time.Now().Add(-time.Hour), IDs: []string{"2"}, Names: []string{"Ada"}, Emails: []string{"ada@example.com"}})
+	bot.mdb.Add(ctx, storage.Match{Date: time.Now().Add(-time.Hour), IDs: []string{"2", "3"}, Names: []string{"Ada"}, Emails: []string{"ada@example.com"}})
+
+	var ms commands.MatchSummary
+	bot.cron(t, "/match", &ms)
+	if ms.Pairs != 1 {
+		t.Fatalf("got match summary %+v", ms)
+	}
+	// only the match that was just made gets a check-in
+	var cs chat.NotificationSummary
+	bot.cron(t, "/checkin", &cs)
+	if cs.Sent != 2 || cs.Failed != 0 {
+		t.Errorf("got check-in summary %+v", cs)
+	}
+	if matches, _ := bot.mdb.ListByUserID(ctx, "2"); len(matches) != 1 {
+		t.Errorf("Ada has %d matches, wanted only the good one", len(matches))
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thwidge/pairing-bot/chat"
+	"github.com/thwidge/pairing-bot/commands"
+	"github.com/thwidge/pairing-bot/storage"
+)
+
+const (
+	testSlackBotToken      = "xoxb-test"
+	testSlackSigningSecret = "signing-secret"
+)
+
+// fakeSlack stands in for Slack's Web API. It knows about a handful of users,
+// opens DMs and group DMs for the bot, and remembers every message posted in
+// them. It can also send Events API requests to the bot, signed like Slack does.
+type fakeSlack struct {
+	*httptest.Server
+
+	users map[string]fakeSlackUser
+
+	mu sync.Mutex
+	// channel ID -> the users in it, comma-separated
+	channels map[string]string
+	posted   []sentMessage
+}
+
+type fakeSlackUser struct {
+	id    string
+	email string
+	name  string
+}
+
+func newFakeSlack(t *testing.T, users ...fakeSlackUser) *fakeSlack {
+	s := &fakeSlack{
+		users:    map[string]fakeSlackUser{},
+		channels: map[string]string{},
+	}
+	for _, u := range users {
+		s.users[u.id] = u
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/users.info", s.api(s.usersInfo))
+	mux.HandleFunc("/api/conversations.open", s.api(s.conversationsOpen))
+	mux.HandleFunc("/api/chat.postMessage", s.api(s.chatPostMessage))
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// platform is a SlackPlatform pointed at this fake
+func (s *fakeSlack) platform() *chat.SlackPlatform {
+	adb := storage.NewMemoryAPIAuthDB()
+	adb.SetKey("slackauth", "bottoken", testSlackBotToken)
+	adb.SetKey("slackauth", "signingsecret", testSlackSigningSecret)
+	return &chat.SlackPlatform{
+		APIURL:      s.URL + "/api",
+		ADB:         adb,
+		MaxRetries:  1,
+		BaseBackoff: time.Millisecond,
+	}
+}
+
+// api checks the bot token and answers with whatever the method returns, the
+// way the Web API does: always HTTP 200, with "ok" saying whether it worked
+func (s *fakeSlack) api(method func(r *http.Request) (map[string]interface{}, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var resp map[string]interface{}
+		errCode := ""
+		if r.Header.Get("Authorization") != "Bearer "+testSlackBotToken {
+			errCode = "invalid_auth"
+		} else {
+			resp, errCode = method(r)
+		}
+
+		if errCode != "" {
+			resp = map[string]interface{}{"ok": false, "error": errCode}
+		} else {
+			resp["ok"] = true
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func (s *fakeSlack) usersInfo(r *http.Request) (map[string]interface{}, string) {
+	u, ok := s.users[r.PostFormValue("user")]
+	if !ok {
+		return nil, "user_not_found"
+	}
+	return map[string]interface{}{
+		"user": map[string]interface{}{
+			"id":        u.id,
+			"real_name": u.name,
+			"profile":   map[string]interface{}{"email": u.email},
+		},
+	}, ""
+}
+
+func (s *fakeSlack) conversationsOpen(r *http.Request) (map[string]interface{}, string) {
+	ids := strings.Split(r.PostFormValue("users"), ",")
+	for _, id := range ids {
+		if _, ok := s.users[id]; !ok {
+			return nil, "user_not_found"
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel := "D" + strconv.Itoa(len(s.channels)+1)
+	if len(ids) > 1 {
+		channel = "G" + strconv.Itoa(len(s.channels)+1)
+	}
+	s.channels[channel] = r.PostFormValue("users")
+	return map[string]interface{}{"channel": map[string]interface{}{"id": channel}}, ""
+}
+
+func (s *fakeSlack) chatPostMessage(r *http.Request) (map[string]interface{}, string) {
+	channel, text := r.PostFormValue("channel"), r.PostFormValue("text")
+	if text == "" {
+		return nil, "no_text"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.channels[channel]; !ok {
+		return nil, "channel_not_found"
+	}
+	s.posted = append(s.posted, sentMessage{to: channel, content: text})
+	return map[string]interface{}{"channel": channel}, ""
+}
+
+// messagesTo returns the content of every message posted in the conversation
+// between the bot and exactly these users
+func (s *fakeSlack) messagesTo(users ...string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var contents []string
+	for _, m := range s.posted {
+		if sameRecipients(s.channels[m.to], strings.Join(users, ",")) {
+			contents = append(contents, m.content)
+		}
+	}
+	return contents
+}
+
+// dm tells the bot that user sent it a DM, like the Events API does, and
+// returns what the bot posted back
+func (s *fakeSlack) dm(t *testing.T, botURL string, from fakeSlackUser, text string) string {
+	t.Helper()
+
+	s.mu.Lock()
+	channel := "D-" + from.id
+	s.channels[channel] = from.id
+	before := len(s.posted)
+	s.mu.Unlock()
+
+	status, body := s.postEvent(t, botURL, map[string]interface{}{
+		"type": "event_callback",
+		"event": map[string]interface{}{
+			"type":         "message",
+			"channel_type": "im",
+			"channel":      channel,
+			"user":         from.id,
+			"text":         text,
+		},
+	}, time.Now(), testSlackSigningSecret)
+	if status != http.StatusOK {
+		t.Fatalf("bot answered %q with HTTP %d: %s", text, status, body)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.posted) != before+1 || s.posted[before].to != channel {
+		t.Fatalf("bot didn't reply to %q in the DM", text)
+	}
+	return s.posted[before].content
+}
+
+// postEvent signs an Events API payload with secret as of signedAt, and sends it to the bot
+func (s *fakeSlack) postEvent(t *testing.T, botURL string, payload interface{}, signedAt time.Time, secret string) (int, []byte) {
+	t.Helper()
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", botURL+"/slack/events", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := strconv.FormatInt(signedAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, b)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, buf.Bytes()
+}
+
+func TestSlackVerifiesRequests(t *testing.T) {
+	slack := newFakeSlack(t)
+	bot := newTestBot(t, slack.platform())
+	challenge := map[string]interface{}{"type": "url_verification", "challenge": "3eZbrw1aBm2rZgRNFdxV"}
+
+	tests := []struct {
+		name     string
+		signedAt time.Time
+		secret   string
+		status   int
+	}{
+		{"good", time.Now(), testSlackSigningSecret, http.StatusOK},
+		{"wrong secret", time.Now(), "not-the-secret", http.StatusNotFound},
+		{"replayed", time.Now().Add(-10 * time.Minute), testSlackSigningSecret, http.StatusNotFound},
+		{"from the future", time.Now().Add(10 * time.Minute), testSlackSigningSecret, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		status, body := slack.postEvent(t, bot.URL, challenge, tt.signedAt, tt.secret)
+		if status != tt.status {
+			t.Errorf("%s: got HTTP %d, wanted %d", tt.name, status, tt.status)
+		}
+		if status == http.StatusOK && string(body) != "3eZbrw1aBm2rZgRNFdxV" {
+			t.Errorf("%s: got %q back for the challenge", tt.name, body)
+		}
+	}
+
+	// an unsigned request
+	resp, err := http.Post(bot.URL+"/slack/events", "application/json", strings.NewReader(`{"type": "url_verification", "challenge": "x"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got HTTP %d for an unsigned request, wanted 404", resp.StatusCode)
+	}
+}
+
+func TestSlackSubscribeAndMatch(t *testing.T) {
+	ada := fakeSlackUser{"U01", "ada@example.com", "Ada"}
+	bea := fakeSlackUser{"U02", "bea@example.com", "Bea"}
+	slack := newFakeSlack(t, ada, bea)
+	bot := newTestBot(t, slack.platform())
+	today := strings.ToLower(time.Now().Weekday().String())
+
+	for _, u := range []fakeSlackUser{ada, bea} {
+		if got := slack.dm(t, bot.URL, u, "subscribe"); got != chat.SlackMarkdown(commands.SubscribeMessage) {
+			t.Fatalf("%s subscribed and got %q", u.name, got)
+		}
+		slack.dm(t, bot.URL, u, "schedule "+today)
+	}
+	if got := slack.dm(t, bot.URL, ada, "status"); !strings.Contains(got, "You're Ada") {
+		t.Errorf("status got %q", got)
+	}
+	if _, err := bot.rdb.GetByUserID(context.Background(), "slack:U01", "", ""); err != nil {
+		t.Errorf("Ada wasn't stored with a slack ID: %s", err)
+	}
+
+	// Cat is on Zulip, so they can't be paired with anyone on Slack
+	cat := fakeZulipUser{4, "cat@example.com", "Cat"}
+	bot.pm(t, cat, "subscribe")
+	bot.pm(t, cat, "schedule "+today)
+
+	var ms commands.MatchSummary
+	bot.cron(t, "/match", &ms)
+	if ms.Pairs != 1 || ms.OddOneOuts != 1 || ms.Notifications.Sent != 2 || ms.Notifications.Failed != 0 {
+		t.Fatalf("got match summary %+v", ms)
+	}
+	if msgs := slack.messagesTo(ada.id, bea.id); len(msgs) != 1 || msgs[0] != commands.MatchedMessage {
+		t.Errorf("Ada and Bea got %q in their group DM", msgs)
+	}
+	if msgs := bot.zulip.messagesTo(cat.email); len(msgs) != 1 || msgs[0] != commands.OddOneOutMessage {
+		t.Errorf("Cat got %q", msgs)
+	}
+
+	// the bot doesn't answer in group DMs, or to itself
+	status, _ := slack.postEvent(t, bot.URL, map[string]interface{}{
+		"type":  "event_callback",
+		"event": map[string]interface{}{"type": "message", "channel_type": "mpim", "channel": "G1", "user": ada.id, "text": "help"},
+	}, time.Now(), testSlackSigningSecret)
+	if status != http.StatusOK || len(slack.messagesTo(ada.id, bea.id)) != 1 {
+		t.Errorf("bot answered in a group DM")
+	}
+}
+
+func TestPlatformNotificationRoutes(t *testing.T) {
+	ada := fakeSlackUser{"U01", "ada@example.com", "Ada"}
+	slack := newFakeSlack(t, ada)
+	zulip := newFakeZulip(t, testBotUsername, testBotPassword, testWebhookToken)
+	pn := &chat.PlatformNotification{
+		Zulip:     &chat.ZulipUserNotification{BotUsername: testBotUsername, ZulipAPIURL: zulip.messagesURL()},
+		Platforms: map[string]chat.Platform{chat.SlackPlatformName: slack.platform()},
+	}
+
+	tests := []struct {
+		to      string
+		wantErr bool
+	}{
+		{"bea@example.com", false},
+		{"slack:U01", false},
+		{"bea@example.com, slack:U01", true},
+		{"discord:123", true},
+	}
+	for _, tt := range tests {
+		err := pn.SendUserMessage(context.Background(), testBotPassword, tt.to, "hi")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("sending to %q: got error %v", tt.to, err)
+		}
+	}
+	if len(zulip.messagesTo("bea@example.com")) != 1 || len(slack.messagesTo(ada.id)) != 1 {
+		t.Errorf("messages went to the wrong place")
+	}
+}
@@ -0,0 +1,583 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backups are NDJSON: one JSON object per line, each with a "type" and its
+// "data". the first line is always the header, which says which version of
+// the format the rest of the file is in. then come the config, recursers,
+// offboarded recursers and matches, like:
+// {"type": "header", "data": {"format": "pairing-bot-backup", "version": 1, "exported": "2024-03-29T12:00:00Z"}}
+// {"type": "recurser", "data": {"id": "215391", "name": "Ada", ...}}
+//
+// everything is read and written through RecurserDB, MatchDB and ConfigDB,
+// so a backup from one backend can be imported into any other
+
+const backupFormat = "pairing-bot-backup"
+
+// bump this whenever the format changes in a way older versions can't read,
+// and teach ImportBackup to read the old versions
+const backupVersion = 1
+
+// stop listing problems with a file after this many
+const maxBackupErrors = 20
+
+type backupHeader struct {
+	Format   string    `json:"format"`
+	Version  int       `json:"version"`
+	Exported time.Time `json:"exported"`
+}
+
+// the field names are the same as in Firestore
+type BackupRecurser struct {
+	ID                 string          `json:"id"`
+	Name               string          `json:"name"`
+	Email              string          `json:"email"`
+	IsSkippingTomorrow bool            `json:"isSkippingTomorrow"`
+	Schedule           map[string]bool `json:"schedule"`
+	LastOddOneOut      *time.Time      `json:"lastOddOneOut,omitempty"`
+	NotifyVia          string          `json:"notifyVia"`
+	Announce           bool            `json:"announce"`
+	Digest             bool            `json:"digest"`
+	TriedCommands      []string        `json:"triedCommands,omitempty"`
+	Reminders          bool            `json:"reminders"`
+	Batch              string          `json:"batch,omitempty"`
+	BatchEnd           *time.Time      `json:"batchEnd,omitempty"`
+	AskedToStay        bool            `json:"askedToStay"`
+}
+
+// BackupOffboarded is an "offboarded" document in a backup
+type BackupOffboarded struct {
+	BackupRecurser
+	OffboardedAt   time.Time `json:"offboardedAt"`
+	OffboardReason string    `json:"offboardReason"`
+}
+
+type backupMatch struct {
+	Date        time.Time                `json:"date"`
+	IDs         []string                 `json:"ids"`
+	Names       []string                 `json:"names"`
+	Emails      []string                 `json:"emails"`
+	CheckInSent bool                     `json:"checkInSent"`
+	CheckIns    map[string]BackupCheckIn `json:"checkIns,omitempty"`
+}
+
+// BackupCheckIn is one person's check-in on a match in a backup
+type BackupCheckIn struct {
+	Happened bool `json:"happened"`
+	Rating   int  `json:"rating"`
+}
+
+type backupConfig struct {
+	Maintenance struct {
+		Enabled      bool     `json:"enabled"`
+		Message      string   `json:"message"`
+		AllowedUsers []string `json:"allowedUsers"`
+	} `json:"maintenance"`
+	Announcements struct {
+		Enabled  bool   `json:"enabled"`
+		Stream   string `json:"stream"`
+		Topic    string `json:"topic"`
+		Template string `json:"template"`
+	} `json:"announcements"`
+}
+
+type backupLine struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// BackupSummary is what an export or import did (or, for a dry run, would do)
+type BackupSummary struct {
+	Recursers int
+	// recursers who were already in the database, and were replaced
+	Replaced   int
+	Offboarded int
+	// offboarded recursers who are subscribed again, so were left out
+	SkippedOffboarded int
+	Matches           int
+	// matches that were already in the database
+	DuplicateMatches int
+	Config           bool
+}
+
+// String is the summary as a sentence, for the logs and pairingbotctl
+func (s BackupSummary) String() string {
+	out := fmt.Sprintf("%d recursers (%d replaced), %d offboarded (%d skipped), %d matches (%d duplicates skipped)",
+		s.Recursers, s.Replaced, s.Offboarded, s.SkippedOffboarded, s.Matches, s.DuplicateMatches)
+	if s.Config {
+		out += ", and the config"
+	}
+	return out
+}
+
+// ToBackupRecurser is r as it's written in a backup, and in `mydata`.
+// times that were never set are left out
+func ToBackupRecurser(r Recurser) BackupRecurser {
+	b := BackupRecurser{
+		ID:                 r.ID,
+		Name:               r.Name,
+		Email:              r.Email,
+		IsSkippingTomorrow: r.IsSkippingTomorrow,
+		Schedule:           map[string]bool{},
+		NotifyVia:          r.NotifyVia,
+		Announce:           r.Announce,
+		Digest:             r.Digest,
+		TriedCommands:      r.TriedCommands,
+		Reminders:          r.Reminders,
+		Batch:              r.Batch,
+		AskedToStay:        r.AskedToStay,
+	}
+	for day, on := range r.Schedule {
+		b.Schedule[day], _ = on.(bool)
+	}
+	if !r.LastOddOneOut.IsZero() {
+		b.LastOddOneOut = &r.LastOddOneOut
+	}
+	if !r.BatchEnd.IsZero() {
+		b.BatchEnd = &r.BatchEnd
+	}
+	return b
+}
+
+func (b BackupRecurser) toRecurser() Recurser {
+	r := Recurser{
+		ID:                 b.ID,
+		Name:               b.Name,
+		Email:              b.Email,
+		IsSkippingTomorrow: b.IsSkippingTomorrow,
+		Schedule:           map[string]interface{}{},
+		NotifyVia:          b.NotifyVia,
+		Announce:           b.Announce,
+		Digest:             b.Digest,
+		TriedCommands:      b.TriedCommands,
+		Reminders:          b.Reminders,
+		Batch:              b.Batch,
+		AskedToStay:        b.AskedToStay,
+	}
+	for _, day := range Weekdays() {
+		r.Schedule[day] = b.Schedule[day]
+	}
+	if b.LastOddOneOut != nil {
+		r.LastOddOneOut = *b.LastOddOneOut
+	}
+	if b.BatchEnd != nil {
+		r.BatchEnd = *b.BatchEnd
+	}
+	return r
+}
+
+func toBackupMatch(m Match) backupMatch {
+	b := backupMatch{
+		Date:        m.Date,
+		IDs:         m.IDs,
+		Names:       m.Names,
+		Emails:      m.Emails,
+		CheckInSent: m.CheckInSent,
+	}
+	if len(m.CheckIns) > 0 {
+		b.CheckIns = map[string]BackupCheckIn{}
+		for id, c := range m.CheckIns {
+			b.CheckIns[id] = BackupCheckIn{c.Happened, c.Rating}
+		}
+	}
+	return b
+}
+
+func (b backupMatch) toMatch() Match {
+	m := Match{
+		Date:        b.Date,
+		IDs:         b.IDs,
+		Names:       b.Names,
+		Emails:      b.Emails,
+		CheckInSent: b.CheckInSent,
+		CheckIns:    map[string]CheckIn{},
+	}
+	for id, c := range b.CheckIns {
+		m.CheckIns[id] = CheckIn{c.Happened, c.Rating}
+	}
+	return m
+}
+
+// matchKey tells matches apart without their IDs, which are different in every database.
+// Firestore only keeps microseconds
+func matchKey(date time.Time, ids []string) string {
+	return date.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano) + " " + strings.Join(ids, ",")
+}
+
+// Weekdays is every day someone can schedule, starting on monday
+func Weekdays() []string {
+	var days []string
+	for i := 1; i <= 7; i++ {
+		days = append(days, strings.ToLower(time.Weekday(i%7).String()))
+	}
+	return days
+}
+
+// ExportBackup writes everything in the databases to w
+func ExportBackup(ctx context.Context, rdb RecurserDB, mdb MatchDB, cdb ConfigDB, w io.Writer) (BackupSummary, error) {
+	var summary BackupSummary
+	enc := json.NewEncoder(w)
+	write := func(kind string, data interface{}) error {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(backupLine{kind, raw})
+	}
+
+	err := write("header", backupHeader{backupFormat, backupVersion, time.Now().UTC()})
+	if err != nil {
+		return summary, err
+	}
+
+	maintenance, err := cdb.GetMaintenance(ctx)
+	if err != nil {
+		return summary, err
+	}
+	announcements, err := cdb.GetAnnouncements(ctx)
+	if err != nil {
+		return summary, err
+	}
+	var config backupConfig
+	config.Maintenance.Enabled = maintenance.Enabled
+	config.Maintenance.Message = maintenance.Message
+	config.Maintenance.AllowedUsers = maintenance.AllowedUsers
+	config.Announcements.Enabled = announcements.Enabled
+	config.Announcements.Stream = announcements.Stream
+	config.Announcements.Topic = announcements.Topic
+	config.Announcements.Template = announcements.Template
+	err = write("config", config)
+	if err != nil {
+		return summary, err
+	}
+	summary.Config = true
+
+	recursersList, err := rdb.GetAllUsers(ctx)
+	if err != nil {
+		return summary, err
+	}
+	for _, r := range recursersList {
+		err = write("recurser", ToBackupRecurser(r))
+		if err != nil {
+			return summary, err
+		}
+		summary.Recursers++
+	}
+
+	offboarded, err := rdb.ListOffboarded(ctx)
+	if err != nil {
+		return summary, err
+	}
+	for _, o := range offboarded {
+		err = write("offboarded", BackupOffboarded{ToBackupRecurser(o.Recurser), o.OffboardedAt, o.Reason})
+		if err != nil {
+			return summary, err
+		}
+		summary.Offboarded++
+	}
+
+	matches, err := mdb.ListSince(ctx, time.Time{})
+	if err != nil {
+		return summary, err
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Date.Before(matches[j].Date) })
+	for _, m := range matches {
+		err = write("match", toBackupMatch(m))
+		if err != nil {
+			return summary, err
+		}
+		summary.Matches++
+	}
+	return summary, nil
+}
+
+// a backup that's been read and checked, but not imported yet
+type parsedBackup struct {
+	config     *backupConfig
+	recursers  []BackupRecurser
+	offboarded []BackupOffboarded
+	matches    []backupMatch
+}
+
+// readBackup reads a whole backup and checks everything in it, so that
+// nothing is imported from a file that's only partly right
+func readBackup(r io.Reader) (parsedBackup, error) {
+	var backup parsedBackup
+	var problems []string
+	problem := func(lineNo int, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("line %d: ", lineNo)+fmt.Sprintf(format, args...))
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	lineNo := 0
+	sawHeader := false
+	recurserIDs := map[string]bool{}
+	offboardedIDs := map[string]bool{}
+	for scanner.Scan() && len(problems) < maxBackupErrors {
+		lineNo++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var line backupLine
+		err := json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			problem(lineNo, "not JSON: %s", err)
+			continue
+		}
+
+		if !sawHeader {
+			var header backupHeader
+			err = json.Unmarshal(line.Data, &header)
+			switch {
+			case line.Type != "header" || err != nil || header.Format != backupFormat:
+				return backup, fmt.Errorf("line %d: this isn't a Pairing Bot backup, it should start with a header", lineNo)
+			case header.Version < 1 || header.Version > backupVersion:
+				return backup, fmt.Errorf("line %d: this backup is version %d, and I can only read up to version %d", lineNo, header.Version, backupVersion)
+			}
+			sawHeader = true
+			continue
+		}
+
+		switch line.Type {
+		case "config":
+			if backup.config != nil {
+				problem(lineNo, "there's more than one config")
+				continue
+			}
+			var c backupConfig
+			err = decodeStrict(line.Data, &c)
+			if err != nil {
+				problem(lineNo, "bad config: %s", err)
+				continue
+			}
+			backup.config = &c
+
+		case "recurser":
+			var b BackupRecurser
+			err = decodeStrict(line.Data, &b)
+			if err == nil {
+				err = checkBackupRecurser(b)
+			}
+			if err == nil && recurserIDs[b.ID] {
+				err = errors.New("there's already a recurser with this id")
+			}
+			if err != nil {
+				problem(lineNo, "bad recurser: %s", err)
+				continue
+			}
+			recurserIDs[b.ID] = true
+			backup.recursers = append(backup.recursers, b)
+
+		case "offboarded":
+			var b BackupOffboarded
+			err = decodeStrict(line.Data, &b)
+			if err == nil {
+				err = checkBackupRecurser(b.BackupRecurser)
+			}
+			switch {
+			case err != nil:
+			case b.OffboardedAt.IsZero():
+				err = errors.New("offboardedAt is missing")
+			case b.OffboardReason != ReasonBatchEnded && b.OffboardReason != ReasonNoStay:
+				err = fmt.Errorf("%q isn't a reason to offboard someone", b.OffboardReason)
+			case offboardedIDs[b.ID]:
+				err = errors.New("there's already an offboarded recurser with this id")
+			}
+			if err != nil {
+				problem(lineNo, "bad offboarded recurser: %s", err)
+				continue
+			}
+			offboardedIDs[b.ID] = true
+			backup.offboarded = append(backup.offboarded, b)
+
+		case "match":
+			var b backupMatch
+			err = decodeStrict(line.Data, &b)
+			if err == nil {
+				err = checkBackupMatch(b)
+			}
+			if err != nil {
+				problem(lineNo, "bad match: %s", err)
+				continue
+			}
+			backup.matches = append(backup.matches, b)
+
+		default:
+			problem(lineNo, "I don't know what a %q is", line.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return backup, err
+	}
+	if !sawHeader {
+		return backup, errors.New("this backup is empty")
+	}
+	if len(problems) > 0 {
+		return backup, fmt.Errorf("this backup has problems, so I didn't import anything:\n%v", strings.Join(problems, "\n"))
+	}
+	return backup, nil
+}
+
+// decodeStrict is json.Unmarshal, except that fields it doesn't know about are
+// errors, since they're probably typos
+func decodeStrict(data json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+func checkBackupRecurser(b BackupRecurser) error {
+	if b.ID == "" {
+		return errors.New("id is missing")
+	}
+	for day := range b.Schedule {
+		if !contains(Weekdays(), day) {
+			return fmt.Errorf("%q isn't a day", day)
+		}
+	}
+	switch b.NotifyVia {
+	case NotifyViaZulip, NotifyViaEmail, NotifyViaBoth:
+	default:
+		return fmt.Errorf("%q isn't a way to notify someone", b.NotifyVia)
+	}
+	return nil
+}
+
+func checkBackupMatch(b backupMatch) error {
+	if b.Date.IsZero() {
+		return errors.New("date is missing")
+	}
+	if len(b.IDs) != 2 || len(b.Names) != 2 || len(b.Emails) != 2 {
+		return errors.New("a match needs two ids, names and emails")
+	}
+	for id, c := range b.CheckIns {
+		if !contains(b.IDs, id) {
+			return fmt.Errorf("%v checked in, but wasn't in the match", id)
+		}
+		if c.Rating < 0 || c.Rating > 5 {
+			return fmt.Errorf("%d isn't a rating", c.Rating)
+		}
+	}
+	return nil
+}
+
+// ImportBackup reads a backup from r and writes it to the databases, on top of
+// what's already there. recursers in the backup replace the ones in the
+// database, and matches that are already there are skipped. with dryRun, it
+// checks the backup and works out what it would do, but doesn't write anything
+func ImportBackup(ctx context.Context, rdb RecurserDB, mdb MatchDB, cdb ConfigDB, r io.Reader, dryRun bool) (BackupSummary, error) {
+	var summary BackupSummary
+
+	backup, err := readBackup(r)
+	if err != nil {
+		return summary, err
+	}
+
+	existing, err := rdb.GetAllUsers(ctx)
+	if err != nil {
+		return summary, err
+	}
+	subscribed := map[string]bool{}
+	for _, rec := range existing {
+		subscribed[rec.ID] = true
+	}
+	matches, err := mdb.ListSince(ctx, time.Time{})
+	if err != nil {
+		return summary, err
+	}
+	matchKeys := map[string]bool{}
+	for _, m := range matches {
+		matchKeys[matchKey(m.Date, m.IDs)] = true
+	}
+
+	if backup.config != nil {
+		summary.Config = true
+		if !dryRun {
+			c := backup.config
+			err = cdb.SetMaintenance(ctx, Maintenance{c.Maintenance.Enabled, c.Maintenance.Message, c.Maintenance.AllowedUsers})
+			if err != nil {
+				return summary, err
+			}
+			err = cdb.SetAnnouncements(ctx, Announcements{c.Announcements.Enabled, c.Announcements.Stream, c.Announcements.Topic, c.Announcements.Template})
+			if err != nil {
+				return summary, err
+			}
+		}
+	}
+
+	for _, b := range backup.recursers {
+		replacing := subscribed[b.ID]
+		if !dryRun {
+			err = importRecurser(ctx, rdb, b.toRecurser(), replacing)
+			if err != nil {
+				return summary, err
+			}
+		}
+		subscribed[b.ID] = true
+		summary.Recursers++
+		if replacing {
+			summary.Replaced++
+		}
+	}
+
+	for _, b := range backup.offboarded {
+		// offboarding them would take away their new subscription
+		if subscribed[b.ID] {
+			summary.SkippedOffboarded++
+			continue
+		}
+		if !dryRun {
+			err = importRecurser(ctx, rdb, b.toRecurser(), false)
+			if err != nil {
+				return summary, err
+			}
+			err = rdb.Offboard(ctx, b.ID, b.OffboardReason, b.OffboardedAt)
+			if err != nil {
+				return summary, err
+			}
+		}
+		summary.Offboarded++
+	}
+
+	for _, b := range backup.matches {
+		key := matchKey(b.Date, b.IDs)
+		if matchKeys[key] {
+			summary.DuplicateMatches++
+			continue
+		}
+		if !dryRun {
+			err = mdb.Add(ctx, b.toMatch())
+			if err != nil {
+				return summary, err
+			}
+		}
+		matchKeys[key] = true
+		summary.Matches++
+	}
+	return summary, nil
+}
+
+// importRecurser writes rec over whatever's there. Set merges, so anyone
+// who's already there is deleted first, or fields that the backup leaves out
+// (like lastOddOneOut) would be kept
+func importRecurser(ctx context.Context, rdb RecurserDB, rec Recurser, replacing bool) error {
+	if replacing {
+		err := rdb.Delete(ctx, rec.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return rdb.Set(ctx, rec.ID, rec)
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newBackupFixture fills in-memory databases with a bit of everything a backup holds
+func newBackupFixture() (*MemoryRecurserDB, *MemoryMatchDB, *MemoryConfigDB) {
+	ctx := context.Background()
+	rdb, mdb, cdb := NewMemoryRecurserDB(), NewMemoryMatchDB(), NewMemoryConfigDB()
+	day := time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC)
+
+	ada := NewRecurser("2", "ada@example.com", "Ada")
+	ada.LastOddOneOut = day
+	ada.NotifyVia = NotifyViaEmail
+	ada.TriedCommands = []string{"history"}
+	ada.Batch = "W1'24"
+	ada.BatchEnd = time.Date(2024, 3, 29, 0, 0, 0, 0, time.UTC)
+	bea := NewRecurser("3", "bea@example.com", "Bea")
+	bea.Schedule["saturday"] = true
+	cat := NewRecurser("4", "cat@example.com", "Cat")
+	for _, r := range []Recurser{ada, bea, cat} {
+		rdb.Set(ctx, r.ID, r)
+	}
+	rdb.Offboard(ctx, cat.ID, ReasonNoStay, day)
+
+	mdb.Add(ctx, Match{
+		Date:     day,
+		IDs:      []string{"2", "3"},
+		Names:    []string{"Ada", "Bea"},
+		Emails:   []string{"ada@example.com", "bea@example.com"},
+		CheckIns: map[string]CheckIn{"2": {Happened: true, Rating: 5}},
+	})
+	mdb.Add(ctx, Match{
+		Date:   day.AddDate(0, 0, 1),
+		IDs:    []string{"3", "4"},
+		Names:  []string{"Bea", "Cat"},
+		Emails: []string{"bea@example.com", "cat@example.com"},
+	})
+
+	cdb.SetMaintenance(ctx, Maintenance{true, "back soon", []string{"2"}})
+	cdb.SetAnnouncements(ctx, Announcements{true, "pairing", "today", ""})
+	return rdb, mdb, cdb
+}
+
+// withoutHeader drops the first line of a backup, since it has the time it was made
+func withoutHeader(backup string) string {
+	return backup[strings.Index(backup, "\n")+1:]
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	rdb, mdb, cdb := newBackupFixture()
+
+	var backup bytes.Buffer
+	summary, err := ExportBackup(ctx, rdb, mdb, cdb, &backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Recursers != 2 || summary.Offboarded != 1 || summary.Matches != 2 || !summary.Config {
+		t.Errorf("got export summary %+v", summary)
+	}
+
+	// into an empty database, and back out again
+	rdb2, mdb2, cdb2 := NewMemoryRecurserDB(), NewMemoryMatchDB(), NewMemoryConfigDB()
+	summary, err = ImportBackup(ctx, rdb2, mdb2, cdb2, bytes.NewReader(backup.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Recursers != 2 || summary.Replaced != 0 || summary.Offboarded != 1 || summary.Matches != 2 {
+		t.Errorf("got import summary %+v", summary)
+	}
+	var again bytes.Buffer
+	_, err = ExportBackup(ctx, rdb2, mdb2, cdb2, &again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withoutHeader(again.String()) != withoutHeader(backup.String()) {
+		t.Errorf("the backup changed on the way through.\nbefore: %s\nafter: %s", backup.String(), again.String())
+	}
+
+	// importing it twice replaces the recursers, and doesn't duplicate matches
+	summary, err = ImportBackup(ctx, rdb2, mdb2, cdb2, bytes.NewReader(backup.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Replaced != 2 || summary.Matches != 0 || summary.DuplicateMatches != 2 {
+		t.Errorf("got import summary %+v", summary)
+	}
+	if matches, _ := mdb2.ListSince(ctx, time.Time{}); len(matches) != 2 {
+		t.Errorf("got %d matches after importing twice", len(matches))
+	}
+}
+
+func TestImportBackupDryRun(t *testing.T) {
+	ctx := context.Background()
+	var backup bytes.Buffer
+	rdb, mdb, cdb := newBackupFixture()
+	ExportBackup(ctx, rdb, mdb, cdb, &backup)
+
+	rdb2, mdb2, cdb2 := NewMemoryRecurserDB(), NewMemoryMatchDB(), NewMemoryConfigDB()
+	summary, err := ImportBackup(ctx, rdb2, mdb2, cdb2, &backup, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Recursers != 2 || summary.Offboarded != 1 || summary.Matches != 2 || !summary.Config {
+		t.Errorf("got dry run summary %+v", summary)
+	}
+	recursers, _ := rdb2.GetAllUsers(ctx)
+	offboarded, _ := rdb2.ListOffboarded(ctx)
+	matches, _ := mdb2.ListSince(ctx, time.Time{})
+	maintenance, _ := cdb2.GetMaintenance(ctx)
+	if len(recursers) != 0 || len(offboarded) != 0 || len(matches) != 0 || maintenance.Enabled {
+		t.Error("a dry run changed the database")
+	}
+}
+
+func TestReadBackupProblems(t *testing.T) {
+	header := `{"type": "header", "data": {"format": "pairing-bot-backup", "version": 1}}` + "\n"
+	recurser := `{"type": "recurser", "data": {"id": "2", "name": "Ada", "email": "ada@example.com", "schedule": {"monday": true}, "notifyVia": "zulip"}}` + "\n"
+	tests := []struct {
+		testName string
+		backup   string
+		wanted   string
+	}{
+		{"ok", header + recurser, ""},
+		{"empty", "", "empty"},
+		{"no_header", recurser, "should start with a header"},
+		{"newer_version", `{"type": "header", "data": {"format": "pairing-bot-backup", "version": 99}}`, "version 99"},
+		{"not_json", header + "{\n", "line 2: not JSON"},
+		{"unknown_type", header + `{"type": "user", "data": {}}`, `line 2: I don't know what a "user" is`},
+		{"unknown_field", header + `{"type": "recurser", "data": {"id": "2", "shedule": {}, "notifyVia": "zulip"}}`, "unknown field"},
+		{"no_id", header + `{"type": "recurser", "data": {"notifyVia": "zulip"}}`, "id is missing"},
+		{"bad_day", header + `{"type": "recurser", "data": {"id": "2", "schedule": {"caturday": true}, "notifyVia": "zulip"}}`, `"caturday" isn't a day`},
+		{"bad_notify_via", header + `{"type": "recurser", "data": {"id": "2", "notifyVia": "pigeon"}}`, "isn't a way to notify"},
+		{"same_id_twice", header + recurser + recurser, "line 3: bad recurser: there's already"},
+		{"bad_reason", header + `{"type": "offboarded", "data": {"id": "2", "notifyVia": "zulip", "offboardedAt": "2024-03-29T00:00:00Z", "offboardReason": "bored"}}`, "isn't a reason"},
+		{"lonely_match", header + `{"type": "match", "data": {"date": "2024-03-29T04:00:00Z", "ids": ["2"], "names": ["Ada"], "emails": ["ada@example.com"]}}`, "two ids"},
+		{"bad_rating", header + `{"type": "match", "data": {"date": "2024-03-29T04:00:00Z", "ids": ["2", "3"], "names": ["Ada", "Bea"], "emails": ["a", "b"], "checkIns": {"2": {"happened": true, "rating": 6}}}}`, "6 isn't a rating"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			_, err := readBackup(strings.NewReader(tt.backup))
+			switch {
+			case tt.wanted == "" && err != nil:
+				t.Errorf("got %v", err)
+			case tt.wanted != "" && (err == nil || !strings.Contains(err.Error(), tt.wanted)):
+				t.Errorf("got %v, wanted an error about %q", err, tt.wanted)
+			}
+		})
+	}
+}
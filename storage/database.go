@@ -0,0 +1,1396 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// how people tell us their batch, like `batch W1'24 2024-03-29`
+const BatchDateLayout = "2006-01-02"
+
+// this is what we send to / receive from Firestore
+// var recurser = map[string]interface{}{
+// 	"id":                 "string",
+// 	"name":               "string",
+// 	"email":              "string",
+// 	"isSkippingTomorrow": false,
+// 	"schedule": map[string]interface{}{
+// 		"monday":    false,
+// 		"tuesday":   false,
+// 		"wednesday": false,
+// 		"thursday":  false,
+// 		"friday":    false,
+// 		"saturday":  false,
+// 		"sunday":    false,
+// 	},
+// 	"lastOddOneOut":      time.Time, // only present once they've been left out
+// 	"notifyVia":          "string",  // "zulip", "email" or "both". missing from older documents
+// 	"announce":           false,     // missing from older documents
+// 	"digest":             true,      // missing from older documents, which means true
+// 	"triedCommands":      []string,  // missing from older documents
+// 	"reminders":          false,     // missing from older documents
+// 	"batch":              "string",  // like "W1'24". missing from older documents
+// 	"batchEnd":           time.Time, // only present once we know it
+// 	"askedToStay":        false,     // missing from older documents
+// 	"schemaVersion":      1,         // see schema.go. missing from older documents
+// }
+
+// Recurser is someone subscribed to Pairing Bot, or who could be. ID is a
+// Zulip user ID, or a prefixed one (see PlatformUserID)
+type Recurser struct {
+	ID                 string
+	Name               string
+	Email              string
+	IsSkippingTomorrow bool
+	Schedule           map[string]interface{}
+	IsSubscribed       bool
+	// zero if they've never been the odd one out
+	LastOddOneOut time.Time
+	// how they want to hear about matches and end of batch: NotifyViaZulip,
+	// NotifyViaEmail or NotifyViaBoth
+	NotifyVia string
+	// whether they're happy to be named in the stream announcement after matching
+	Announce bool
+	// whether they get the weekly digest
+	Digest bool
+	// the commands they've used, so the digest can suggest ones they haven't
+	TriedCommands []string
+	// whether they want a reminder the evening before they're going to be matched
+	Reminders bool
+	// which batch they're in, and its last day. empty and zero if we don't know
+	Batch    string
+	BatchEnd time.Time
+	// whether we asked at the end of a batch if they're still here, and they haven't said `stay`
+	AskedToStay bool
+}
+
+// "zulip" means whichever chat platform someone uses Pairing Bot on
+const (
+	NotifyViaZulip = "zulip"
+	NotifyViaEmail = "email"
+	NotifyViaBoth  = "both"
+)
+
+// ConvertToMap is r as a Firestore document
+func (r *Recurser) ConvertToMap() map[string]interface{} {
+	m := map[string]interface{}{
+		"id":                 r.ID,
+		"name":               r.Name,
+		"email":              r.Email,
+		"isSkippingTomorrow": r.IsSkippingTomorrow,
+		"schedule":           r.Schedule,
+		"announce":           r.Announce,
+		"digest":             r.Digest,
+		"reminders":          r.Reminders,
+		"batch":              r.Batch,
+		"askedToStay":        r.AskedToStay,
+	}
+	m["schemaVersion"] = CurrentRecurserSchema
+	if !r.LastOddOneOut.IsZero() {
+		m["lastOddOneOut"] = r.LastOddOneOut
+	}
+	if !r.BatchEnd.IsZero() {
+		m["batchEnd"] = r.BatchEnd
+	}
+	if len(r.TriedCommands) > 0 {
+		m["triedCommands"] = append([]string(nil), r.TriedCommands...)
+	}
+	// always written once it's set, so that switching back to zulip sticks with MergeAll
+	if r.NotifyVia != "" {
+		m["notifyVia"] = r.NotifyVia
+	}
+	return m
+}
+
+// MapToStruct reads a recurser document, upgrading it from older schema
+// versions. fields of the wrong type get their defaults, and are logged
+func MapToStruct(m map[string]interface{}) Recurser {
+	r, problems := decodeRecurser(m)
+	if len(problems) > 0 {
+		log.Printf("Problems reading recurser %v, so I used defaults: %v", r.ID, problems)
+	}
+	return r
+}
+
+// decodeRecurser migrates a copy of m to the current version and reads it,
+// returning anything that was wrong with it instead of panicking
+func decodeRecurser(m map[string]interface{}) (Recurser, []string) {
+	doc := copyDoc(m)
+	migrateRecurser(doc)
+	d := docReader{doc: doc}
+
+	// isSubscribed is missing here because it's not in the map
+	r := Recurser{
+		ID:                 d.string("id", ""),
+		Name:               d.string("name", ""),
+		Email:              d.string("email", ""),
+		IsSkippingTomorrow: d.bool("isSkippingTomorrow", false),
+		Schedule:           d.schedule("schedule"),
+		LastOddOneOut:      d.time("lastOddOneOut"),
+		NotifyVia:          d.string("notifyVia", NotifyViaZulip),
+		Announce:           d.bool("announce", false),
+		Digest:             d.bool("digest", true),
+		TriedCommands:      d.strings("triedCommands"),
+		Reminders:          d.bool("reminders", false),
+		Batch:              d.string("batch", ""),
+		BatchEnd:           d.time("batchEnd"),
+		AskedToStay:        d.bool("askedToStay", false),
+	}
+	if r.ID == "" {
+		d.problems = append(d.problems, "id is missing")
+	}
+	switch r.NotifyVia {
+	case NotifyViaZulip, NotifyViaEmail, NotifyViaBoth:
+	default:
+		d.problems = append(d.problems, fmt.Sprintf("notifyVia %q isn't a way to notify anyone", r.NotifyVia))
+		r.NotifyVia = NotifyViaZulip
+	}
+	return r, d.problems
+}
+
+// NewRecurser is what someone looks like before they've subscribed:
+// set to pair on weekdays, and not skipping tomorrow
+func NewRecurser(userID, userEmail, userName string) Recurser {
+	return Recurser{
+		ID:                 userID,
+		Name:               userName,
+		Email:              userEmail,
+		IsSkippingTomorrow: false,
+		NotifyVia:          NotifyViaZulip,
+		Digest:             true,
+		Schedule: map[string]interface{}{
+			"monday":    true,
+			"tuesday":   true,
+			"wednesday": true,
+			"thursday":  true,
+			"friday":    true,
+			"saturday":  false,
+			"sunday":    false,
+		},
+	}
+}
+
+// why someone was offboarded, for the end-of-batch plan and the "offboarded" collection
+const (
+	ReasonBatchEnded = "batch ended"
+	ReasonNoStay     = "didn't say stay"
+)
+
+// someone who was offboarded at the end of a batch. their documents in the
+// "offboarded" collection are their old recurser documents, plus
+// "offboardedAt" (a time.Time) and "offboardReason" (ReasonBatchEnded or ReasonNoStay)
+
+// OffboardedRecurser is a document in "offboarded"
+type OffboardedRecurser struct {
+	Recurser     Recurser
+	OffboardedAt time.Time
+	Reason       string
+}
+
+// MapToOffboarded reads a document from "offboarded", as tolerantly as MapToStruct
+func MapToOffboarded(m map[string]interface{}) OffboardedRecurser {
+	d := docReader{doc: m}
+	o := OffboardedRecurser{
+		Recurser:     MapToStruct(m),
+		OffboardedAt: d.time("offboardedAt"),
+		Reason:       d.string("offboardReason", ReasonNoStay),
+	}
+	d.logProblems("offboarded recurser " + o.Recurser.ID)
+	return o
+}
+
+// DB Lookups of Pairing Bot subscribers (= "Recursers")
+
+// RecurserDB is the "recursers" collection, where everyone who's subscribed
+// has a document under their user ID, and the "offboarded" and "oddoneouts"
+// collections that go with it
+type RecurserDB interface {
+	// GetByUserID is someone's record, with the name and email they have now.
+	// if they aren't subscribed, it's NewRecurser's defaults with IsSubscribed false
+	GetByUserID(ctx context.Context, userID, userEmail, userName string) (Recurser, error)
+	// GetAllUsers is everyone who's subscribed
+	GetAllUsers(ctx context.Context) ([]Recurser, error)
+	// Set saves someone's record, which subscribes them if they weren't
+	Set(ctx context.Context, userID string, recurser Recurser) error
+	// Delete unsubscribes someone
+	Delete(ctx context.Context, userID string) error
+	// ListPairingTomorrow is everyone scheduled for today in UTC, which is the
+	// day the 04:00 UTC match run is for ("tomorrow", to people in New York)
+	ListPairingTomorrow(ctx context.Context) ([]Recurser, error)
+	// ListPairingOn is everyone scheduled on day, not counting anyone who's
+	// skipping. day is lowercase, like "monday"
+	ListPairingOn(ctx context.Context, day string) ([]Recurser, error)
+	// ListSkippingTomorrow is everyone who's said `skip tomorrow`
+	ListSkippingTomorrow(ctx context.Context) ([]Recurser, error)
+	// UnsetSkippingTomorrow undoes `skip tomorrow`, once the day they skipped is over
+	UnsetSkippingTomorrow(ctx context.Context, recurser Recurser) error
+	// RecordOddOneOut notes that someone was left without a partner, in
+	// "oddoneouts" and in their LastOddOneOut
+	RecordOddOneOut(ctx context.Context, recurser Recurser, when time.Time) error
+	// Offboard moves someone's document into the "offboarded" collection, where
+	// it's kept until PurgeOffboarded, so they can be restored. it does nothing
+	// if they aren't subscribed
+	Offboard(ctx context.Context, userID, reason string, when time.Time) error
+	ListOffboarded(ctx context.Context) ([]OffboardedRecurser, error)
+	// Restore moves someone's document back from the "offboarded" collection.
+	// it fails with ErrStillSubscribed if they've subscribed again since
+	Restore(ctx context.Context, userID string) (Recurser, error)
+	// PurgeOffboarded deletes everyone who was offboarded before a time, for good
+	PurgeOffboarded(ctx context.Context, before time.Time) (int, error)
+	// Forget deletes everything about someone: their document, the one in
+	// "offboarded", and their entries in "oddoneouts"
+	Forget(ctx context.Context, userID string) error
+	// Migrate brings every document in "recursers" and "offboarded" up to the
+	// current schema version (see schema.go), and returns how many needed it.
+	// with dryRun, it only counts them
+	Migrate(ctx context.Context, dryRun bool) (int, error)
+}
+
+// ErrStillSubscribed is what Restore fails with for someone who's subscribed again
+var ErrStillSubscribed = errors.New("they've subscribed again since they were offboarded")
+
+// implements RecurserDB
+type FirestoreRecurserDB struct {
+	Client *firestore.Client
+}
+
+func (f *FirestoreRecurserDB) GetByUserID(ctx context.Context, userID, userEmail, userName string) (Recurser, error) {
+	// get the users "document" (database entry) out of firestore
+	// we temporarily keep it in 'doc'
+	doc, err := f.Client.Collection("recursers").Doc(userID).Get(ctx)
+	// this says "if there's an error, and if that error was not document-not-found"
+	if err != nil && status.Code(err) != codes.NotFound {
+		return Recurser{}, err
+	}
+
+	// if there's a db entry, that means they were already subscribed to pairing bot
+	// if there's not, they were not subscribed
+	isSubscribed := doc.Exists()
+
+	var r Recurser
+	// if the user is in the database, get their current state into this map
+	// also assign their zulip name to the name field, just in case it changed
+	// also assign their email, for the same reason
+	if isSubscribed {
+		recurser := doc.Data()
+		// the document's older than this version of the bot. bring it up to
+		// date while we're here, but it's not worth failing over
+		if schemaVersion(recurser) < CurrentRecurserSchema {
+			_, err = f.migrate(ctx, doc.Ref, false)
+			if err != nil {
+				log.Printf("Could not migrate recurser %v: %s\n", userID, err)
+			}
+		}
+		recurser["name"] = userName
+		recurser["email"] = userEmail
+		r = MapToStruct(recurser)
+	} else {
+		// User is not subscribed, so provide a default recurser struct instead.
+		r = NewRecurser(userID, userEmail, userName)
+	}
+	// now put the data from the recurser map into a Recurser struct
+	r.IsSubscribed = isSubscribed
+	return r, nil
+}
+
+func (f *FirestoreRecurserDB) GetAllUsers(ctx context.Context) ([]Recurser, error) {
+
+	var recursersList []Recurser
+	var r Recurser
+
+	iter := f.Client.Collection("recursers").Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r = MapToStruct(doc.Data())
+
+		recursersList = append(recursersList, r)
+	}
+	return recursersList, nil
+}
+
+func (f *FirestoreRecurserDB) Set(ctx context.Context, userID string, recurser Recurser) error {
+
+	r := recurser.ConvertToMap()
+	_, err := f.Client.Collection("recursers").Doc(userID).Set(ctx, r, firestore.MergeAll)
+	return err
+
+}
+
+func (f *FirestoreRecurserDB) Delete(ctx context.Context, userID string) error {
+	_, err := f.Client.Collection("recursers").Doc(userID).Delete(ctx)
+	return err
+}
+
+func (f *FirestoreRecurserDB) ListPairingTomorrow(ctx context.Context) ([]Recurser, error) {
+	// this gets the time from system time, which is UTC
+	// on app engine (and most other places). This works
+	// fine for us in NYC, but might not if pairing bot
+	// were ever running in another time zone
+	today := strings.ToLower(time.Now().Weekday().String())
+	return f.ListPairingOn(ctx, today)
+}
+
+// ListPairingOn lists everyone who's scheduled to pair on day and isn't skipping
+func (f *FirestoreRecurserDB) ListPairingOn(ctx context.Context, day string) ([]Recurser, error) {
+	var recursersList []Recurser
+	var r Recurser
+
+	// ok this is how we have to get all the recursers. it's weird.
+	// this query returns an iterator, and then we have to use firestore
+	// magic to iterate across the results of the query and store them
+	// into our 'recursersList' variable which is a slice of map[string]interface{}
+	iter := f.Client.Collection("recursers").Where("isSkippingTomorrow", "==", false).Where("schedule."+day, "==", true).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		r = MapToStruct(doc.Data())
+
+		recursersList = append(recursersList, r)
+	}
+
+	return recursersList, nil
+}
+
+func (f *FirestoreRecurserDB) ListSkippingTomorrow(ctx context.Context) ([]Recurser, error) {
+
+	var skippersList []Recurser
+	var r Recurser
+
+	iter := f.Client.Collection("recursers").Where("isSkippingTomorrow", "==", true).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		r = MapToStruct(doc.Data())
+
+		skippersList = append(skippersList, r)
+	}
+	return skippersList, nil
+}
+
+func (f *FirestoreRecurserDB) UnsetSkippingTomorrow(ctx context.Context, recurser Recurser) error {
+
+	r := recurser.ConvertToMap()
+	r["isSkippingTomorrow"] = false
+
+	_, err := f.Client.Collection("recursers").Doc(r["id"].(string)).Set(ctx, r, firestore.MergeAll)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RecordOddOneOut logs a leftover event in the "oddoneouts" collection
+// and stamps the recurser's document with when it happened
+func (f *FirestoreRecurserDB) RecordOddOneOut(ctx context.Context, recurser Recurser, when time.Time) error {
+	_, _, err := f.Client.Collection("oddoneouts").Add(ctx, map[string]interface{}{
+		"id":   recurser.ID,
+		"date": when,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Client.Collection("recursers").Doc(recurser.ID).Set(ctx, map[string]interface{}{
+		"lastOddOneOut": when,
+	}, firestore.MergeAll)
+	return err
+}
+
+// Offboard moves the document in one transaction, so nobody is ever in both
+// collections or in neither
+func (f *FirestoreRecurserDB) Offboard(ctx context.Context, userID, reason string, when time.Time) error {
+	from := f.Client.Collection("recursers").Doc(userID)
+	to := f.Client.Collection("offboarded").Doc(userID)
+	return f.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(from)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		r := doc.Data()
+		r["offboardedAt"] = when
+		r["offboardReason"] = reason
+		err = tx.Set(to, r)
+		if err != nil {
+			return err
+		}
+		return tx.Delete(from)
+	})
+}
+
+// ListOffboarded lists everyone who's been offboarded, most recent first
+func (f *FirestoreRecurserDB) ListOffboarded(ctx context.Context) ([]OffboardedRecurser, error) {
+	var offboarded []OffboardedRecurser
+
+	iter := f.Client.Collection("offboarded").OrderBy("offboardedAt", firestore.Desc).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		offboarded = append(offboarded, MapToOffboarded(doc.Data()))
+	}
+	return offboarded, nil
+}
+
+func (f *FirestoreRecurserDB) Restore(ctx context.Context, userID string) (Recurser, error) {
+	from := f.Client.Collection("offboarded").Doc(userID)
+	to := f.Client.Collection("recursers").Doc(userID)
+	var r Recurser
+	err := f.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current, err := tx.Get(to)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if current.Exists() {
+			return ErrStillSubscribed
+		}
+		doc, err := tx.Get(from)
+		if err != nil {
+			return err
+		}
+		m := doc.Data()
+		delete(m, "offboardedAt")
+		delete(m, "offboardReason")
+		r = MapToStruct(m)
+		err = tx.Set(to, m)
+		if err != nil {
+			return err
+		}
+		return tx.Delete(from)
+	})
+	return r, err
+}
+
+func (f *FirestoreRecurserDB) PurgeOffboarded(ctx context.Context, before time.Time) (int, error) {
+	purged := 0
+	iter := f.Client.Collection("offboarded").Where("offboardedAt", "<", before).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return purged, err
+		}
+		_, err = doc.Ref.Delete(ctx)
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (f *FirestoreRecurserDB) Migrate(ctx context.Context, dryRun bool) (int, error) {
+	migrated := 0
+	for _, col := range []string{"recursers", "offboarded"} {
+		iter := f.Client.Collection(col).Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return migrated, err
+			}
+			if schemaVersion(doc.Data()) >= CurrentRecurserSchema {
+				continue
+			}
+			ok, err := f.migrate(ctx, doc.Ref, dryRun)
+			if err != nil {
+				return migrated, err
+			}
+			if ok {
+				migrated++
+			}
+		}
+	}
+	return migrated, nil
+}
+
+// migrate brings one document up to date in a transaction, so nothing written
+// to it in the meantime is lost. it says whether it needed to
+func (f *FirestoreRecurserDB) migrate(ctx context.Context, ref *firestore.DocumentRef, dryRun bool) (bool, error) {
+	migrated := false
+	err := f.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		m := doc.Data()
+		migrated = migrateRecurser(m)
+		if !migrated || dryRun {
+			return nil
+		}
+		return tx.Set(ref, m)
+	})
+	return migrated, err
+}
+
+func (f *FirestoreRecurserDB) Forget(ctx context.Context, userID string) error {
+	for _, col := range []string{"recursers", "offboarded"} {
+		_, err := f.Client.Collection(col).Doc(userID).Delete(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	iter := f.Client.Collection("oddoneouts").Where("id", "==", userID).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		_, err = doc.Ref.Delete(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a Match is one pair that got matched on one day. We keep them around so we
+// can check in afterwards, and so people can look back on who they've paired with
+
+// this is what we send to / receive from Firestore
+// var match = map[string]interface{}{
+// 	"date":        time.Time,
+// 	"ids":         []string{"id1", "id2"},
+// 	"names":       []string{"name1", "name2"},
+// 	"emails":      []string{"email1", "email2"},
+// 	"checkInSent": false,
+// 	"checkIns": map[string]interface{}{
+// 		"id1": map[string]interface{}{"happened": true, "rating": 5},
+// 	},
+// }
+
+// Match is a pair who were matched on a day, and how their check-ins went
+type Match struct {
+	ID    string
+	Date  time.Time
+	IDs   []string
+	Names []string
+	// what we message each of them at: their email on Zulip, or their ID on other platforms
+	Emails      []string
+	CheckInSent bool
+	// keyed by recurser id, only has entries for people who've answered
+	CheckIns map[string]CheckIn
+}
+
+// what someone told us when we asked if their pairing session happened
+type CheckIn struct {
+	Happened bool
+	// 1 to 5, or 0 if they didn't give a rating
+	Rating int
+}
+
+// ConvertToMap is m as a Firestore document. the ID is the document's own, so it's left out
+func (m *Match) ConvertToMap() map[string]interface{} {
+	checkIns := map[string]interface{}{}
+	for id, c := range m.CheckIns {
+		checkIns[id] = map[string]interface{}{
+			"happened": c.Happened,
+			"rating":   c.Rating,
+		}
+	}
+	return map[string]interface{}{
+		"date":        m.Date,
+		"ids":         m.IDs,
+		"names":       m.Names,
+		"emails":      m.Emails,
+		"checkInSent": m.CheckInSent,
+		"checkIns":    checkIns,
+	}
+}
+
+// MapToMatch reads a match document. fields of the wrong type get their
+// defaults, and are logged
+func MapToMatch(id string, m map[string]interface{}) Match {
+	match, problems := decodeMatch(id, m)
+	if len(problems) > 0 {
+		log.Printf("Problems reading match %v, so I used defaults: %v", id, problems)
+	}
+	return match
+}
+
+// decodeMatch reads a match document, returning anything that was wrong with
+// it instead of panicking
+func decodeMatch(id string, m map[string]interface{}) (Match, []string) {
+	d := docReader{doc: m}
+	match := Match{
+		ID:          id,
+		Date:        d.time("date"),
+		IDs:         d.strings("ids"),
+		Names:       d.strings("names"),
+		Emails:      d.strings("emails"),
+		CheckInSent: d.bool("checkInSent", false),
+		CheckIns:    map[string]CheckIn{},
+	}
+	checkIns, ok := m["checkIns"].(map[string]interface{})
+	if _, there := m["checkIns"]; there && !ok {
+		d.wrongType("checkIns", m["checkIns"], "map")
+	}
+	for userID, v := range checkIns {
+		c, ok := v.(map[string]interface{})
+		if !ok {
+			d.wrongType("checkIns."+userID, v, "map")
+			continue
+		}
+		cd := docReader{doc: c}
+		match.CheckIns[userID] = CheckIn{
+			Happened: cd.bool("happened", false),
+			Rating:   cd.int("rating", 0),
+		}
+		for _, p := range cd.problems {
+			d.problems = append(d.problems, "checkIns."+userID+"."+p)
+		}
+	}
+	if p := match.peopleProblem(); p != "" {
+		d.problems = append(d.problems, p)
+	}
+	return match, d.problems
+}
+
+// peopleProblem says what's wrong with who's in m, or "" if nothing is.
+// everything that reads a match expects exactly two people, with a name and
+// an email each, so the List functions leave out matches that don't have them
+func (m *Match) peopleProblem() string {
+	switch {
+	case len(m.IDs) != 2:
+		return fmt.Sprintf("there are %d ids, not 2", len(m.IDs))
+	case len(m.Names) != len(m.IDs) || len(m.Emails) != len(m.IDs):
+		return fmt.Sprintf("there are %d names and %d emails for %d ids", len(m.Names), len(m.Emails), len(m.IDs))
+	}
+	return ""
+}
+
+// firestore hands arrays back to us as []interface{}. anything in them that
+// isn't a string is dropped (docReader.strings says so)
+func toStringSlice(v interface{}) []string {
+	// the in-memory databases hand back what they were given
+	if list, ok := v.([]string); ok {
+		return append([]string(nil), list...)
+	}
+	var s []string
+	list, _ := v.([]interface{})
+	for _, item := range list {
+		if str, ok := item.(string); ok {
+			s = append(s, str)
+		}
+	}
+	return s
+}
+
+// what's left of someone in their matches once they've asked to be forgotten
+const (
+	ForgottenID   = "forgotten"
+	ForgottenName = "someone who asked to be forgotten"
+)
+
+// forget takes userID's id, name, email and check-in out of the match, and
+// says whether they were in it
+func (m *Match) forget(userID string) bool {
+	found := false
+	for i, id := range m.IDs {
+		if id == userID {
+			m.IDs[i] = ForgottenID
+			m.Names[i] = ForgottenName
+			m.Emails[i] = ""
+			found = true
+		}
+	}
+	delete(m.CheckIns, userID)
+	return found
+}
+
+// PartnerOf returns the index of whoever userID was matched with
+func (m *Match) PartnerOf(userID string) int {
+	if m.IDs[0] == userID {
+		return 1
+	}
+	return 0
+}
+
+// DB Lookups of matches
+
+// MatchDB is the "matches" collection, with a document for every pair made
+type MatchDB interface {
+	// Add saves a new match. its ID is made up by the database
+	Add(ctx context.Context, match Match) error
+	// ListSince is every match made at or after since, in no particular order
+	ListSince(ctx context.Context, since time.Time) ([]Match, error)
+	// ListByUserID is every match someone was in, newest first
+	ListByUserID(ctx context.Context, userID string) ([]Match, error)
+	// SetCheckIn saves someone's answer to the check-in about a match
+	SetCheckIn(ctx context.Context, matchID, userID string, checkIn CheckIn) error
+	// MarkCheckInSent notes that a match's check-in has gone out, so it's only sent once
+	MarkCheckInSent(ctx context.Context, matchID string) error
+	// Forget anonymises userID in every match they were in, so their partners'
+	// history still adds up. it returns how many matches it changed
+	Forget(ctx context.Context, userID string) (int, error)
+}
+
+// implements MatchDB
+type FirestoreMatchDB struct {
+	Client *firestore.Client
+}
+
+func (f *FirestoreMatchDB) Add(ctx context.Context, match Match) error {
+	_, _, err := f.Client.Collection("matches").Add(ctx, match.ConvertToMap())
+	return err
+}
+
+func (f *FirestoreMatchDB) ListSince(ctx context.Context, since time.Time) ([]Match, error) {
+	iter := f.Client.Collection("matches").Where("date", ">=", since).Documents(ctx)
+	return f.collect(iter)
+}
+
+// ListByUserID returns everyone's matches with userID in them, newest first.
+// this query needs a composite index on (ids, date) in firestore
+func (f *FirestoreMatchDB) ListByUserID(ctx context.Context, userID string) ([]Match, error) {
+	iter := f.Client.Collection("matches").Where("ids", "array-contains", userID).OrderBy("date", firestore.Desc).Documents(ctx)
+	return f.collect(iter)
+}
+
+func (f *FirestoreMatchDB) SetCheckIn(ctx context.Context, matchID, userID string, checkIn CheckIn) error {
+	_, err := f.Client.Collection("matches").Doc(matchID).Set(ctx, map[string]interface{}{
+		"checkIns": map[string]interface{}{
+			userID: map[string]interface{}{
+				"happened": checkIn.Happened,
+				"rating":   checkIn.Rating,
+			},
+		},
+	}, firestore.MergeAll)
+	return err
+}
+
+func (f *FirestoreMatchDB) MarkCheckInSent(ctx context.Context, matchID string) error {
+	_, err := f.Client.Collection("matches").Doc(matchID).Set(ctx, map[string]interface{}{
+		"checkInSent": true,
+	}, firestore.MergeAll)
+	return err
+}
+
+func (f *FirestoreMatchDB) Forget(ctx context.Context, userID string) (int, error) {
+	matches, err := f.ListByUserID(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	for i, m := range matches {
+		m.forget(userID)
+		// no MergeAll, so their check-in goes too
+		_, err = f.Client.Collection("matches").Doc(m.ID).Set(ctx, m.ConvertToMap())
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(matches), nil
+}
+
+func (f *FirestoreMatchDB) collect(iter *firestore.DocumentIterator) ([]Match, error) {
+	var matches []Match
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		match := MapToMatch(doc.Ref.ID, doc.Data())
+		if match.peopleProblem() != "" {
+			log.Printf("Skipping match %v, since I can't tell who was in it", match.ID)
+			continue
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// the audit log keeps track of everything admins do
+
+// AuditEntry is one admin command, and who ran it when
+type AuditEntry struct {
+	Date      time.Time
+	AdminID   string
+	AdminName string
+	Action    string
+}
+
+// ConvertToMap is a as a Firestore document
+func (a *AuditEntry) ConvertToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"date":      a.Date,
+		"adminID":   a.AdminID,
+		"adminName": a.AdminName,
+		"action":    a.Action,
+	}
+}
+
+// forget takes someone out of an audit entry: as the admin who did it, and
+// anywhere their ID or email is in what they did. it says whether they were in it
+func (a *AuditEntry) forget(userID, email string) bool {
+	found := false
+	if a.AdminID == userID {
+		a.AdminID = ForgottenID
+		a.AdminName = ForgottenName
+		found = true
+	}
+	words := strings.Fields(a.Action)
+	for i, w := range words {
+		if w == userID || (email != "" && strings.EqualFold(w, email)) {
+			words[i] = ForgottenID
+			found = true
+		}
+	}
+	if found {
+		a.Action = strings.Join(words, " ")
+	}
+	return found
+}
+
+// MapToAuditEntry reads an audit document, logging anything that's wrong with it
+func MapToAuditEntry(m map[string]interface{}) AuditEntry {
+	d := docReader{doc: m}
+	entry := AuditEntry{
+		Date:      d.time("date"),
+		AdminID:   d.string("adminID", ""),
+		AdminName: d.string("adminName", ""),
+		Action:    d.string("action", ""),
+	}
+	d.logProblems("an audit entry")
+	return entry
+}
+
+// AuditDB is the "audit" collection, a log of every admin command
+type AuditDB interface {
+	// Add saves an entry. entries are never changed, except by Forget
+	Add(ctx context.Context, entry AuditEntry) error
+	// Forget takes someone out of every audit entry they're in, and returns how many that was
+	Forget(ctx context.Context, userID, email string) (int, error)
+}
+
+// implements AuditDB
+type FirestoreAuditDB struct {
+	Client *firestore.Client
+}
+
+func (f *FirestoreAuditDB) Add(ctx context.Context, entry AuditEntry) error {
+	_, _, err := f.Client.Collection("auditlog").Add(ctx, entry.ConvertToMap())
+	return err
+}
+
+// Forget has to look at every entry, since the action is free text
+func (f *FirestoreAuditDB) Forget(ctx context.Context, userID, email string) (int, error) {
+	forgotten := 0
+	iter := f.Client.Collection("auditlog").Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return forgotten, err
+		}
+		entry := MapToAuditEntry(doc.Data())
+		if !entry.forget(userID, email) {
+			continue
+		}
+		_, err = doc.Ref.Set(ctx, entry.ConvertToMap())
+		if err != nil {
+			return forgotten, err
+		}
+		forgotten++
+	}
+	return forgotten, nil
+}
+
+// runtime settings live in the "config" collection, one document per feature,
+// so they can be changed without a redeploy
+
+// this is what we send to / receive from Firestore
+// var maintenance = map[string]interface{}{
+// 	"enabled":      false,
+// 	"message":      "string",
+// 	"allowedUsers": []string{"id1", "id2"},
+// }
+
+// Maintenance is the "maintenance" config document, what `admin maintenance` sets
+type Maintenance struct {
+	Enabled bool
+	// shown to people who message the bot during maintenance. empty means the default message
+	Message string
+	// zulip IDs of people who can still use the bot during maintenance, on top of the admins
+	AllowedUsers []string
+}
+
+// ConvertToMap is m as the "maintenance" document
+func (m *Maintenance) ConvertToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"enabled":      m.Enabled,
+		"message":      m.Message,
+		"allowedUsers": m.AllowedUsers,
+	}
+}
+
+// MapToMaintenance never panics, since every command reads it. anything
+// that's missing or the wrong type means maintenance mode is off
+func MapToMaintenance(m map[string]interface{}) Maintenance {
+	d := docReader{doc: m}
+	maintenance := Maintenance{
+		Enabled:      d.bool("enabled", false),
+		Message:      d.string("message", ""),
+		AllowedUsers: d.strings("allowedUsers"),
+	}
+	d.logProblems("config/maintenance")
+	return maintenance
+}
+
+// Announcements is how the stream announcement after matching is set up
+type Announcements struct {
+	Enabled bool
+	Stream  string
+	Topic   string
+	// a text/template, filled in with an announcementData. empty means defaultAnnouncementTemplate
+	Template string
+}
+
+// ConvertToMap is a as the "announcements" document
+func (a *Announcements) ConvertToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"enabled":  a.Enabled,
+		"stream":   a.Stream,
+		"topic":    a.Topic,
+		"template": a.Template,
+	}
+}
+
+// MapToAnnouncements reads the "announcements" document, logging anything that's wrong with it
+func MapToAnnouncements(m map[string]interface{}) Announcements {
+	d := docReader{doc: m}
+	announcements := Announcements{
+		Enabled:  d.bool("enabled", false),
+		Stream:   d.string("stream", ""),
+		Topic:    d.string("topic", ""),
+		Template: d.string("template", ""),
+	}
+	d.logProblems("config/announcements")
+	return announcements
+}
+
+// this is what we send to / receive from Firestore
+// var endOfBatchPlan = map[string]interface{}{
+// 	"code":      "string",
+// 	"created":   time.Time,
+// 	"createdBy": "string",
+// 	"offboard":  []map[string]interface{}{{"id": "string", "name": "string", "reason": "string"}},
+// 	"prompt":    []string{"id1", "id2"},
+// }
+
+// EndOfBatchPlan is who the end of batch will offboard, and who it'll ask
+// to stay. nothing happens until an admin confirms it with its code
+type EndOfBatchPlan struct {
+	// empty means there's no plan waiting
+	Code      string
+	Created   time.Time
+	CreatedBy string
+	Offboard  []PlannedOffboard
+	// the ids of the people who'll be asked whether they're staying
+	Prompt []string
+}
+
+// PlannedOffboard is someone a plan will offboard, and why (ReasonBatchEnded or ReasonNoStay)
+type PlannedOffboard struct {
+	ID     string
+	Name   string
+	Reason string
+}
+
+// ConvertToMap is p as the "endofbatch" document
+func (p *EndOfBatchPlan) ConvertToMap() map[string]interface{} {
+	// the same type Firestore gives back, so maps round trip
+	offboard := []interface{}{}
+	for _, o := range p.Offboard {
+		offboard = append(offboard, map[string]interface{}{
+			"id":     o.ID,
+			"name":   o.Name,
+			"reason": o.Reason,
+		})
+	}
+	return map[string]interface{}{
+		"code":      p.Code,
+		"created":   p.Created,
+		"createdBy": p.CreatedBy,
+		"offboard":  offboard,
+		"prompt":    append([]string{}, p.Prompt...),
+	}
+}
+
+// MapToEndOfBatchPlan reads the "endofbatch" document, logging anything that's wrong with it
+func MapToEndOfBatchPlan(m map[string]interface{}) EndOfBatchPlan {
+	d := docReader{doc: m}
+	p := EndOfBatchPlan{
+		Code:      d.string("code", ""),
+		Created:   d.time("created"),
+		CreatedBy: d.string("createdBy", ""),
+		Prompt:    d.strings("prompt"),
+	}
+	offboard, ok := m["offboard"].([]interface{})
+	if v, there := m["offboard"]; there && v != nil && !ok {
+		d.wrongType("offboard", v, "array")
+	}
+	for i, v := range offboard {
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			d.wrongType(fmt.Sprintf("offboard.%d", i), v, "map")
+			continue
+		}
+		od := docReader{doc: o}
+		p.Offboard = append(p.Offboard, PlannedOffboard{
+			ID:     od.string("id", ""),
+			Name:   od.string("name", ""),
+			Reason: od.string("reason", ""),
+		})
+		for _, problem := range od.problems {
+			d.problems = append(d.problems, fmt.Sprintf("offboard.%d.%v", i, problem))
+		}
+	}
+	d.logProblems("config/endofbatch")
+	return p
+}
+
+// ConfigDB is the "config" collection: settings that admins change from
+// chat, one document each. a missing document means the zero value
+type ConfigDB interface {
+	// GetMaintenance is the "maintenance" document
+	GetMaintenance(ctx context.Context) (Maintenance, error)
+	SetMaintenance(ctx context.Context, maintenance Maintenance) error
+	// GetAnnouncements is the "announcements" document
+	GetAnnouncements(ctx context.Context) (Announcements, error)
+	SetAnnouncements(ctx context.Context, announcements Announcements) error
+	// GetEndOfBatchPlan is the "endofbatch" document, the plan waiting to be confirmed
+	GetEndOfBatchPlan(ctx context.Context) (EndOfBatchPlan, error)
+	// setting an empty plan throws away the one that's waiting
+	SetEndOfBatchPlan(ctx context.Context, plan EndOfBatchPlan) error
+}
+
+// implements ConfigDB
+type FirestoreConfigDB struct {
+	Client *firestore.Client
+}
+
+func (f *FirestoreConfigDB) GetMaintenance(ctx context.Context) (Maintenance, error) {
+	doc, err := f.Client.Collection("config").Doc("maintenance").Get(ctx)
+	// no document just means maintenance mode has never been turned on
+	if status.Code(err) == codes.NotFound {
+		return Maintenance{}, nil
+	}
+	if err != nil {
+		return Maintenance{}, err
+	}
+	return MapToMaintenance(doc.Data()), nil
+}
+
+func (f *FirestoreConfigDB) SetMaintenance(ctx context.Context, maintenance Maintenance) error {
+	_, err := f.Client.Collection("config").Doc("maintenance").Set(ctx, maintenance.ConvertToMap())
+	return err
+}
+
+func (f *FirestoreConfigDB) GetAnnouncements(ctx context.Context) (Announcements, error) {
+	doc, err := f.Client.Collection("config").Doc("announcements").Get(ctx)
+	// no document just means announcements have never been set up
+	if status.Code(err) == codes.NotFound {
+		return Announcements{}, nil
+	}
+	if err != nil {
+		return Announcements{}, err
+	}
+	return MapToAnnouncements(doc.Data()), nil
+}
+
+func (f *FirestoreConfigDB) SetAnnouncements(ctx context.Context, announcements Announcements) error {
+	_, err := f.Client.Collection("config").Doc("announcements").Set(ctx, announcements.ConvertToMap())
+	return err
+}
+
+func (f *FirestoreConfigDB) GetEndOfBatchPlan(ctx context.Context) (EndOfBatchPlan, error) {
+	doc, err := f.Client.Collection("config").Doc("endofbatch").Get(ctx)
+	// no document just means nobody's made a plan yet
+	if status.Code(err) == codes.NotFound {
+		return EndOfBatchPlan{}, nil
+	}
+	if err != nil {
+		return EndOfBatchPlan{}, err
+	}
+	return MapToEndOfBatchPlan(doc.Data()), nil
+}
+
+func (f *FirestoreConfigDB) SetEndOfBatchPlan(ctx context.Context, plan EndOfBatchPlan) error {
+	_, err := f.Client.Collection("config").Doc("endofbatch").Set(ctx, plan.ConvertToMap())
+	return err
+}
+
+// the outbox holds messages that we couldn't deliver, even after retrying,
+// so they can be tried again later
+
+// OutboxMessage is a message waiting in the outbox
+type OutboxMessage struct {
+	ID string
+	// when it was first queued
+	Date      time.Time
+	To        string
+	Content   string
+	Attempts  int
+	LastError string
+	// someone's trying to send it until then. see OutboxDB.Claim
+	ClaimedUntil time.Time
+}
+
+// ConvertToMap is o as a Firestore document, without its ID
+func (o *OutboxMessage) ConvertToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"date":         o.Date,
+		"to":           o.To,
+		"content":      o.Content,
+		"attempts":     o.Attempts,
+		"lastError":    o.LastError,
+		"claimedUntil": o.ClaimedUntil,
+	}
+}
+
+// MapToOutboxMessage reads an outbox document, logging anything that's wrong with it
+func MapToOutboxMessage(id string, m map[string]interface{}) OutboxMessage {
+	d := docReader{doc: m}
+	msg := OutboxMessage{
+		ID:        id,
+		Date:      d.time("date"),
+		To:        d.string("to", ""),
+		Content:   d.string("content", ""),
+		Attempts:  d.int("attempts", 0),
+		LastError: d.string("lastError", ""),
+		// older messages were never claimed
+		ClaimedUntil: d.time("claimedUntil"),
+	}
+	d.logProblems("outbox message " + id)
+	return msg
+}
+
+// OutboxDB is the "outbox" collection
+type OutboxDB interface {
+	Add(ctx context.Context, msg OutboxMessage) error
+	List(ctx context.Context) ([]OutboxMessage, error)
+	// Update saves msg as it is, which also lets go of any claim on it
+	Update(ctx context.Context, msg OutboxMessage) error
+	Delete(ctx context.Context, id string) error
+	// Claim marks a message as being sent until a time, so that two retries
+	// running at once (the cron job and `admin outbox`, say) don't both send
+	// it. it's false if someone else already has it, or it's gone
+	Claim(ctx context.Context, id string, until time.Time) (bool, error)
+}
+
+// implements OutboxDB
+type FirestoreOutboxDB struct {
+	Client *firestore.Client
+}
+
+func (f *FirestoreOutboxDB) Add(ctx context.Context, msg OutboxMessage) error {
+	_, _, err := f.Client.Collection("outbox").Add(ctx, msg.ConvertToMap())
+	return err
+}
+
+// List returns everything in the outbox, oldest first
+func (f *FirestoreOutboxDB) List(ctx context.Context) ([]OutboxMessage, error) {
+	var msgs []OutboxMessage
+	iter := f.Client.Collection("outbox").OrderBy("date", firestore.Asc).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, MapToOutboxMessage(doc.Ref.ID, doc.Data()))
+	}
+	return msgs, nil
+}
+
+func (f *FirestoreOutboxDB) Update(ctx context.Context, msg OutboxMessage) error {
+	_, err := f.Client.Collection("outbox").Doc(msg.ID).Set(ctx, msg.ConvertToMap())
+	return err
+}
+
+func (f *FirestoreOutboxDB) Delete(ctx context.Context, id string) error {
+	_, err := f.Client.Collection("outbox").Doc(id).Delete(ctx)
+	return err
+}
+
+func (f *FirestoreOutboxDB) Claim(ctx context.Context, id string, until time.Time) (bool, error) {
+	ref := f.Client.Collection("outbox").Doc(id)
+	claimed := false
+	err := f.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// the transaction can run more than once
+		claimed = false
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if MapToOutboxMessage(id, doc.Data()).ClaimedUntil.After(time.Now()) {
+			return nil
+		}
+		claimed = true
+		return tx.Update(ref, []firestore.Update{{Path: "claimedUntil", Value: until}})
+	})
+	return claimed, err
+}
+
+// rooms remembers the conversations we've opened on chat platforms where
+// that's up to us (like Matrix), so that we keep talking to the same people
+// in the same place instead of opening a new one every time
+
+// RoomDB maps a set of people on a platform to the room they share with the bot.
+// members is their platform user IDs, sorted and comma-separated
+type RoomDB interface {
+	GetRoom(ctx context.Context, platform, members string) (string, error)
+	SetRoom(ctx context.Context, platform, members, roomID string) error
+	// Forget deletes every room on platform that userID (without the prefix) is
+	// in, and returns how many there were
+	Forget(ctx context.Context, platform, userID string) (int, error)
+}
+
+// implements RoomDB
+type FirestoreRoomDB struct {
+	Client *firestore.Client
+}
+
+// GetRoom returns "" if there's no room for these people yet
+func (f *FirestoreRoomDB) GetRoom(ctx context.Context, platform, members string) (string, error) {
+	doc, err := f.Client.Collection("rooms").Doc(platform + ":" + members).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	room, _ := doc.Data()["room"].(string)
+	return room, nil
+}
+
+func (f *FirestoreRoomDB) SetRoom(ctx context.Context, platform, members, roomID string) error {
+	_, err := f.Client.Collection("rooms").Doc(platform+":"+members).Set(ctx, map[string]interface{}{
+		"platform": platform,
+		"members":  members,
+		"room":     roomID,
+	})
+	return err
+}
+
+func (f *FirestoreRoomDB) Forget(ctx context.Context, platform, userID string) (int, error) {
+	deleted := 0
+	iter := f.Client.Collection("rooms").Where("platform", "==", platform).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return deleted, err
+		}
+		members, _ := doc.Data()["members"].(string)
+		if !inMembers(members, userID) {
+			continue
+		}
+		_, err = doc.Ref.Delete(ctx)
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// inMembers is whether userID is one of a room's comma-separated members
+func inMembers(members, userID string) bool {
+	for _, m := range strings.Split(members, ",") {
+		if m == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// DB Lookups of tokens
+
+// APIAuthDB is where secrets live: tokens and passwords, one to a document,
+// in collections like "botauth" and "slackauth"
+type APIAuthDB interface {
+	// GetKey is the "value" field of the document doc in the collection col
+	GetKey(ctx context.Context, col, doc string) (string, error)
+}
+
+// implements APIAuthDB
+type FirestoreAPIAuthDB struct {
+	Client *firestore.Client
+}
+
+func (f *FirestoreAPIAuthDB) GetKey(ctx context.Context, col, doc string) (string, error) {
+	res, err := f.Client.Collection(col).Doc(doc).Get(ctx)
+	if err != nil {
+		log.Println("Something weird happened trying to read the auth token from the database")
+		return "", err
+	}
+
+	token := res.Data()
+	return token["value"].(string), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditEntryForget(t *testing.T) {
+	tests := []struct {
+		testName   string
+		entry      AuditEntry
+		wanted     AuditEntry
+		wantChange bool
+	}{
+		{"admin", AuditEntry{AdminID: "2", AdminName: "Ada", Action: "list"}, AuditEntry{AdminID: ForgottenID, AdminName: ForgottenName, Action: "list"}, true},
+		{"by_id", AuditEntry{AdminID: "1", Action: "show 2"}, AuditEntry{AdminID: "1", Action: "show " + ForgottenID}, true},
+		{"by_email", AuditEntry{AdminID: "1", Action: "unsubscribe ada@example.com"}, AuditEntry{AdminID: "1", Action: "unsubscribe " + ForgottenID}, true},
+		{"only_whole_words", AuditEntry{AdminID: "1", Action: "show 22"}, AuditEntry{AdminID: "1", Action: "show 22"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			got := tt.entry
+			if changed := got.forget("2", "Ada@example.com"); changed != tt.wantChange || got != tt.wanted {
+				t.Errorf("got %+v (changed: %v), wanted %+v", got, changed, tt.wanted)
+			}
+		})
+	}
+}
+
+// documents that were edited by hand, or written by an older version of the
+// bot, get defaults instead of panicking
+func TestMapBrokenDocs(t *testing.T) {
+	tests := []struct {
+		testName string
+		decode   func(m map[string]interface{}) interface{}
+		doc      map[string]interface{}
+		wanted   interface{}
+	}{
+		{"audit_entry",
+			func(m map[string]interface{}) interface{} { return MapToAuditEntry(m) },
+			map[string]interface{}{"adminID": int64(1), "action": "list"},
+			AuditEntry{Action: "list"}},
+		{"maintenance",
+			func(m map[string]interface{}) interface{} { return MapToMaintenance(m) },
+			map[string]interface{}{"enabled": "yes"},
+			Maintenance{}},
+		{"announcements",
+			func(m map[string]interface{}) interface{} { return MapToAnnouncements(m) },
+			map[string]interface{}{"enabled": true, "stream": "pairing", "topic": nil},
+			Announcements{Enabled: true, Stream: "pairing"}},
+		{"end_of_batch_plan",
+			func(m map[string]interface{}) interface{} { return MapToEndOfBatchPlan(m) },
+			map[string]interface{}{"code": "abc123", "createdBy": int64(1), "offboard": []interface{}{"2", map[string]interface{}{"id": "3", "reason": nil}}},
+			EndOfBatchPlan{Code: "abc123", Offboard: []PlannedOffboard{{ID: "3"}}}},
+		{"outbox_message",
+			func(m map[string]interface{}) interface{} { return MapToOutboxMessage("o1", m) },
+			map[string]interface{}{"to": "ada@example.com", "attempts": 3, "lastError": nil},
+			OutboxMessage{ID: "o1", To: "ada@example.com", Attempts: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if got := tt.decode(tt.doc); !reflect.DeepEqual(got, tt.wanted) {
+				t.Errorf("got %+v, wanted %+v", got, tt.wanted)
+			}
+		})
+	}
+}
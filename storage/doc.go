@@ -0,0 +1,5 @@
+// Package storage is where pairing bot keeps everything: subscribers,
+// matches, check-ins, the outbox, config and secrets. each collection is an
+// interface with a Firestore implementation, and most have an in-memory one
+// for tests, backups and the command line.
+package storage
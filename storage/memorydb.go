@@ -0,0 +1,570 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In-memory versions of all of Pairing Bot's databases. They behave like the
+// Firestore ones (documents are merged on Set, missing documents aren't errors
+// where Firestore's aren't), so they're handy for tests and for running the
+// bot locally without a Firestore project.
+
+// implements RecurserDB
+type MemoryRecurserDB struct {
+	mu sync.Mutex
+	// keyed by recurser id, in the same shape as the Firestore documents
+	docs map[string]map[string]interface{}
+	// the "oddoneouts" collection
+	oddOneOuts []map[string]interface{}
+	// the "offboarded" collection, keyed by recurser id
+	offboarded map[string]map[string]interface{}
+}
+
+// NewMemoryRecurserDB is an empty MemoryRecurserDB, with nobody subscribed
+func NewMemoryRecurserDB() *MemoryRecurserDB {
+	return &MemoryRecurserDB{
+		docs:       map[string]map[string]interface{}{},
+		offboarded: map[string]map[string]interface{}{},
+	}
+}
+
+// copyDoc copies a document deeply enough that nobody can change what's
+// stored by holding on to a map they passed in or got out
+func copyDoc(doc map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		if m, ok := v.(map[string]interface{}); ok {
+			v = copyDoc(m)
+		}
+		c[k] = v
+	}
+	return c
+}
+
+func (m *MemoryRecurserDB) GetByUserID(ctx context.Context, userID, userEmail, userName string) (Recurser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc, isSubscribed := m.docs[userID]
+	var r Recurser
+	if isSubscribed {
+		// like Firestore, this saves the document once it's migrated
+		migrateRecurser(doc)
+		recurser := copyDoc(doc)
+		recurser["name"] = userName
+		recurser["email"] = userEmail
+		r = MapToStruct(recurser)
+	} else {
+		r = NewRecurser(userID, userEmail, userName)
+	}
+	r.IsSubscribed = isSubscribed
+	return r, nil
+}
+
+func (m *MemoryRecurserDB) GetAllUsers(ctx context.Context) ([]Recurser, error) {
+	return m.list(func(map[string]interface{}) bool { return true }), nil
+}
+
+// list returns every recurser whose document matches, ordered by id
+// so the results don't depend on map order
+func (m *MemoryRecurserDB) list(match func(map[string]interface{}) bool) []Recurser {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []string
+	for id := range m.docs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var recursersList []Recurser
+	for _, id := range ids {
+		if match(m.docs[id]) {
+			recursersList = append(recursersList, MapToStruct(copyDoc(m.docs[id])))
+		}
+	}
+	return recursersList
+}
+
+// merge works like Firestore's Set with MergeAll
+func (m *MemoryRecurserDB) merge(userID string, fields map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc, ok := m.docs[userID]
+	if !ok {
+		doc = map[string]interface{}{}
+		m.docs[userID] = doc
+	}
+	for k, v := range copyDoc(fields) {
+		doc[k] = v
+	}
+}
+
+func (m *MemoryRecurserDB) Set(ctx context.Context, userID string, recurser Recurser) error {
+	m.merge(userID, recurser.ConvertToMap())
+	return nil
+}
+
+func (m *MemoryRecurserDB) Delete(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.docs, userID)
+	return nil
+}
+
+func (m *MemoryRecurserDB) ListPairingTomorrow(ctx context.Context) ([]Recurser, error) {
+	today := strings.ToLower(time.Now().Weekday().String())
+	return m.ListPairingOn(ctx, today)
+}
+
+func (m *MemoryRecurserDB) ListPairingOn(ctx context.Context, day string) ([]Recurser, error) {
+	return m.list(func(doc map[string]interface{}) bool {
+		schedule, _ := doc["schedule"].(map[string]interface{})
+		return doc["isSkippingTomorrow"] == false && schedule[day] == true
+	}), nil
+}
+
+func (m *MemoryRecurserDB) ListSkippingTomorrow(ctx context.Context) ([]Recurser, error) {
+	return m.list(func(doc map[string]interface{}) bool {
+		return doc["isSkippingTomorrow"] == true
+	}), nil
+}
+
+func (m *MemoryRecurserDB) UnsetSkippingTomorrow(ctx context.Context, recurser Recurser) error {
+	r := recurser.ConvertToMap()
+	r["isSkippingTomorrow"] = false
+	m.merge(recurser.ID, r)
+	return nil
+}
+
+func (m *MemoryRecurserDB) RecordOddOneOut(ctx context.Context, recurser Recurser, when time.Time) error {
+	m.mu.Lock()
+	m.oddOneOuts = append(m.oddOneOuts, map[string]interface{}{
+		"id":   recurser.ID,
+		"date": when,
+	})
+	m.mu.Unlock()
+
+	m.merge(recurser.ID, map[string]interface{}{"lastOddOneOut": when})
+	return nil
+}
+
+func (m *MemoryRecurserDB) Offboard(ctx context.Context, userID, reason string, when time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	doc, ok := m.docs[userID]
+	if !ok {
+		return nil
+	}
+	doc = copyDoc(doc)
+	doc["offboardedAt"] = when
+	doc["offboardReason"] = reason
+	m.offboarded[userID] = doc
+	delete(m.docs, userID)
+	return nil
+}
+
+func (m *MemoryRecurserDB) ListOffboarded(ctx context.Context) ([]OffboardedRecurser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var offboarded []OffboardedRecurser
+	for _, doc := range m.offboarded {
+		offboarded = append(offboarded, MapToOffboarded(copyDoc(doc)))
+	}
+	// most recent first, like Firestore's
+	sort.Slice(offboarded, func(i, j int) bool {
+		if !offboarded[i].OffboardedAt.Equal(offboarded[j].OffboardedAt) {
+			return offboarded[i].OffboardedAt.After(offboarded[j].OffboardedAt)
+		}
+		return offboarded[i].Recurser.ID < offboarded[j].Recurser.ID
+	})
+	return offboarded, nil
+}
+
+func (m *MemoryRecurserDB) Restore(ctx context.Context, userID string) (Recurser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.docs[userID]; ok {
+		return Recurser{}, ErrStillSubscribed
+	}
+	doc, ok := m.offboarded[userID]
+	if !ok {
+		return Recurser{}, fmt.Errorf("%v isn't offboarded", userID)
+	}
+	doc = copyDoc(doc)
+	delete(doc, "offboardedAt")
+	delete(doc, "offboardReason")
+	m.docs[userID] = doc
+	delete(m.offboarded, userID)
+	return MapToStruct(copyDoc(doc)), nil
+}
+
+func (m *MemoryRecurserDB) Forget(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.docs, userID)
+	delete(m.offboarded, userID)
+	var oddOneOuts []map[string]interface{}
+	for _, o := range m.oddOneOuts {
+		if o["id"] != userID {
+			oddOneOuts = append(oddOneOuts, o)
+		}
+	}
+	m.oddOneOuts = oddOneOuts
+	return nil
+}
+
+func (m *MemoryRecurserDB) Migrate(ctx context.Context, dryRun bool) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	migrated := 0
+	for _, docs := range []map[string]map[string]interface{}{m.docs, m.offboarded} {
+		for id, doc := range docs {
+			c := copyDoc(doc)
+			if !migrateRecurser(c) {
+				continue
+			}
+			migrated++
+			if !dryRun {
+				docs[id] = c
+			}
+		}
+	}
+	return migrated, nil
+}
+
+func (m *MemoryRecurserDB) PurgeOffboarded(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for id, doc := range m.offboarded {
+		if doc["offboardedAt"].(time.Time).Before(before) {
+			delete(m.offboarded, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// implements MatchDB
+type MemoryMatchDB struct {
+	mu      sync.Mutex
+	matches []Match
+	nextID  int
+}
+
+// NewMemoryMatchDB is an empty MemoryMatchDB
+func NewMemoryMatchDB() *MemoryMatchDB {
+	return &MemoryMatchDB{}
+}
+
+// copyMatch makes sure the check-ins map isn't shared with whoever asked
+func copyMatch(match Match) Match {
+	checkIns := map[string]CheckIn{}
+	for id, c := range match.CheckIns {
+		checkIns[id] = c
+	}
+	match.CheckIns = checkIns
+	return match
+}
+
+func (m *MemoryMatchDB) Add(ctx context.Context, match Match) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	match.ID = strconv.Itoa(m.nextID)
+	m.matches = append(m.matches, copyMatch(match))
+	return nil
+}
+
+func (m *MemoryMatchDB) ListSince(ctx context.Context, since time.Time) ([]Match, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matches []Match
+	for _, match := range m.matches {
+		if !match.Date.Before(since) && match.peopleProblem() == "" {
+			matches = append(matches, copyMatch(match))
+		}
+	}
+	return matches, nil
+}
+
+func (m *MemoryMatchDB) ListByUserID(ctx context.Context, userID string) ([]Match, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matches []Match
+	for _, match := range m.matches {
+		if contains(match.IDs, userID) && match.peopleProblem() == "" {
+			matches = append(matches, copyMatch(match))
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Date.After(matches[j].Date) })
+	return matches, nil
+}
+
+func (m *MemoryMatchDB) SetCheckIn(ctx context.Context, matchID, userID string, checkIn CheckIn) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.matches {
+		if m.matches[i].ID == matchID {
+			m.matches[i].CheckIns[userID] = checkIn
+		}
+	}
+	return nil
+}
+
+func (m *MemoryMatchDB) Forget(ctx context.Context, userID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	forgotten := 0
+	for i := range m.matches {
+		if m.matches[i].peopleProblem() == "" && m.matches[i].forget(userID) {
+			forgotten++
+		}
+	}
+	return forgotten, nil
+}
+
+func (m *MemoryMatchDB) MarkCheckInSent(ctx context.Context, matchID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.matches {
+		if m.matches[i].ID == matchID {
+			m.matches[i].CheckInSent = true
+		}
+	}
+	return nil
+}
+
+// implements AuditDB
+type MemoryAuditDB struct {
+	mu      sync.Mutex
+	Entries []AuditEntry
+}
+
+// NewMemoryAuditDB is an empty MemoryAuditDB
+func NewMemoryAuditDB() *MemoryAuditDB {
+	return &MemoryAuditDB{}
+}
+
+func (m *MemoryAuditDB) Add(ctx context.Context, entry AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Entries = append(m.Entries, entry)
+	return nil
+}
+
+func (m *MemoryAuditDB) Forget(ctx context.Context, userID, email string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	forgotten := 0
+	for i := range m.Entries {
+		if m.Entries[i].forget(userID, email) {
+			forgotten++
+		}
+	}
+	return forgotten, nil
+}
+
+// implements ConfigDB
+type MemoryConfigDB struct {
+	mu             sync.Mutex
+	maintenance    Maintenance
+	announcements  Announcements
+	endOfBatchPlan EndOfBatchPlan
+}
+
+// NewMemoryConfigDB is a MemoryConfigDB where everything is off
+func NewMemoryConfigDB() *MemoryConfigDB {
+	return &MemoryConfigDB{}
+}
+
+func (m *MemoryConfigDB) GetMaintenance(ctx context.Context) (Maintenance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	maintenance := m.maintenance
+	maintenance.AllowedUsers = append([]string(nil), m.maintenance.AllowedUsers...)
+	return maintenance, nil
+}
+
+func (m *MemoryConfigDB) SetMaintenance(ctx context.Context, maintenance Maintenance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	maintenance.AllowedUsers = append([]string(nil), maintenance.AllowedUsers...)
+	m.maintenance = maintenance
+	return nil
+}
+
+func (m *MemoryConfigDB) GetAnnouncements(ctx context.Context) (Announcements, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.announcements, nil
+}
+
+func (m *MemoryConfigDB) SetAnnouncements(ctx context.Context, announcements Announcements) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.announcements = announcements
+	return nil
+}
+
+func (m *MemoryConfigDB) GetEndOfBatchPlan(ctx context.Context) (EndOfBatchPlan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return MapToEndOfBatchPlan(m.endOfBatchPlan.ConvertToMap()), nil
+}
+
+func (m *MemoryConfigDB) SetEndOfBatchPlan(ctx context.Context, plan EndOfBatchPlan) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// a round trip through the Firestore shape copies it
+	m.endOfBatchPlan = MapToEndOfBatchPlan(plan.ConvertToMap())
+	return nil
+}
+
+// implements OutboxDB
+type MemoryOutboxDB struct {
+	mu     sync.Mutex
+	msgs   []OutboxMessage
+	nextID int
+}
+
+// NewMemoryOutboxDB is an empty MemoryOutboxDB
+func NewMemoryOutboxDB() *MemoryOutboxDB {
+	return &MemoryOutboxDB{}
+}
+
+func (m *MemoryOutboxDB) Add(ctx context.Context, msg OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	msg.ID = strconv.Itoa(m.nextID)
+	m.msgs = append(m.msgs, msg)
+	return nil
+}
+
+func (m *MemoryOutboxDB) List(ctx context.Context) ([]OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]OutboxMessage(nil), m.msgs...), nil
+}
+
+func (m *MemoryOutboxDB) Update(ctx context.Context, msg OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.msgs {
+		if m.msgs[i].ID == msg.ID {
+			m.msgs[i] = msg
+		}
+	}
+	return nil
+}
+
+func (m *MemoryOutboxDB) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.msgs {
+		if m.msgs[i].ID == id {
+			m.msgs = append(m.msgs[:i], m.msgs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryOutboxDB) Claim(ctx context.Context, id string, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.msgs {
+		if m.msgs[i].ID != id {
+			continue
+		}
+		if m.msgs[i].ClaimedUntil.After(time.Now()) {
+			return false, nil
+		}
+		m.msgs[i].ClaimedUntil = until
+		return true, nil
+	}
+	return false, nil
+}
+
+// implements RoomDB
+type MemoryRoomDB struct {
+	mu    sync.Mutex
+	rooms map[string]string
+}
+
+// NewMemoryRoomDB is a MemoryRoomDB with no rooms in it
+func NewMemoryRoomDB() *MemoryRoomDB {
+	return &MemoryRoomDB{rooms: map[string]string{}}
+}
+
+func (m *MemoryRoomDB) GetRoom(ctx context.Context, platform, members string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rooms[platform+":"+members], nil
+}
+
+func (m *MemoryRoomDB) SetRoom(ctx context.Context, platform, members, roomID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rooms[platform+":"+members] = roomID
+	return nil
+}
+
+func (m *MemoryRoomDB) Forget(ctx context.Context, platform, userID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for key := range m.rooms {
+		if strings.HasPrefix(key, platform+":") && inMembers(strings.TrimPrefix(key, platform+":"), userID) {
+			delete(m.rooms, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// implements APIAuthDB
+type MemoryAPIAuthDB struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+// NewMemoryAPIAuthDB is a MemoryAPIAuthDB with no keys. add them with SetKey
+func NewMemoryAPIAuthDB() *MemoryAPIAuthDB {
+	return &MemoryAPIAuthDB{keys: map[string]string{}}
+}
+
+func (m *MemoryAPIAuthDB) GetKey(ctx context.Context, col, doc string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.keys[col+"/"+doc], nil
+}
+
+// SetKey stores a key, like prepopulating the "botauth" and "apiauth" collections in Firestore
+func (m *MemoryAPIAuthDB) SetKey(col, doc, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[col+"/"+doc] = value
+}
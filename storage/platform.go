@@ -0,0 +1,34 @@
+package storage
+
+import "strings"
+
+// Pairing Bot started out on Zulip, and Zulip is still the default: Zulip
+// users' IDs are plain Zulip user IDs and we message them by email. Other chat
+// platforms plug in next to it. Their users' IDs are prefixed with the
+// platform's name (like "slack:U024BE7LH"), which keeps them from colliding
+// with Zulip's, and tells us where to send their messages.
+
+// ZulipPlatform is what PlatformOf says for a plain Zulip user ID
+const ZulipPlatform = "zulip"
+
+// PlatformUserID builds the ID we store for someone on a platform other than Zulip
+func PlatformUserID(platform, id string) string {
+	return platform + ":" + id
+}
+
+// PlatformOf tells which chat platform a recurser ID belongs to
+func PlatformOf(userID string) string {
+	if i := strings.Index(userID, ":"); i >= 0 {
+		return userID[:i]
+	}
+	return ZulipPlatform
+}
+
+// Address is what we pass to UserNotification to message this recurser:
+// their email on Zulip, and their prefixed ID everywhere else
+func (r *Recurser) Address() string {
+	if PlatformOf(r.ID) == ZulipPlatform {
+		return r.Email
+	}
+	return r.ID
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// recurser documents (in "recursers", and in "offboarded") have a
+// "schemaVersion", so that when we change what's in them, older documents can
+// be brought up to date instead of breaking MapToStruct. documents from before
+// we started counting have no schemaVersion, which is version 0.
+//
+// to change what's in a recurser document, change ConvertToMap and
+// MapToStruct, and add a migration to the end of recurserMigrations that turns
+// the last version into the new one. schema_test.go has a fixture of every
+// shape we've ever written: add the new one there too.
+//
+// documents are migrated whenever they're decoded. GetByUserID also saves the
+// migrated document, and RecurserDB.Migrate does the same for everyone at once
+
+// a migration changes a document in place, from the version before it to its own
+type migration func(doc map[string]interface{})
+
+// recurserMigrations[i] takes a document from version i to version i+1
+var recurserMigrations = []migration{
+	fillRecurserDefaults,
+}
+
+// the version ConvertToMap writes
+var CurrentRecurserSchema = len(recurserMigrations)
+
+// fillRecurserDefaults is version 0 to 1. before versioning, every new field
+// was just left out of older documents, and MapToStruct guessed. it also means
+// Firestore queries skip them, so someone with no isSkippingTomorrow was never
+// matched. this writes every field, with the value MapToStruct used to guess
+func fillRecurserDefaults(doc map[string]interface{}) {
+	defaults := map[string]interface{}{
+		"isSkippingTomorrow": false,
+		"notifyVia":          NotifyViaZulip,
+		"announce":           false,
+		"digest":             true,
+		"reminders":          false,
+		"batch":              "",
+		"askedToStay":        false,
+	}
+	for field, value := range defaults {
+		if _, ok := doc[field]; !ok {
+			doc[field] = value
+		}
+	}
+
+	schedule, ok := doc["schedule"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, day := range Weekdays() {
+		if _, ok := schedule[day]; !ok {
+			schedule[day] = false
+		}
+	}
+}
+
+// schemaVersion is the version doc says it is. Firestore gives us int64s, but
+// documents in memory have ints
+func schemaVersion(doc map[string]interface{}) int {
+	switch v := doc["schemaVersion"].(type) {
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case float64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+// migrateRecurser brings doc up to the current version in place, and says
+// whether it changed anything. a document from a newer version of the bot
+// (halfway through a deploy, say) is left as it is
+func migrateRecurser(doc map[string]interface{}) bool {
+	return migrateDoc(doc, recurserMigrations)
+}
+
+func migrateDoc(doc map[string]interface{}, migrations []migration) bool {
+	version := schemaVersion(doc)
+	if version >= len(migrations) {
+		return false
+	}
+	for _, migrate := range migrations[version:] {
+		migrate(doc)
+	}
+	doc["schemaVersion"] = len(migrations)
+	return true
+}
+
+// docReader reads fields out of a document without trusting it. a field
+// that's missing gets its default, and one with the wrong type gets its
+// default and a problem
+type docReader struct {
+	doc      map[string]interface{}
+	problems []string
+}
+
+func (d *docReader) wrongType(field string, v interface{}, wanted string) {
+	d.problems = append(d.problems, fmt.Sprintf("%v is a %T, not a %v", field, v, wanted))
+}
+
+func (d *docReader) string(field string, def string) string {
+	v, ok := d.doc[field]
+	if !ok {
+		return def
+	}
+	s, ok := v.(string)
+	if !ok {
+		d.wrongType(field, v, "string")
+		return def
+	}
+	return s
+}
+
+func (d *docReader) bool(field string, def bool) bool {
+	v, ok := d.doc[field]
+	if !ok {
+		return def
+	}
+	b, ok := v.(bool)
+	if !ok {
+		d.wrongType(field, v, "bool")
+		return def
+	}
+	return b
+}
+
+// int takes any kind of number. Firestore gives us int64s, but documents in
+// memory have ints
+func (d *docReader) int(field string, def int) int {
+	v, ok := d.doc[field]
+	if !ok {
+		return def
+	}
+	switch n := v.(type) {
+	case int64:
+		return int(n)
+	case int:
+		return n
+	case float64:
+		return int(n)
+	default:
+		d.wrongType(field, v, "number")
+		return def
+	}
+}
+
+func (d *docReader) time(field string) time.Time {
+	v, ok := d.doc[field]
+	if !ok {
+		return time.Time{}
+	}
+	t, ok := v.(time.Time)
+	if !ok {
+		d.wrongType(field, v, "time")
+		return time.Time{}
+	}
+	return t
+}
+
+func (d *docReader) strings(field string) []string {
+	v, ok := d.doc[field]
+	if !ok {
+		return nil
+	}
+	switch v.(type) {
+	case []string, []interface{}:
+	default:
+		d.wrongType(field, v, "list")
+		return nil
+	}
+	list, _ := v.([]interface{})
+	for i, item := range list {
+		if _, ok := item.(string); !ok {
+			d.wrongType(fmt.Sprintf("%v.%d", field, i), item, "string")
+		}
+	}
+	return toStringSlice(v)
+}
+
+// schedule always has all seven days. anything that isn't true is false
+func (d *docReader) schedule(field string) map[string]interface{} {
+	schedule := map[string]interface{}{}
+	for _, day := range Weekdays() {
+		schedule[day] = false
+	}
+	v, ok := d.doc[field]
+	if !ok {
+		d.problems = append(d.problems, field+" is missing")
+		return schedule
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		d.wrongType(field, v, "map")
+		return schedule
+	}
+	var unknown []string
+	for day, on := range m {
+		if _, ok := schedule[day]; !ok {
+			unknown = append(unknown, day)
+			continue
+		}
+		schedule[day], ok = on.(bool)
+		if !ok {
+			d.wrongType(field+"."+day, on, "bool")
+			schedule[day] = false
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		d.problems = append(d.problems, fmt.Sprintf("%v has days that aren't days: %v", field, unknown))
+	}
+	return schedule
+}
+
+// logProblems says what was wrong with a document, if anything was
+func (d *docReader) logProblems(what string) {
+	if len(d.problems) > 0 {
+		log.Printf("Problems reading %v, so I used defaults: %v", what, d.problems)
+	}
+}
@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var fixtureTime = time.Date(2024, 3, 1, 4, 0, 0, 0, time.UTC)
+
+func fixtureSchedule(days ...string) map[string]interface{} {
+	schedule := map[string]interface{}{}
+	for _, day := range Weekdays() {
+		schedule[day] = false
+	}
+	for _, day := range days {
+		schedule[day] = true
+	}
+	return schedule
+}
+
+// recurserFixtures are every shape of recurser document we've written, as
+// Firestore hands them back (arrays are []interface{}, numbers are int64).
+// each is what the one before it had, plus whatever was added next
+func recurserFixtures() []struct {
+	shape string
+	doc   map[string]interface{}
+} {
+	first := map[string]interface{}{
+		"id":                 "2",
+		"name":               "Ada",
+		"email":              "ada@example.com",
+		"isSkippingTomorrow": false,
+		"schedule":           fixtureSchedule("monday", "friday"),
+	}
+	oddOneOut := copyDoc(first)
+	oddOneOut["lastOddOneOut"] = fixtureTime
+	notifyVia := copyDoc(oddOneOut)
+	notifyVia["notifyVia"] = NotifyViaEmail
+	announce := copyDoc(notifyVia)
+	announce["announce"] = true
+	digest := copyDoc(announce)
+	digest["digest"] = false
+	digest["triedCommands"] = []interface{}{"skip"}
+	reminders := copyDoc(digest)
+	reminders["reminders"] = true
+	batch := copyDoc(reminders)
+	batch["batch"] = "W1'24"
+	batch["batchEnd"] = fixtureTime.AddDate(0, 0, 28)
+	batch["askedToStay"] = true
+	version1 := copyDoc(batch)
+	version1["schemaVersion"] = int64(1)
+
+	return []struct {
+		shape string
+		doc   map[string]interface{}
+	}{
+		{"first_version", first},
+		{"odd_one_out", oddOneOut},
+		{"notify_via", notifyVia},
+		{"announce", announce},
+		{"digest", digest},
+		{"reminders", reminders},
+		{"batch", batch},
+		{"version_1", version1},
+	}
+}
+
+func TestRecurserFixtures(t *testing.T) {
+	for _, f := range recurserFixtures() {
+		t.Run(f.shape, func(t *testing.T) {
+			r, problems := decodeRecurser(f.doc)
+			if len(problems) > 0 {
+				t.Errorf("got problems %v", problems)
+			}
+			// every field ought to be what the document says, or what
+			// MapToStruct always said it was when the document didn't say
+			_, hasOddOneOut := f.doc["lastOddOneOut"]
+			_, hasNotifyVia := f.doc["notifyVia"]
+			_, hasDigest := f.doc["digest"]
+			_, hasBatch := f.doc["batch"]
+			if r.ID != "2" || r.Name != "Ada" || !r.Schedule["friday"].(bool) || r.Schedule["sunday"].(bool) {
+				t.Errorf("got %+v", r)
+			}
+			if r.LastOddOneOut.IsZero() == hasOddOneOut {
+				t.Errorf("got lastOddOneOut %v", r.LastOddOneOut)
+			}
+			if (r.NotifyVia == NotifyViaEmail) != hasNotifyVia {
+				t.Errorf("got notifyVia %q", r.NotifyVia)
+			}
+			if r.Digest == hasDigest || (len(r.TriedCommands) == 1) != hasDigest {
+				t.Errorf("got digest %v and triedCommands %v", r.Digest, r.TriedCommands)
+			}
+			if (r.Batch == "W1'24") != hasBatch || r.AskedToStay != hasBatch {
+				t.Errorf("got batch %q and askedToStay %v", r.Batch, r.AskedToStay)
+			}
+
+			// a migrated document has everything ConvertToMap would write,
+			// so Firestore's queries can find it
+			doc := copyDoc(f.doc)
+			migrated := migrateRecurser(doc)
+			if migrated != (schemaVersion(f.doc) < CurrentRecurserSchema) {
+				t.Errorf("migrated: %v", migrated)
+			}
+			if got, wanted := keys(doc), keys(r.ConvertToMap()); !reflect.DeepEqual(got, wanted) {
+				t.Errorf("the migrated document has %v, wanted %v", got, wanted)
+			}
+			if again, _ := decodeRecurser(doc); !reflect.DeepEqual(again, r) {
+				t.Errorf("migrating changed what the document means: %+v", again)
+			}
+		})
+	}
+}
+
+func keys(m map[string]interface{}) []string {
+	var k []string
+	for key := range m {
+		// only written when they're set
+		if key != "lastOddOneOut" && key != "batchEnd" && key != "triedCommands" {
+			k = append(k, key)
+		}
+	}
+	sort.Strings(k)
+	return k
+}
+
+func TestDecodeBrokenRecursers(t *testing.T) {
+	tests := []struct {
+		testName string
+		doc      map[string]interface{}
+		problem  string
+	}{
+		{"no_schedule", map[string]interface{}{"id": "2"}, "schedule is missing"},
+		{"no_id", map[string]interface{}{"schedule": fixtureSchedule()}, "id is missing"},
+		{"wrong_type", map[string]interface{}{"id": "2", "name": int64(7), "schedule": fixtureSchedule()}, "name is a int64, not a string"},
+		{"bad_day", map[string]interface{}{"id": "2", "schedule": map[string]interface{}{"caturday": true}}, "aren't days: [caturday]"},
+		{"day_not_bool", map[string]interface{}{"id": "2", "schedule": map[string]interface{}{"monday": "yes"}}, "schedule.monday is a string"},
+		{"bad_notify_via", map[string]interface{}{"id": "2", "schedule": fixtureSchedule(), "notifyVia": "pigeon"}, `"pigeon" isn't a way`},
+		{"bad_tried_commands", map[string]interface{}{"id": "2", "schedule": fixtureSchedule(), "triedCommands": "skip"}, "triedCommands is a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			r, problems := decodeRecurser(tt.doc)
+			if !strings.Contains(strings.Join(problems, "; "), tt.problem) {
+				t.Errorf("got problems %v, wanted %q", problems, tt.problem)
+			}
+			if len(r.Schedule) != 7 || r.NotifyVia != NotifyViaZulip {
+				t.Errorf("didn't fall back to defaults: %+v", r)
+			}
+		})
+	}
+}
+
+func TestDecodeBrokenMatches(t *testing.T) {
+	tests := []struct {
+		testName string
+		doc      map[string]interface{}
+		problem  string
+	}{
+		{"good", map[string]interface{}{"date": fixtureTime, "ids": []interface{}{"2", "3"}, "names": []interface{}{"Ada", "Bea"},
+			"emails": []interface{}{"ada@example.com", "bea@example.com"}, "checkInSent": true,
+			"checkIns": map[string]interface{}{"2": map[string]interface{}{"happened": true, "rating": int64(5)}}}, ""},
+		{"no_check_ins", map[string]interface{}{"date": fixtureTime, "ids": []interface{}{"2", "3"}, "names": []interface{}{"Ada", "Bea"},
+			"emails": []interface{}{"ada@example.com", "bea@example.com"}}, ""},
+		{"date_not_time", map[string]interface{}{"date": "2024-03-01", "ids": []interface{}{"2", "3"}}, "date is a string"},
+		{"no_ids", map[string]interface{}{"date": fixtureTime}, "0 ids, not 2"},
+		{"three_ids", map[string]interface{}{"ids": []interface{}{"2", "3", "4"}, "names": []interface{}{"Ada", "Bea", "Cat"},
+			"emails": []interface{}{"a", "b", "c"}}, "3 ids, not 2"},
+		{"missing_name", map[string]interface{}{"ids": []interface{}{"2", "3"}, "names": []interface{}{"Ada"},
+			"emails": []interface{}{"a", "b"}}, "1 names and 2 emails for 2 ids"},
+		{"id_not_string", map[string]interface{}{"ids": []interface{}{"2", int64(3)}, "names": []interface{}{"Ada", "Bea"},
+			"emails": []interface{}{"a", "b"}}, "ids.1 is a int64"},
+		{"check_in_sent_not_bool", map[string]interface{}{"checkInSent": "yes"}, "checkInSent is a string"},
+		{"check_ins_not_map", map[string]interface{}{"checkIns": []interface{}{}}, "checkIns is a []interface {}"},
+		{"check_in_not_map", map[string]interface{}{"checkIns": map[string]interface{}{"2": true}}, "checkIns.2 is a bool"},
+		{"rating_not_number", map[string]interface{}{"checkIns": map[string]interface{}{"2": map[string]interface{}{"happened": true, "rating": "5"}}}, "checkIns.2.rating is a string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			m, problems := decodeMatch("m1", tt.doc)
+			if got := strings.Join(problems, "; "); (tt.problem == "") != (got == "") || !strings.Contains(got, tt.problem) {
+				t.Errorf("got problems %v, wanted %q", problems, tt.problem)
+			}
+			if m.ID != "m1" || m.CheckIns == nil {
+				t.Errorf("didn't fall back to defaults: %+v", m)
+			}
+		})
+	}
+}
+
+func TestMigrateDoc(t *testing.T) {
+	migrations := []migration{
+		func(doc map[string]interface{}) { doc["a"] = "a" },
+		func(doc map[string]interface{}) { doc["b"] = doc["a"].(string) + "b" },
+	}
+	tests := []struct {
+		testName string
+		doc      map[string]interface{}
+		wanted   map[string]interface{}
+	}{
+		{"unversioned", map[string]interface{}{}, map[string]interface{}{"a": "a", "b": "ab", "schemaVersion": 2}},
+		{"version_1", map[string]interface{}{"a": "x", "schemaVersion": int64(1)}, map[string]interface{}{"a": "x", "b": "xb", "schemaVersion": 2}},
+		{"current", map[string]interface{}{"schemaVersion": int64(2)}, map[string]interface{}{"schemaVersion": int64(2)}},
+		{"newer", map[string]interface{}{"c": "c", "schemaVersion": int64(3)}, map[string]interface{}{"c": "c", "schemaVersion": int64(3)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			migrateDoc(tt.doc, migrations)
+			if !reflect.DeepEqual(tt.doc, tt.wanted) {
+				t.Errorf("got %v, wanted %v", tt.doc, tt.wanted)
+			}
+		})
+	}
+}
+
+func TestMemoryRecurserDBMigrate(t *testing.T) {
+	ctx := context.Background()
+	rdb := NewMemoryRecurserDB()
+	for _, f := range recurserFixtures() {
+		doc := copyDoc(f.doc)
+		doc["id"] = f.shape
+		rdb.docs[f.shape] = doc
+	}
+	rdb.Offboard(ctx, "first_version", ReasonNoStay, fixtureTime)
+
+	// everything but version_1 needs it, including whoever's offboarded
+	if n, _ := rdb.Migrate(ctx, true); n != 7 {
+		t.Errorf("a dry run would migrate %d documents, wanted 7", n)
+	}
+	if v := schemaVersion(rdb.offboarded["first_version"]); v != 0 {
+		t.Errorf("a dry run migrated a document to version %d", v)
+	}
+
+	// reading someone migrates them on the way
+	rdb.GetByUserID(ctx, "odd_one_out", "ada@example.com", "Ada")
+	if n, _ := rdb.Migrate(ctx, false); n != 6 {
+		t.Errorf("migrated %d documents, wanted 6", n)
+	}
+	if n, _ := rdb.Migrate(ctx, false); n != 0 {
+		t.Errorf("migrated %d documents the second time", n)
+	}
+
+	// and everyone who's still subscribed is found by the same queries as a new subscriber
+	pairing, _ := rdb.ListPairingOn(ctx, "friday")
+	if len(pairing) != 7 {
+		t.Errorf("%d people are pairing on Friday, wanted 7", len(pairing))
+	}
+}